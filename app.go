@@ -6,31 +6,74 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
 )
 
-type appLockDelete struct {
+// appNameFlag handles the --app flag of the commands that act on a single app
+// or on many apps at once, keeping the name given in the flag so they can tell
+// whether it was used.
+type appNameFlag struct {
 	cmd.GuessingCommand
+	appName string
+}
+
+func (f *appNameFlag) flags() *gnuflag.FlagSet {
+	fs := gnuflag.NewFlagSet("", gnuflag.ExitOnError)
+	fs.StringVar(&f.appName, "app", "", "The name of the app.")
+	fs.StringVar(&f.appName, "a", "", "The name of the app.")
+	return fs
+}
+
+// Guess returns the name of the app given in the --app flag, guessing it
+// when the flag isn't used.
+func (f *appNameFlag) Guess() (string, error) {
+	if f.appName != "" {
+		return f.appName, nil
+	}
+	return f.GuessingCommand.Guess()
+}
+
+type appLockDelete struct {
+	appNameFlag
 	cmd.ConfirmationCommand
-	fs *gnuflag.FlagSet
+	all       bool
+	olderThan time.Duration
+	fs        *gnuflag.FlagSet
 }
 
 func (c *appLockDelete) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "app-unlock",
 		MinArgs: 0,
-		Usage:   "app-unlock -a <app-name> [-y]",
+		Usage:   "app-unlock [-a <app-name>] [--all] [--older-than <duration>] [-y]",
 		Desc: `Forces the removal of an application lock.
-Use with caution, removing an active lock may cause inconsistencies.`,
+Use with caution, removing an active lock may cause inconsistencies.
+
+Locks may be removed from many applications at once using the [[--all]] flag,
+which removes the locks from all locked applications, or the [[--older-than]]
+flag, which removes only the locks acquired longer than the given duration ago
+(e.g. 30m or 2h). A single confirmation is asked for all the applications.`,
 	}
 }
 
 func (c *appLockDelete) Run(ctx *cmd.Context, client *cmd.Client) error {
+	if c.all || c.olderThan > 0 {
+		return c.unlockMany(ctx, client)
+	}
 	appName, err := c.Guess()
 	if err != nil {
 		return err
@@ -38,6 +81,65 @@ func (c *appLockDelete) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if !c.Confirm(ctx, fmt.Sprintf(`Are you sure you want to remove the lock from app "%s"?`, appName)) {
 		return nil
 	}
+	err = removeAppLock(client, appName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.Stdout, "Lock successfully removed!\n")
+	return nil
+}
+
+func (c *appLockDelete) unlockMany(ctx *cmd.Context, client *cmd.Client) error {
+	if c.appName != "" {
+		return errors.New("the --app flag can't be combined with --all or --older-than")
+	}
+	apps, err := listLockedApps(client)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, a := range apps {
+		if time.Since(a.Lock.AcquireDate) >= c.olderThan {
+			names = append(names, a.Name)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(ctx.Stdout, "No locks to remove.")
+		return nil
+	}
+	if !c.Confirm(ctx, fmt.Sprintf("Are you sure you want to remove the lock from %d app(s) (%s)?", len(names), strings.Join(names, ", "))) {
+		return nil
+	}
+	var failed int
+	for _, name := range names {
+		err = removeAppLock(client, name)
+		if err != nil {
+			failed++
+			fmt.Fprintf(ctx.Stderr, "Failed to remove lock from app %q: %s\n", name, err)
+			continue
+		}
+		fmt.Fprintf(ctx.Stdout, "Lock removed from app %q.\n", name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove lock from %d app(s)", failed)
+	}
+	return nil
+}
+
+func (c *appLockDelete) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		fs := gnuflag.NewFlagSet("", gnuflag.ExitOnError)
+		fs.BoolVar(&c.all, "all", false, "Remove the locks from all locked applications.")
+		fs.DurationVar(&c.olderThan, "older-than", 0, "Remove only locks acquired longer than the given duration ago.")
+		c.fs = cmd.MergeFlagSet(
+			cmd.MergeFlagSet(c.appNameFlag.flags(), c.ConfirmationCommand.Flags()),
+			fs,
+		)
+	}
+	return c.fs
+}
+
+func removeAppLock(client *cmd.Client, appName string) error {
 	url, err := cmd.GetURL("/apps/" + appName + "/lock")
 	if err != nil {
 		return err
@@ -47,78 +149,444 @@ func (c *appLockDelete) Run(ctx *cmd.Context, client *cmd.Client) error {
 		return err
 	}
 	_, err = client.Do(request)
+	return err
+}
+
+type appLockList struct {
+	outputCommand
+}
+
+func (c *appLockList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "app-lock-list",
+		MinArgs: 0,
+		Usage:   "app-lock-list [-o <format>]",
+		Desc:    `Lists the locked applications, showing who holds each lock, why and since when.`,
+	}
+}
+
+func (c *appLockList) Run(ctx *cmd.Context, client *cmd.Client) error {
+	apps, err := listLockedApps(client)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(ctx.Stdout, "Lock successfully removed!\n")
-	return nil
+	locks := make([]appLock, len(apps))
+	for i, a := range apps {
+		locks[i] = appLock{App: a.Name, Lock: a.Lock}
+	}
+	return c.render(ctx.Stdout, locks, func() error {
+		if len(locks) == 0 {
+			fmt.Fprintln(ctx.Stdout, "No locked apps.")
+			return nil
+		}
+		table := cmd.NewTable()
+		table.Headers = cmd.Row([]string{"App", "Owner", "Reason", "Acquire Date"})
+		for _, l := range locks {
+			table.AddRow(cmd.Row([]string{l.App, l.Lock.Owner, l.Lock.Reason, l.Lock.AcquireDate.Format(time.RFC3339)}))
+		}
+		fmt.Fprint(ctx.Stdout, table.String())
+		return nil
+	})
 }
 
-func (c *appLockDelete) Flags() *gnuflag.FlagSet {
+type appLock struct {
+	App  string
+	Lock app.AppLock
+}
+
+// listLockedApps returns the locked apps, sorted by the lock acquire date.
+func listLockedApps(client *cmd.Client) ([]appSummary, error) {
+	apps, err := listApps(client, url.Values{"locked": []string{"true"}})
+	if err != nil {
+		return nil, err
+	}
+	var locked []appSummary
+	for _, a := range apps {
+		if a.Lock.Locked {
+			locked = append(locked, a)
+		}
+	}
+	sort.Sort(appsByLockDate(locked))
+	return locked, nil
+}
+
+type appsByLockDate []appSummary
+
+func (l appsByLockDate) Len() int      { return len(l) }
+func (l appsByLockDate) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l appsByLockDate) Less(i, j int) bool {
+	return l[i].Lock.AcquireDate.Before(l[j].Lock.AcquireDate)
+}
+
+// routesCommand holds the flags shared by the commands handling app routes,
+// which may act on a single app or on many apps at once.
+type routesCommand struct {
+	appNameFlag
+	all         bool
+	pool        string
+	router      string
+	concurrency int
+	retries     int
+	fs          *gnuflag.FlagSet
+}
+
+func (c *routesCommand) flags(action string) *gnuflag.FlagSet {
 	if c.fs == nil {
-		c.fs = cmd.MergeFlagSet(
-			c.GuessingCommand.Flags(),
-			c.ConfirmationCommand.Flags(),
-		)
+		fs := gnuflag.NewFlagSet("", gnuflag.ExitOnError)
+		fs.BoolVar(&c.all, "all", false, action+" for all applications.")
+		fs.StringVar(&c.pool, "pool", "", action+" for applications in the given pool.")
+		fs.StringVar(&c.router, "router", "", action+" for applications using the given router.")
+		fs.IntVar(&c.concurrency, "max-concurrency", 10, "Maximum number of applications handled at the same time.")
+		fs.IntVar(&c.retries, "retries", 3, "Number of times a transient failure is retried for each application.")
+		c.fs = cmd.MergeFlagSet(c.appNameFlag.flags(), fs)
 	}
 	return c.fs
 }
 
+func (c *routesCommand) multipleApps() bool {
+	return c.all || c.pool != "" || c.router != ""
+}
+
+// runAll calls the routes endpoint for all the selected apps, in parallel and
+// retrying transient failures.
+func (c *routesCommand) runAll(client *cmd.Client) ([]appRoutesResult, error) {
+	if c.appName != "" {
+		return nil, errors.New("the --app flag can't be combined with --all, --pool or --router")
+	}
+	apps, err := selectApps(client, c.pool, c.router)
+	if err != nil {
+		return nil, err
+	}
+	results := forEachApp(apps, c.concurrency, func(appName string) (*app.RebuildRoutesResult, error) {
+		var result *app.RebuildRoutesResult
+		err := retryTransient(c.retries, func() error {
+			var err error
+			result, err = rebuildRoutes(client, appName)
+			return err
+		})
+		return result, err
+	})
+	return results, nil
+}
+
 type appRoutesRebuild struct {
-	cmd.GuessingCommand
+	routesCommand
 }
 
 func (c *appRoutesRebuild) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "app-routes-rebuild",
 		MinArgs: 0,
-		Usage:   "app-routes-rebuild -a <app-name>",
+		Usage:   "app-routes-rebuild [-a <app-name>] [--all] [--pool <pool>] [--router <router>] [--max-concurrency <n>] [--retries <n>]",
 		Desc: `Rebuild routes for an application.
 This can be used to recover from some failure in the router that caused
-existing routes to be lost.`,
+existing routes to be lost.
+
+Routes may be rebuilt for many applications at once using the [[--all]] flag,
+or by selecting the applications in a pool with [[--pool]] or using a router
+with [[--router]], which includes the applications whose plan uses the default
+router. In this case, routes are rebuilt in parallel, limited by
+[[--max-concurrency]], and transient failures (network errors and gateway
+errors) are retried up to [[--retries]] times. A summary with the applications
+that had routes added or removed is displayed at the end.`,
 	}
 }
 
+func (c *appRoutesRebuild) Flags() *gnuflag.FlagSet {
+	return c.flags("Rebuild routes")
+}
+
 func (c *appRoutesRebuild) Run(ctx *cmd.Context, client *cmd.Client) error {
-	appName, err := c.Guess()
+	if !c.multipleApps() {
+		appName, err := c.Guess()
+		if err != nil {
+			return err
+		}
+		result, err := rebuildRoutes(client, appName)
+		if err != nil {
+			return err
+		}
+		writeRebuildResult(ctx.Stdout, result)
+		return nil
+	}
+	results, err := c.runAll(client)
 	if err != nil {
 		return err
 	}
+	if len(results) == 0 {
+		fmt.Fprintln(ctx.Stdout, "No apps found.")
+		return nil
+	}
+	changed, failed := writeRoutesSummary(ctx.Stdout, results, "Routes rebuilt", "Failed to rebuild routes")
+	fmt.Fprintf(ctx.Stdout, "\n%d app(s) processed: %d rebuilt, %d already correct, %d failed.\n",
+		len(results), changed, len(results)-changed-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("failed to rebuild routes for %d app(s)", failed)
+	}
+	return nil
+}
+
+// writeRoutesSummary writes a table with the apps that had routes added or
+// removed and a list with the failures, returning the number of each of them.
+func writeRoutesSummary(w io.Writer, results []appRoutesResult, changedTitle, failedTitle string) (changed, failed int) {
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"App", "Added", "Removed"})
+	table.LineSeparator = true
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			continue
+		}
+		if r.result != nil && (len(r.result.Added) > 0 || len(r.result.Removed) > 0) {
+			table.AddRow(cmd.Row([]string{r.app, strings.Join(r.result.Added, "\n"), strings.Join(r.result.Removed, "\n")}))
+		}
+	}
+	if table.Rows() > 0 {
+		fmt.Fprintf(w, "%s:\n%s", changedTitle, table.String())
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "%s:\n", failedTitle)
+		for _, r := range results {
+			if r.err != nil {
+				fmt.Fprintf(w, "- %s: %s\n", r.app, r.err)
+			}
+		}
+	}
+	return table.Rows(), failed
+}
+
+// rebuildRoutes rebuilds the routes of the app. The result is nil when the
+// response has no content, like under the --dry-run flag.
+func rebuildRoutes(client *cmd.Client, appName string) (*app.RebuildRoutesResult, error) {
 	url, err := cmd.GetURL("/apps/" + appName + "/routes")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	request, err := http.NewRequest("POST", url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	rsp, err := client.Do(request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
 	var rebuildResult app.RebuildRoutesResult
 	err = json.NewDecoder(rsp.Body).Decode(&rebuildResult)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &rebuildResult, nil
+}
+
+func writeRoutesList(w io.Writer, title string, routes []string) {
+	if len(routes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, route := range routes {
+		fmt.Fprintf(w, "- %s\n", route)
+	}
+}
+
+func writeRebuildResult(w io.Writer, rebuildResult *app.RebuildRoutesResult) {
+	if rebuildResult == nil {
+		return
+	}
+	writeRoutesList(w, "Added routes", rebuildResult.Added)
+	writeRoutesList(w, "Removed routes", rebuildResult.Removed)
+	if len(rebuildResult.Added) > 0 || len(rebuildResult.Removed) > 0 {
+		fmt.Fprintf(w, "\nRoutes successfully rebuilt!\n")
+	} else {
+		fmt.Fprintf(w, "Nothing to do, routes already correct.\n")
+	}
+}
+
+// appSummary holds the fields used by tsuru-admin from the app list and app
+// info endpoints.
+type appSummary struct {
+	Name string
+	Pool string
+	Lock app.AppLock
+	Plan struct {
+		Router string
+	}
+}
+
+func listApps(client *cmd.Client, filter url.Values) ([]appSummary, error) {
+	path := "/apps"
+	if len(filter) > 0 {
+		path += "?" + filter.Encode()
+	}
+	u, err := cmd.GetURL(path)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var apps []appSummary
+	err = json.NewDecoder(rsp.Body).Decode(&apps)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+func getApp(client *cmd.Client, appName string) (*appSummary, error) {
+	u, err := cmd.GetURL("/apps/" + appName)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	var a appSummary
+	err = json.NewDecoder(rsp.Body).Decode(&a)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// selectApps returns the sorted names of the apps in the given pool (or in
+// all pools, when pool is empty) using the given router. The app list
+// doesn't include the plan, so filtering by router requires fetching each
+// app.
+func selectApps(client *cmd.Client, pool, router string) ([]string, error) {
+	filter := url.Values{}
+	if pool != "" {
+		filter.Set("pool", pool)
+	}
+	apps, err := listApps(client, filter)
+	if err != nil {
+		return nil, err
 	}
-	rebuilt := len(rebuildResult.Added) > 0 || len(rebuildResult.Removed) > 0
-	if len(rebuildResult.Added) > 0 {
-		fmt.Fprintf(ctx.Stdout, "Added routes:\n")
-		for _, added := range rebuildResult.Added {
-			fmt.Fprintf(ctx.Stdout, "- %s\n", added)
+	var names []string
+	var defaultName string
+	for _, a := range apps {
+		if router != "" {
+			info, err := getApp(client, a.Name)
+			if err != nil {
+				return nil, err
+			}
+			appRouter := info.Plan.Router
+			if appRouter == "" {
+				if defaultName == "" {
+					if defaultName, err = defaultRouter(client); err != nil {
+						return nil, err
+					}
+				}
+				appRouter = defaultName
+			}
+			if appRouter != router {
+				continue
+			}
 		}
+		names = append(names, a.Name)
 	}
-	if len(rebuildResult.Removed) > 0 {
-		fmt.Fprintf(ctx.Stdout, "Removed routes:\n")
-		for _, removed := range rebuildResult.Removed {
-			fmt.Fprintf(ctx.Stdout, "- %s\n", removed)
+	sort.Strings(names)
+	return names, nil
+}
+
+// defaultRouter returns the router used by the apps whose plan has no router:
+// the one flagged as default by the tsuru API or, when none is flagged, the
+// only router available.
+func defaultRouter(client *cmd.Client) (string, error) {
+	var routers []struct {
+		Name    string
+		Default bool
+	}
+	if err := getJSON(client, "/plans/routers", &routers); err != nil {
+		return "", err
+	}
+	for _, r := range routers {
+		if r.Default {
+			return r.Name, nil
 		}
 	}
-	if rebuilt {
-		fmt.Fprintf(ctx.Stdout, "\nRoutes successfully rebuilt!\n")
-	} else {
-		fmt.Fprintf(ctx.Stdout, "Nothing to do, routes already correct.\n")
+	if len(routers) == 1 {
+		return routers[0].Name, nil
 	}
-	return nil
+	return "", errors.New("unable to find the default router, used by apps whose plan has no router")
+}
+
+type appRoutesResult struct {
+	app    string
+	result *app.RebuildRoutesResult
+	err    error
+}
+
+// forEachApp calls fn for each app, with at most concurrency calls running at
+// the same time. Results are returned in the same order as the apps.
+func forEachApp(apps []string, concurrency int, fn func(appName string) (*app.RebuildRoutesResult, error)) []appRoutesResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]appRoutesResult, len(apps))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				result, err := fn(apps[idx])
+				results[idx] = appRoutesResult{app: apps[idx], result: result, err: err}
+			}
+		}()
+	}
+	for i := range apps {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// retryTransient calls fn until it succeeds, fails with an error that isn't
+// transient or the number of retries is exhausted. Network failures and
+// gateway errors are considered transient.
+func retryTransient(retries int, fn func() error) error {
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= retries || !isTransient(err) {
+			return err
+		}
+		time.Sleep(backoffDelay(i))
+	}
+}
+
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *tsuruErrors.HTTP:
+		switch e.Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	case *url.Error, net.Error:
+		return true
+	}
+	// The client replaces network errors with this message.
+	return strings.HasPrefix(err.Error(), "Failed to connect to tsuru server") &&
+		strings.HasSuffix(err.Error(), "it's probably down.")
 }
@@ -7,12 +7,16 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/cmd/cmdtest"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
 	"gopkg.in/check.v1"
 )
 
@@ -51,6 +55,186 @@ func (s *S) TestAppLockDeleteRunAsksConfirmation(c *check.C) {
 	c.Assert(stdout.String(), check.Equals, "Are you sure you want to remove the lock from app \"app1\"? (y/n) Abort.\n")
 }
 
+func (s *S) TestAppLockDeleteRunOlderThan(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	recent := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	apps := `[{"name":"app1","lock":{"Locked":true,"Owner":"admin","Reason":"deploy","AcquireDate":"2016-01-02T10:00:00Z"}},
+{"name":"app2","lock":{"Locked":true,"Owner":"admin","Reason":"deploy","AcquireDate":"` + recent + `"}},
+{"name":"app3","lock":{"Locked":true,"Owner":"admin","Reason":"restart","AcquireDate":"2016-01-01T10:00:00Z"}}]`
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: apps, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps") && req.URL.Query().Get("locked") == "true" && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app3/lock") && req.Method == "DELETE"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app1/lock") && req.Method == "DELETE"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockDelete{}
+	command.Flags().Parse(true, []string{"--older-than", "30m", "-y"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `Lock removed from app "app3".
+Lock removed from app "app1".
+`)
+}
+
+func (s *S) TestAppLockDeleteRunAllAsksConfirmation(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("n\n"),
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `[{"name":"app1","lock":{"Locked":true,"AcquireDate":"2016-01-02T10:00:00Z"}},{"name":"app2","lock":{"Locked":true,"AcquireDate":"2016-01-01T10:00:00Z"}}]`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockDelete{}
+	command.Flags().Parse(true, []string{"--all"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Are you sure you want to remove the lock from 2 app(s) (app2, app1)? (y/n) Abort.\n")
+}
+
+func (s *S) TestAppLockDeleteRunAllWithFailure(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"app1","lock":{"Locked":true,"AcquireDate":"2016-01-01T10:00:00Z"}},{"name":"app2","lock":{"Locked":true,"AcquireDate":"2016-01-02T10:00:00Z"}}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "app not found", Status: http.StatusNotFound},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app1/lock") && req.Method == "DELETE"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app2/lock") && req.Method == "DELETE"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockDelete{}
+	command.Flags().Parse(true, []string{"--all", "-y"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `failed to remove lock from 1 app\(s\)`)
+	c.Assert(stdout.String(), check.Equals, "Lock removed from app \"app2\".\n")
+	c.Assert(stderr.String(), check.Equals, "Failed to remove lock from app \"app1\": app not found\n")
+}
+
+func (s *S) TestAppLockDeleteRunOlderThanNothingToRemove(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusNoContent},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockDelete{}
+	command.Flags().Parse(true, []string{"--older-than", "1h"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No locks to remove.\n")
+}
+
+func (s *S) TestAppLockDeleteRunWithoutFlags(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusNoContent},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockDelete{all: true}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No locks to remove.\n")
+}
+
+func (s *S) TestAppLockListRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	apps := `[{"name":"app1","lock":{"Locked":true,"Owner":"admin@example.com","Reason":"POST /apps/app1/deploy","AcquireDate":"2016-01-02T10:00:00Z"}},
+{"name":"app2","lock":{"Locked":true,"Owner":"user@example.com","Reason":"POST /apps/app2/restart","AcquireDate":"2016-01-01T10:00:00Z"}}]`
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: apps, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/apps") && req.URL.Query().Get("locked") == "true" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockList{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `+------+-------------------+-------------------------+----------------------+
+| App  | Owner             | Reason                  | Acquire Date         |
++------+-------------------+-------------------------+----------------------+
+| app2 | user@example.com  | POST /apps/app2/restart | 2016-01-01T10:00:00Z |
+| app1 | admin@example.com | POST /apps/app1/deploy  | 2016-01-02T10:00:00Z |
++------+-------------------+-------------------------+----------------------+
+`)
+}
+
+func (s *S) TestAppLockListRunNoLocks(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.Transport{Message: "", Status: http.StatusNoContent}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockList{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No locked apps.\n")
+}
+
 func (s *S) TestAppRoutesRebuildRun(c *check.C) {
 	var stdout, stderr bytes.Buffer
 	context := cmd.Context{
@@ -106,3 +290,299 @@ func (s *S) TestAppRoutesRebuildRunNothingToDo(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(stdout.String(), check.Equals, "Nothing to do, routes already correct.\n")
 }
+
+func (s *S) TestAppRoutesRebuildRunPool(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"app2"},{"name":"app1"},{"name":"app3"}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps") && req.URL.Query().Get("pool") == "pool1" && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"Added":["r1","r2"],"Removed":["r9"]}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app1/routes") && req.Method == "POST"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app2/routes") && req.Method == "POST"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "service unavailable", Status: http.StatusServiceUnavailable},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app3/routes") && req.Method == "POST"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"Removed":["r3"]}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app3/routes") && req.Method == "POST"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--pool", "pool1", "--max-concurrency", "1"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `Routes rebuilt:
++------+-------+---------+
+| App  | Added | Removed |
++------+-------+---------+
+| app1 | r1    | r9      |
+|      | r2    |         |
++------+-------+---------+
+| app3 |       | r3      |
++------+-------+---------+
+
+3 app(s) processed: 2 rebuilt, 1 already correct, 0 failed.
+`)
+}
+
+func (s *S) TestAppRoutesRebuildRunRouterWithFailures(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"app1"},{"name":"app2"}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps") && req.URL.RawQuery == "" && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"name":"app1","plan":{"router":"hipache"}}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app1") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"name":"app2","plan":{"router":"galeb"}}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app2") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "bad gateway", Status: http.StatusBadGateway},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app2/routes") && req.Method == "POST"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "router error", Status: http.StatusInternalServerError},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app2/routes") && req.Method == "POST"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--router", "galeb"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `failed to rebuild routes for 1 app\(s\)`)
+	c.Assert(stdout.String(), check.Equals, `Failed to rebuild routes:
+- app2: router error
+
+1 app(s) processed: 0 rebuilt, 0 already correct, 1 failed.
+`)
+}
+
+func (s *S) TestAppRoutesRebuildRunDefaultRouter(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"app1"},{"name":"app2"}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"name":"app1","plan":{}}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app1") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"galeb","type":"galeb"},{"name":"hipache","type":"hipache","default":true}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/plans/routers") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"name":"app2","plan":{"router":"galeb"}}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app2") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"Added":["r1"]}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app1/routes") && req.Method == "POST"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--router", "hipache"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Matches, `(?s)Routes rebuilt:.*\| app1 \| r1 .*\n1 app\(s\) processed: 1 rebuilt, 0 already correct, 0 failed.\n`)
+}
+
+func (s *S) TestAppRoutesRebuildRunUnknownDefaultRouter(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"app1"}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"name":"app1","plan":{}}`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/app1") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"galeb","type":"galeb"},{"name":"hipache","type":"hipache"}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/plans/routers") && req.Method == "GET"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--router", "hipache"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, "unable to find the default router, used by apps whose plan has no router")
+}
+
+func (s *S) TestIsTransient(c *check.C) {
+	c.Assert(isTransient(&tsuruErrors.HTTP{Code: http.StatusBadGateway}), check.Equals, true)
+	c.Assert(isTransient(&tsuruErrors.HTTP{Code: http.StatusServiceUnavailable}), check.Equals, true)
+	c.Assert(isTransient(&tsuruErrors.HTTP{Code: http.StatusGatewayTimeout}), check.Equals, true)
+	c.Assert(isTransient(&tsuruErrors.HTTP{Code: http.StatusConflict}), check.Equals, false)
+	c.Assert(isTransient(&tsuruErrors.HTTP{Code: http.StatusInternalServerError}), check.Equals, false)
+	c.Assert(isTransient(&url.Error{Op: "Post", URL: "http://localhost", Err: errors.New("connection refused")}), check.Equals, true)
+	c.Assert(isTransient(errors.New("Failed to connect to tsuru server (http://localhost), it's probably down.")), check.Equals, true)
+	c.Assert(isTransient(errors.New("unexpected EOF")), check.Equals, false)
+}
+
+func (s *S) TestAppRoutesRebuildRunAllRetriesExhausted(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	var calls int
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "unavailable", Status: http.StatusServiceUnavailable},
+		CondFunc: func(req *http.Request) bool {
+			if strings.HasSuffix(req.URL.Path, "/apps/app1/routes") && req.Method == "POST" {
+				calls++
+				return true
+			}
+			return false
+		},
+	}
+	listTrans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `[{"name":"app1"}]`, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+				},
+			},
+			*trans, *trans, *trans,
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: listTrans}, nil, manager)
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--all", "--retries", "2"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.NotNil)
+	c.Assert(calls, check.Equals, 3)
+	c.Assert(stdout.String(), check.Matches, `(?s)Failed to rebuild routes:
+- app1: unavailable
+.*1 failed.
+`)
+}
+
+func (s *S) TestAppRoutesRebuildRunNoApps(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusNoContent},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--all"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No apps found.\n")
+}
+
+func (s *S) TestAppRoutesRebuildRunWithoutFlags(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusNoContent},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRoutesRebuild{routesCommand{all: true}}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No apps found.\n")
+}
+
+func (s *S) TestAppRoutesRebuildRunAppWithAll(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--all", "-a", "app1"})
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, "the --app flag can't be combined with --all, --pool or --router")
+}
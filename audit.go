@@ -0,0 +1,563 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+const (
+	auditLogEnv        = "TSURU_ADMIN_AUDIT_LOG"
+	auditLogMaxSizeEnv = "TSURU_ADMIN_AUDIT_LOG_MAX_SIZE"
+	// defaultAuditLogMaxSize is the size of the audit file that triggers its
+	// rotation.
+	defaultAuditLogMaxSize = 10 << 20
+	// auditLogBackups is the number of rotated files kept, named after the
+	// audit file with the suffixes ".1" (the newest) to ".3" (the oldest).
+	auditLogBackups = 3
+	redactedValue   = "*****"
+)
+
+// sensitiveParam matches the names of parameters whose values are never
+// written to the audit log.
+var sensitiveParam = regexp.MustCompile(`(?i)pass|token|secret|key|credential|auth`)
+
+// auditEntry is a line of the audit log, describing a request that changed
+// data in the tsuru server.
+type auditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user"`
+	Target    string    `json:"target"`
+	Command   string    `json:"command"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Body      string    `json:"body,omitempty"`
+	Status    int       `json:"status"`
+	Duration  string    `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLog appends entries as JSON lines to a file, rotating it when it
+// reaches maxSize bytes.
+type auditLog struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// unescapeHTML undoes the escaping of <, > and & done by json.Marshal, so
+// request bodies and command lines are readable in the log.
+func unescapeHTML(data []byte) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' || i+1 == len(data) {
+			buf.WriteByte(data[i])
+			continue
+		}
+		rest := data[i+1:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("u003c")):
+			buf.WriteByte('<')
+		case bytes.HasPrefix(rest, []byte("u003e")):
+			buf.WriteByte('>')
+		case bytes.HasPrefix(rest, []byte("u0026")):
+			buf.WriteByte('&')
+		default:
+			buf.Write(data[i : i+2])
+			i++
+			continue
+		}
+		i += 5
+	}
+	return buf.Bytes()
+}
+
+func (l *auditLog) write(entry auditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(unescapeHTML(data), '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if info, err := os.Stat(l.path); err == nil && info.Size() > 0 && info.Size()+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	return err
+}
+
+func (l *auditLog) rotate() error {
+	for i := auditLogBackups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.path+".1")
+}
+
+// files returns the existing audit files, from the oldest to the newest.
+func (l *auditLog) files() []string {
+	var files []string
+	for i := auditLogBackups; i >= 0; i-- {
+		path := l.path
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", l.path, i)
+		}
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// entries reads all the entries in the audit files, from the oldest to the
+// newest.
+func (l *auditLog) entries() ([]auditEntry, error) {
+	var entries []auditEntry
+	for _, path := range l.files() {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry auditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// auditTransport writes an entry to the audit log for each request that
+// changes data in the tsuru server. Failing to write the entry doesn't fail
+// the request.
+type auditTransport struct {
+	transport   http.RoundTripper
+	log         *auditLog
+	commandLine []string
+	// flags are the flags of the command in the command line, used for
+	// redacting the values of the sensitive ones.
+	flags  *gnuflag.FlagSet
+	stderr io.Writer
+	mu     sync.Mutex
+}
+
+// setCommand changes the command recorded in the entries, keeping the name of
+// the program, as batch and shell run many commands in the same process.
+func (t *auditTransport) setCommand(args []string, flags *gnuflag.FlagSet) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	program := "tsuru-admin"
+	if len(t.commandLine) > 0 {
+		program = t.commandLine[0]
+	}
+	t.commandLine = append([]string{program}, args...)
+	t.flags = flags
+}
+
+func (t *auditTransport) command() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return redactCommandLine(t.commandLine, t.flags)
+}
+
+// auditTransportOf returns the audit transport used by the client, or nil when
+// the requests aren't audited.
+func auditTransportOf(client *http.Client) *auditTransport {
+	if client == nil {
+		return nil
+	}
+	transport := client.Transport
+	if dryRun, ok := transport.(*dryRunTransport); ok {
+		transport = dryRun.transport
+	}
+	audit, _ := transport.(*auditTransport)
+	return audit
+}
+
+// auditCommand records the command about to run in the audit entries of the
+// requests made by the client, along with its flags.
+func auditCommand(client *http.Client, commands map[string]cmd.Command, args []string) {
+	audit := auditTransportOf(client)
+	if audit == nil {
+		return
+	}
+	var flags *gnuflag.FlagSet
+	if len(args) > 0 {
+		if registered, ok := commands[args[0]]; ok {
+			// A fresh copy is used, so the flags of the command that runs
+			// aren't built twice.
+			if flagged, ok := freshCommand(registered).(cmd.FlaggedCommand); ok {
+				flags = flagged.Flags()
+			}
+		}
+	}
+	audit.setCommand(args, flags)
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return t.transport.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	entry := auditEntry{
+		Timestamp: start,
+		User:      currentUser(),
+		Target:    auditTarget(req.URL),
+		Command:   t.command(),
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Body:      redactBody(req.Header.Get("Content-Type"), body),
+		Duration:  time.Since(start).String(),
+	}
+	if req.URL.RawQuery != "" {
+		entry.Path += "?" + redactValues(req.URL.Query()).Encode()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Status = resp.StatusCode
+	}
+	if logErr := t.log.write(entry); logErr != nil {
+		fmt.Fprintf(t.stderr, "WARNING: failed to write the audit log: %s\n", logErr)
+	}
+	return resp, err
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func auditTarget(u *url.URL) string {
+	if target, err := cmd.ReadTarget(); err == nil {
+		return target
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// redactBody returns the body of a request with the values of sensitive
+// parameters replaced. Files in multipart bodies are replaced by their names
+// and sizes.
+func redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return redactValues(values).Encode()
+		}
+	case strings.HasPrefix(mediaType, "multipart/"):
+		values := url.Values{}
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := ioutil.ReadAll(part)
+			if part.FileName() != "" {
+				values.Add(part.FormName(), fmt.Sprintf("file %q (%d bytes)", part.FileName(), len(data)))
+			} else {
+				values.Add(part.FormName(), string(data))
+			}
+		}
+		return redactValues(values).Encode()
+	case mediaType == "application/json":
+		var data interface{}
+		if err := json.Unmarshal(body, &data); err == nil {
+			if encoded, err := json.Marshal(redactJSON(data)); err == nil {
+				return string(encoded)
+			}
+		}
+	}
+	return string(body)
+}
+
+// redactValues returns the values with the sensitive parameters replaced.
+// Besides the parameters with sensitive keys, parameters sent as name and
+// value pairs, like the data of machine templates (Data.0.Name and
+// Data.0.Value), are redacted when the name is sensitive.
+func redactValues(values url.Values) url.Values {
+	sensitivePairs := make(map[string]bool)
+	for key, list := range values {
+		lower := strings.ToLower(key)
+		if !strings.HasSuffix(lower, ".name") {
+			continue
+		}
+		for _, name := range list {
+			if sensitiveParam.MatchString(name) {
+				sensitivePairs[strings.TrimSuffix(lower, ".name")] = true
+			}
+		}
+	}
+	result := make(url.Values, len(values))
+	for key, list := range values {
+		lower := strings.ToLower(key)
+		if sensitiveParam.MatchString(key) ||
+			(strings.HasSuffix(lower, ".value") && sensitivePairs[strings.TrimSuffix(lower, ".value")]) {
+			list = []string{redactedValue}
+		}
+		result[key] = list
+	}
+	return result
+}
+
+// redactCommandLine joins the command line, replacing the values of the
+// name=value arguments whose names are sensitive, like the parameters of
+// machine templates, and the values of the sensitive flags, given either as
+// --password=value or as --password value. Short flags are sensitive when
+// they're aliases of sensitive flags of the command, given by flags.
+func redactCommandLine(args []string, flags *gnuflag.FlagSet) string {
+	redacted := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 {
+			if sensitiveParam.MatchString(parts[0]) || (strings.HasPrefix(arg, "-") && isSensitiveFlag(flags, strings.TrimLeft(parts[0], "-"))) {
+				arg = parts[0] + "=" + redactedValue
+			}
+			redacted = append(redacted, arg)
+			continue
+		}
+		redacted = append(redacted, arg)
+		name := strings.TrimLeft(arg, "-")
+		if name == arg || name == "" || i+1 == len(args) || !isSensitiveFlag(flags, name) {
+			continue
+		}
+		if flags != nil {
+			if flag := flags.Lookup(name); flag != nil && isBoolFlag(flag) {
+				continue
+			}
+		}
+		redacted = append(redacted, redactedValue)
+		i++
+	}
+	return strings.Join(redacted, " ")
+}
+
+// isSensitiveFlag reports whether the flag has a sensitive name or is an
+// alias, sharing the same value, of a flag with a sensitive name.
+func isSensitiveFlag(flags *gnuflag.FlagSet, name string) bool {
+	if sensitiveParam.MatchString(name) {
+		return true
+	}
+	if flags == nil {
+		return false
+	}
+	flag := flags.Lookup(name)
+	if flag == nil {
+		return false
+	}
+	value := reflect.ValueOf(flag.Value)
+	if value.Kind() != reflect.Ptr {
+		return false
+	}
+	var sensitive bool
+	flags.VisitAll(func(other *gnuflag.Flag) {
+		otherValue := reflect.ValueOf(other.Value)
+		if otherValue.Kind() == reflect.Ptr && otherValue.Pointer() == value.Pointer() && sensitiveParam.MatchString(other.Name) {
+			sensitive = true
+		}
+	})
+	return sensitive
+}
+
+func redactJSON(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if sensitiveParam.MatchString(key) {
+				value[key] = redactedValue
+			} else {
+				value[key] = redactJSON(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactJSON(item)
+		}
+	}
+	return data
+}
+
+// parseSize parses a size in bytes, optionally followed by K, M or G for
+// kilobytes, megabytes or gigabytes.
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("%q is not a valid size", value)
+	}
+	return size * multiplier, nil
+}
+
+func defaultAuditLogPath() string {
+	if path := os.Getenv(auditLogEnv); path != "" {
+		return path
+	}
+	return cmd.JoinWithUserDir(".tsuru", "admin-audit.log")
+}
+
+type auditLogCommand struct {
+	outputCommand
+	file   string
+	user   string
+	target string
+	method string
+	grep   string
+	since  time.Duration
+	failed bool
+}
+
+func (c *auditLogCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "audit-log",
+		Usage:   "audit-log [--file <path>] [--user <user>] [--target <target>] [--method <method>] [--since <duration>] [--grep <text>] [--failed] [-o <format>]",
+		MinArgs: 0,
+		Desc: `Searches the local audit log, which records the requests that changed data
+in the tsuru server.
+
+The audit log is written when the [[--audit-log]] global flag or the
+TSURU_ADMIN_AUDIT_LOG environment variable is set, and is searched including
+its rotated files. The [[--grep]] flag matches the command line, the path and
+the body of the requests.`,
+	}
+}
+
+func (c *auditLogCommand) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.outputCommand.Flags()
+		c.fs.StringVar(&c.file, "file", defaultAuditLogPath(), "Path to the audit log file.")
+		c.fs.StringVar(&c.user, "user", "", "Only show requests made by the given OS user.")
+		c.fs.StringVar(&c.target, "target", "", "Only show requests sent to targets containing the given text.")
+		c.fs.StringVar(&c.method, "method", "", "Only show requests with the given HTTP method.")
+		c.fs.DurationVar(&c.since, "since", 0, "Only show requests made in the given period, e.g. 24h.")
+		c.fs.StringVar(&c.grep, "grep", "", "Only show requests containing the given text.")
+		c.fs.BoolVar(&c.failed, "failed", false, "Only show failed requests.")
+	}
+	return c.fs
+}
+
+func (c *auditLogCommand) match(entry auditEntry) bool {
+	switch {
+	case c.user != "" && entry.User != c.user,
+		c.target != "" && !strings.Contains(entry.Target, c.target),
+		c.method != "" && !strings.EqualFold(entry.Method, c.method),
+		c.since > 0 && time.Since(entry.Timestamp) > c.since,
+		c.failed && entry.Error == "" && entry.Status < http.StatusBadRequest:
+		return false
+	case c.grep != "":
+		return strings.Contains(entry.Command, c.grep) ||
+			strings.Contains(entry.Path, c.grep) ||
+			strings.Contains(entry.Body, c.grep)
+	}
+	return true
+}
+
+func (c *auditLogCommand) Run(context *cmd.Context, client *cmd.Client) error {
+	log := auditLog{path: c.file}
+	all, err := log.entries()
+	if err != nil {
+		return err
+	}
+	entries := []auditEntry{}
+	for _, entry := range all {
+		if c.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return c.render(context.Stdout, entries, func() error {
+		if len(entries) == 0 {
+			fmt.Fprintln(context.Stdout, "No audit entries found.")
+			return nil
+		}
+		table := cmd.NewTable()
+		table.Headers = cmd.Row([]string{"Time", "User", "Target", "Command", "Request", "Status", "Duration"})
+		for _, entry := range entries {
+			status := strconv.Itoa(entry.Status)
+			if entry.Error != "" {
+				status = entry.Error
+			}
+			table.AddRow(cmd.Row([]string{
+				entry.Timestamp.Local().Format(time.RFC3339),
+				entry.User,
+				entry.Target,
+				entry.Command,
+				entry.Method + " " + entry.Path,
+				status,
+				entry.Duration,
+			}))
+		}
+		fmt.Fprint(context.Stdout, table.String())
+		return nil
+	})
+}
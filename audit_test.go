@@ -0,0 +1,311 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func (s *S) TestAuditLogWriteAndRotate(c *check.C) {
+	path := filepath.Join(c.MkDir(), "logs", "audit.log")
+	log := auditLog{path: path, maxSize: 150}
+	for i := 0; i < 6; i++ {
+		err := log.write(auditEntry{Method: "POST", Path: "/1.0/plans", Status: 200 + i})
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(log.files(), check.DeepEquals, []string{path + ".3", path + ".2", path + ".1", path})
+	entries, err := log.entries()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 4)
+	for i, entry := range entries {
+		c.Assert(entry.Status, check.Equals, 202+i)
+	}
+	info, err := os.Stat(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Mode().Perm(), check.Equals, os.FileMode(0600))
+}
+
+func (s *S) TestAuditLogWriteDoesNotEscapeHTML(c *check.C) {
+	path := filepath.Join(c.MkDir(), "audit.log")
+	log := auditLog{path: path, maxSize: defaultAuditLogMaxSize}
+	err := log.write(auditEntry{Method: "POST", Path: "/1.0/plans", Body: `name=<small>&note=\u003c`})
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(string(data), `"body":"name=<small>&note=\\u003c"`), check.Equals, true)
+	entries, err := log.entries()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Body, check.Equals, `name=<small>&note=\u003c`)
+}
+
+func (s *S) TestAuditLogEntriesNoFile(c *check.C) {
+	log := auditLog{path: filepath.Join(c.MkDir(), "audit.log")}
+	entries, err := log.entries()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 0)
+}
+
+func (s *S) TestAuditTransport(c *check.C) {
+	path := filepath.Join(c.MkDir(), "audit.log")
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusCreated},
+		CondFunc: func(req *http.Request) bool {
+			if req.Method == "GET" {
+				return true
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			return string(body) == "name=plan1&password=s3cret"
+		},
+	}
+	var stderr bytes.Buffer
+	transport := &auditTransport{
+		transport:   trans,
+		log:         &auditLog{path: path, maxSize: defaultAuditLogMaxSize},
+		commandLine: []string{"tsuru-admin", "plan-create", "plan1"},
+		stderr:      &stderr,
+	}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("http://localhost/1.0/plans")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusCreated)
+	resp, err = client.Post("http://localhost/1.0/plans?token=abc", "application/x-www-form-urlencoded", strings.NewReader("name=plan1&password=s3cret"))
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusCreated)
+	entries, err := transport.log.entries()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	entry := entries[0]
+	c.Assert(entry.User, check.Equals, currentUser())
+	c.Assert(entry.Target, check.Equals, "http://localhost")
+	c.Assert(entry.Command, check.Equals, "tsuru-admin plan-create plan1")
+	c.Assert(entry.Method, check.Equals, "POST")
+	c.Assert(entry.Path, check.Equals, "/1.0/plans?token=%2A%2A%2A%2A%2A")
+	c.Assert(entry.Body, check.Equals, "name=plan1&password=%2A%2A%2A%2A%2A")
+	c.Assert(entry.Status, check.Equals, http.StatusCreated)
+	c.Assert(entry.Error, check.Equals, "")
+	_, err = time.ParseDuration(entry.Duration)
+	c.Assert(err, check.IsNil)
+	c.Assert(time.Since(entry.Timestamp) < time.Minute, check.Equals, true)
+	c.Assert(stderr.String(), check.Equals, "")
+}
+
+func (s *S) TestAuditTransportNetworkError(c *check.C) {
+	path := filepath.Join(c.MkDir(), "audit.log")
+	transport := &auditTransport{
+		transport: failingTransport{},
+		log:       &auditLog{path: path, maxSize: defaultAuditLogMaxSize},
+	}
+	req, err := http.NewRequest("DELETE", "http://localhost/1.0/plans/plan1", nil)
+	c.Assert(err, check.IsNil)
+	_, err = transport.RoundTrip(req)
+	c.Assert(err, check.ErrorMatches, "connection reset by peer")
+	entries, err := transport.log.entries()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+	c.Assert(entries[0].Status, check.Equals, 0)
+	c.Assert(entries[0].Error, check.Equals, "connection reset by peer")
+}
+
+func (s *S) TestAuditTransportWriteFailure(c *check.C) {
+	dir := c.MkDir()
+	var stderr bytes.Buffer
+	transport := &auditTransport{
+		transport: &cmdtest.Transport{Message: "", Status: http.StatusOK},
+		log:       &auditLog{path: dir, maxSize: defaultAuditLogMaxSize},
+		stderr:    &stderr,
+	}
+	req, err := http.NewRequest("DELETE", "http://localhost/1.0/plans/plan1", nil)
+	c.Assert(err, check.IsNil)
+	resp, err := transport.RoundTrip(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(stderr.String(), check.Matches, "WARNING: failed to write the audit log: .*\n")
+}
+
+func (s *S) TestRedactBody(c *check.C) {
+	c.Assert(redactBody("", nil), check.Equals, "")
+	c.Assert(redactBody("application/json", []byte(`{"name":"n1","config":{"secret-key":"x"},"list":[{"token":"y"}]}`)),
+		check.Equals, `{"config":{"secret-key":"*****"},"list":[{"token":"*****"}],"name":"n1"}`)
+	c.Assert(redactBody("text/plain", []byte("raw body")), check.Equals, "raw body")
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	writer.WriteField("name", "python")
+	part, err := writer.CreateFormFile("dockerfile_content", "Dockerfile")
+	c.Assert(err, check.IsNil)
+	part.Write([]byte("FROM ubuntu"))
+	writer.Close()
+	c.Assert(redactBody(writer.FormDataContentType(), buf.Bytes()), check.Equals,
+		"dockerfile_content=file+%22Dockerfile%22+%2811+bytes%29&name=python")
+}
+
+func (s *S) TestRedactBodyNamedValues(c *check.C) {
+	body := "Name=tpl1&IaaSName=ec2&Data.0.Name=secret-key&Data.0.Value=XYZ&Data.1.Name=region&Data.1.Value=us-east-1"
+	c.Assert(redactBody("application/x-www-form-urlencoded", []byte(body)), check.Equals,
+		"Data.0.Name=secret-key&Data.0.Value=%2A%2A%2A%2A%2A&Data.1.Name=region&Data.1.Value=us-east-1&IaaSName=ec2&Name=tpl1")
+}
+
+func (s *S) TestRedactCommandLine(c *check.C) {
+	args := []string{"tsuru-admin", "machine-template-add", "tpl1", "ec2", "secret-key=XYZ", "region=us-east-1", "--password=s3cret", "key"}
+	c.Assert(redactCommandLine(args, nil), check.Equals,
+		"tsuru-admin machine-template-add tpl1 ec2 secret-key=***** region=us-east-1 --password=***** key")
+}
+
+func (s *S) TestRedactCommandLineFlagValues(c *check.C) {
+	args := []string{"tsuru-admin", "user-create", "--password", "s3cret", "--name", "john", "--token"}
+	c.Assert(redactCommandLine(args, nil), check.Equals,
+		"tsuru-admin user-create --password ***** --name john --token")
+}
+
+func (s *S) TestRedactCommandLineFlagAliases(c *check.C) {
+	var password, pool string
+	var secretOnly bool
+	flags := gnuflag.NewFlagSet("", gnuflag.ContinueOnError)
+	flags.StringVar(&password, "password", "", "")
+	flags.StringVar(&password, "p", "", "")
+	flags.StringVar(&pool, "pool", "", "")
+	flags.StringVar(&pool, "o", "", "")
+	flags.BoolVar(&secretOnly, "secret-only", false, "")
+	args := []string{"tsuru-admin", "user-create", "-p", "s3cret", "-p=s3cret", "-o", "pool1", "--secret-only", "john"}
+	c.Assert(redactCommandLine(args, flags), check.Equals,
+		"tsuru-admin user-create -p ***** -p=***** -o pool1 --secret-only john")
+}
+
+func (s *S) TestParseSize(c *check.C) {
+	for value, expected := range map[string]int64{"100": 100, "2K": 2048, "10M": 10 << 20, "1G": 1 << 30} {
+		size, err := parseSize(value)
+		c.Assert(err, check.IsNil)
+		c.Assert(size, check.Equals, expected)
+	}
+	_, err := parseSize("ten")
+	c.Assert(err, check.ErrorMatches, `"ten" is not a valid size`)
+	_, err = parseSize("0")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestParseGlobalOptionsAuditLog(c *check.C) {
+	os.Setenv(auditLogEnv, "/var/log/env.log")
+	os.Setenv(auditLogMaxSizeEnv, "1M")
+	defer os.Unsetenv(auditLogEnv)
+	defer os.Unsetenv(auditLogMaxSizeEnv)
+	opts, args, err := parseGlobalOptions([]string{"plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.auditLog, check.Equals, "/var/log/env.log")
+	c.Assert(opts.auditMaxSize, check.Equals, int64(1<<20))
+	opts, args, err = parseGlobalOptions([]string{"--audit-log", "/tmp/audit.log", "--audit-log-max-size=2K", "plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.auditLog, check.Equals, "/tmp/audit.log")
+	c.Assert(opts.auditMaxSize, check.Equals, int64(2048))
+	c.Assert(args, check.DeepEquals, []string{"plan-list"})
+	os.Setenv(auditLogMaxSizeEnv, "big")
+	_, _, err = parseGlobalOptions([]string{"plan-list"})
+	c.Assert(err, check.ErrorMatches, `invalid value "big" for TSURU_ADMIN_AUDIT_LOG_MAX_SIZE: "big" is not a valid size`)
+}
+
+func (s *S) TestGlobalOptionsApplyAuditLogWithDryRun(c *check.C) {
+	var out bytes.Buffer
+	client := &http.Client{}
+	opts := globalOptions{dryRun: true, auditLog: "/tmp/audit.log", auditMaxSize: 100}
+	err := opts.apply(client, &out, &out)
+	c.Assert(err, check.IsNil)
+	dryRun, ok := client.Transport.(*dryRunTransport)
+	c.Assert(ok, check.Equals, true)
+	audit, ok := dryRun.transport.(*auditTransport)
+	c.Assert(ok, check.Equals, true)
+	retry, ok := audit.transport.(*retryTransport)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(retry.transport, check.Equals, http.DefaultTransport)
+	c.Assert(audit.log.path, check.Equals, "/tmp/audit.log")
+	c.Assert(audit.log.maxSize, check.Equals, int64(100))
+}
+
+func writeAuditEntries(c *check.C, path string) {
+	log := auditLog{path: path, maxSize: defaultAuditLogMaxSize}
+	now := time.Now()
+	for _, entry := range []auditEntry{
+		{Timestamp: now.Add(-48 * time.Hour), User: "alice", Target: "https://prod.example.com", Command: "tsuru-admin plan-remove old", Method: "DELETE", Path: "/1.0/plans/old", Status: 200, Duration: "10ms"},
+		{Timestamp: now.Add(-time.Hour), User: "bob", Target: "https://dev.example.com", Command: "tsuru-admin plan-create small", Method: "POST", Path: "/1.0/plans", Body: "name=small", Status: 409, Duration: "5ms"},
+		{Timestamp: now, User: "alice", Target: "https://prod.example.com", Command: "tsuru-admin pool-update p1 --public", Method: "POST", Path: "/1.0/pools/p1", Error: "connection refused", Duration: "1ms"},
+	} {
+		c.Assert(log.write(entry), check.IsNil)
+	}
+}
+
+func (s *S) runAuditLog(c *check.C, args ...string) string {
+	var stdout bytes.Buffer
+	command := auditLogCommand{}
+	err := command.Flags().Parse(true, args)
+	c.Assert(err, check.IsNil)
+	err = command.Run(&cmd.Context{Stdout: &stdout}, nil)
+	c.Assert(err, check.IsNil)
+	return stdout.String()
+}
+
+func (s *S) TestAuditLogCommandInfo(c *check.C) {
+	c.Assert((&auditLogCommand{}).Info(), check.NotNil)
+}
+
+func (s *S) TestAuditLogCommand(c *check.C) {
+	path := filepath.Join(c.MkDir(), "audit.log")
+	writeAuditEntries(c, path)
+	output := s.runAuditLog(c, "--file", path, "--user", "alice")
+	c.Assert(output, check.Matches, `(?s)\+-.*\| Time .*\| User .*\| Target .*\| Command .*\| Request .*\| Status .*\| Duration .*`)
+	c.Assert(output, check.Matches, `(?s).*\| alice \| https://prod.example.com \| tsuru-admin plan-remove old .*\| DELETE /1.0/plans/old \| 200 .*`)
+	c.Assert(output, check.Matches, `(?s).*\| POST /1.0/pools/p1 +\| connection refused \|.*`)
+	c.Assert(strings.Contains(output, "bob"), check.Equals, false)
+}
+
+func (s *S) TestAuditLogCommandFilters(c *check.C) {
+	path := filepath.Join(c.MkDir(), "audit.log")
+	writeAuditEntries(c, path)
+	commands := func(output string) []string {
+		var result []string
+		for _, line := range strings.Split(output, "\n") {
+			if strings.HasPrefix(line, "  Command: ") {
+				result = append(result, strings.TrimPrefix(line, "  Command: "))
+			}
+		}
+		return result
+	}
+	tmpl := "--output=template={{range .}}  Command: {{.Command}}\n{{end}}"
+	c.Assert(commands(s.runAuditLog(c, "--file", path, tmpl, "--failed")), check.DeepEquals,
+		[]string{"tsuru-admin plan-create small", "tsuru-admin pool-update p1 --public"})
+	c.Assert(commands(s.runAuditLog(c, "--file", path, tmpl, "--since", "24h")), check.DeepEquals,
+		[]string{"tsuru-admin plan-create small", "tsuru-admin pool-update p1 --public"})
+	c.Assert(commands(s.runAuditLog(c, "--file", path, tmpl, "--method", "delete")), check.DeepEquals,
+		[]string{"tsuru-admin plan-remove old"})
+	c.Assert(commands(s.runAuditLog(c, "--file", path, tmpl, "--target", "dev")), check.DeepEquals,
+		[]string{"tsuru-admin plan-create small"})
+	c.Assert(commands(s.runAuditLog(c, "--file", path, tmpl, "--grep", "name=small")), check.DeepEquals,
+		[]string{"tsuru-admin plan-create small"})
+	c.Assert(s.runAuditLog(c, "--file", path, "--user", "carol"), check.Equals, "No audit entries found.\n")
+	c.Assert(s.runAuditLog(c, "--file", path, "--user", "carol", "-o", "json"), check.Equals, "[]\n")
+}
+
+func (s *S) TestAuditLogCommandDefaultFile(c *check.C) {
+	os.Setenv(auditLogEnv, "/var/log/tsuru-admin.log")
+	defer os.Unsetenv(auditLogEnv)
+	command := auditLogCommand{}
+	c.Assert(command.Flags().Lookup("file").DefValue, check.Equals, "/var/log/tsuru-admin.log")
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+type batch struct {
+	manager         *cmd.Manager
+	file            string
+	continueOnError bool
+	dryRun          bool
+	fs              *gnuflag.FlagSet
+}
+
+func (c *batch) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "batch",
+		Usage:   "batch -f <file> [--continue-on-error] [--dry-run]",
+		MinArgs: 0,
+		Desc: `Runs the tsuru-admin commands listed in a file, one per line.
+
+Empty lines and lines starting with # are ignored. Arguments may be quoted as
+in a shell. The execution stops at the first failure, unless the
+[[--continue-on-error]] flag is used. With [[--dry-run]], the requests that
+would change data in the tsuru server are displayed instead of sent.`,
+	}
+}
+
+func (c *batch) freshCopy() cmd.Command {
+	return &batch{manager: c.manager}
+}
+
+func (c *batch) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("batch", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.file, "file", "", "Path to the file with the commands.")
+		c.fs.StringVar(&c.file, "f", "", "Path to the file with the commands.")
+		c.fs.BoolVar(&c.continueOnError, "continue-on-error", false, "Keep running the next commands after a failure.")
+		c.fs.BoolVar(&c.dryRun, "dry-run", false, "Display the requests that would change data instead of sending them.")
+	}
+	return c.fs
+}
+
+type batchLine struct {
+	number int
+	text   string
+	args   []string
+}
+
+func (c *batch) Run(context *cmd.Context, client *cmd.Client) error {
+	if c.file == "" {
+		return errors.New("please provide the file with the commands using the -f flag")
+	}
+	lines, err := readBatchFile(c.file)
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		dryClient := *client
+		dryClient.HTTPClient = &http.Client{
+			Transport: &dryRunTransport{transport: transportOf(client.HTTPClient), out: context.Stdout},
+			Timeout:   client.HTTPClient.Timeout,
+		}
+		client = &dryClient
+	}
+	runner := commandRunner{manager: c.manager, client: client}
+	var succeeded, failed int
+	for _, line := range lines {
+		fmt.Fprintf(context.Stdout, "Line %d: %s\n", line.number, line.text)
+		lineContext := *context
+		err = runner.run(&lineContext, line.args)
+		if err != nil {
+			failed++
+			fmt.Fprintf(context.Stdout, "Line %d: FAILED: %s\n\n", line.number, strings.TrimSpace(err.Error()))
+			if !c.continueOnError {
+				break
+			}
+			continue
+		}
+		succeeded++
+		fmt.Fprintf(context.Stdout, "Line %d: OK\n\n", line.number)
+	}
+	fmt.Fprintf(context.Stdout, "%d command(s): %d succeeded, %d failed, %d skipped.\n",
+		len(lines), succeeded, failed, len(lines)-succeeded-failed)
+	if failed > 0 {
+		return fmt.Errorf("%d command(s) failed", failed)
+	}
+	return nil
+}
+
+// readBatchFile parses all the lines in the file before anything runs, so
+// syntax errors are reported upfront.
+func readBatchFile(path string) ([]batchLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []batchLine
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		args, err := splitCommandLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, number, err)
+		}
+		if args[0] == "tsuru-admin" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "batch" {
+			return nil, fmt.Errorf("%s:%d: batch can't be nested", path, number)
+		}
+		lines = append(lines, batchLine{number: number, text: text, args: args})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
@@ -0,0 +1,232 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func writeBatchFile(c *check.C, content string) string {
+	path := filepath.Join(c.MkDir(), "changes.txt")
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	c.Assert(err, check.IsNil)
+	return path
+}
+
+const batchContent = `# plans cleanup
+plan-remove small
+
+tsuru-admin app-unlock -a "my app" -y
+plan-remove large
+`
+
+func (s *S) TestBatchRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/plans/small") && req.Method == "DELETE"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/apps/my app/lock") && req.Method == "DELETE"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/plans/large") && req.Method == "DELETE"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := batch{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{"-f", writeBatchFile(c, batchContent)})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `Line 2: plan-remove small
+Plan successfully removed!
+Line 2: OK
+
+Line 4: tsuru-admin app-unlock -a "my app" -y
+Lock successfully removed!
+Line 4: OK
+
+Line 5: plan-remove large
+Plan successfully removed!
+Line 5: OK
+
+3 command(s): 3 succeeded, 0 failed, 0 skipped.
+`)
+}
+
+func (s *S) TestBatchRunStopsAtFirstError(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.Transport{Message: "plan not found", Status: http.StatusNotFound}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := batch{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{"-f", writeBatchFile(c, batchContent)})
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `1 command\(s\) failed`)
+	c.Assert(stdout.String(), check.Equals, `Line 2: plan-remove small
+Failed to remove plan!
+Line 2: FAILED: plan not found
+
+3 command(s): 0 succeeded, 1 failed, 2 skipped.
+`)
+}
+
+func (s *S) TestBatchRunContinueOnError(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.Transport{Message: "", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := batch{manager: buildManager("tsuru-admin")}
+	content := "plan-remove\nplan-remove --invalid plan1\nunknown-command\nplan-remove plan1\n"
+	command.Flags().Parse(true, []string{"-f", writeBatchFile(c, content), "--continue-on-error"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `3 command\(s\) failed`)
+	c.Assert(stdout.String(), check.Equals, `Line 1: plan-remove
+Line 1: FAILED: wrong number of arguments, usage: plan-remove <name>
+
+Line 2: plan-remove --invalid plan1
+Line 2: FAILED: flag provided but not defined: --invalid
+
+Line 3: unknown-command
+Line 3: FAILED: "unknown-command" is not a tsuru-admin command
+
+Line 4: plan-remove plan1
+Plan successfully removed!
+Line 4: OK
+
+4 command(s): 1 succeeded, 3 failed, 0 skipped.
+`)
+}
+
+func (s *S) TestBatchRunDryRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.Transport{Message: "should not be called", Status: http.StatusInternalServerError}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := batch{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{"-f", writeBatchFile(c, "plan-remove small\n"), "--dry-run"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `Line 1: plan-remove small
+Dry run: DELETE http://localhost/1.0/plans/small
+Plan successfully removed!
+Line 1: OK
+
+1 command(s): 1 succeeded, 0 failed, 0 skipped.
+`)
+}
+
+func (s *S) TestBatchRunAuditsEachCommand(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	path := writeBatchFile(c, "plan-remove small\nplan-remove large\n")
+	audit := &auditTransport{
+		transport:   &cmdtest.Transport{Message: "", Status: http.StatusOK},
+		log:         &auditLog{path: filepath.Join(c.MkDir(), "audit.log"), maxSize: defaultAuditLogMaxSize},
+		commandLine: []string{"tsuru-admin", "batch", "-f", path},
+		stderr:      &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: audit}, nil, manager)
+	command := batch{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{"-f", path})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	entries, err := audit.log.entries()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 2)
+	c.Assert(entries[0].Command, check.Equals, "tsuru-admin plan-remove small")
+	c.Assert(entries[1].Command, check.Equals, "tsuru-admin plan-remove large")
+}
+
+func (s *S) TestBatchRunFlagsDontLeak(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("n\n"),
+	}
+	trans := &cmdtest.Transport{Message: "", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := batch{manager: buildManager("tsuru-admin")}
+	content := "app-unlock -a app1 -y\napp-unlock -a app2\n"
+	command.Flags().Parse(true, []string{"-f", writeBatchFile(c, content)})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Matches, `(?s).*Are you sure you want to remove the lock from app "app2"\? \(y/n\) Abort.*`)
+}
+
+func (s *S) TestBatchRunFlagsAfterHelp(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/apps") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := batch{manager: buildManager("tsuru-admin")}
+	content := "help app-unlock\napp-unlock --all -y\napp-unlock --all -y\n"
+	command.Flags().Parse(true, []string{"-f", writeBatchFile(c, content)})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Matches, `(?s).*Usage: tsuru-admin app-unlock.*Line 2: app-unlock --all -y\nNo locks to remove.\nLine 2: OK\n.*Line 3: app-unlock --all -y\nNo locks to remove.\nLine 3: OK\n.*`)
+}
+
+func (s *S) TestBatchRunInvalidFile(c *check.C) {
+	command := batch{manager: buildManager("tsuru-admin")}
+	path := writeBatchFile(c, "plan-remove 'small\n")
+	command.Flags().Parse(true, []string{"-f", path})
+	err := command.Run(&cmd.Context{}, nil)
+	c.Assert(err, check.ErrorMatches, `.*changes.txt:1: unterminated quote or escape in "plan-remove 'small"`)
+	command = batch{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{})
+	err = command.Run(&cmd.Context{}, nil)
+	c.Assert(err, check.ErrorMatches, "please provide the file with the commands using the -f flag")
+}
+
+func (s *S) TestSplitCommandLine(c *check.C) {
+	args, err := splitCommandLine(`pool-update "my pool" --public 'a b' x\ y ""`)
+	c.Assert(err, check.IsNil)
+	c.Assert(args, check.DeepEquals, []string{"pool-update", "my pool", "--public", "a b", "x y", ""})
+}
@@ -0,0 +1,553 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/docker/healer"
+	"github.com/tsuru/tsuru/provision/docker/nodecontainer"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/yaml.v1"
+)
+
+// clusterSnapshot is the admin configuration of a tsuru installation, as
+// exported by cluster-export. Each resource is kept in its own YAML file,
+// sorted by name, so snapshots are easy to review in version control.
+type clusterSnapshot struct {
+	Pools          []provision.Pool
+	Plans          []app.Plan
+	Platforms      []app.Platform
+	Templates      []iaas.Template
+	Roles          []clusterRole
+	NodeContainers []nodecontainer.NodeContainerConfigGroup
+	NodeHealing    []clusterHealing
+	AutoScaleRules []clusterAutoScaleRule
+	DockerLogs     []clusterLogConfig
+	AppQuotas      []clusterQuota
+	UserQuotas     []clusterQuota
+}
+
+// clusterRole is a role along with its permissions and the events the role
+// is assigned by default on, e.g. user-create.
+type clusterRole struct {
+	Name        string
+	Context     string
+	Description string   `yaml:",omitempty"`
+	Permissions []string `yaml:",omitempty"`
+	Events      []string `yaml:",omitempty"`
+}
+
+// clusterHealing is the node healing configuration of a pool, or the default
+// one when the pool is empty. Only the values set for the pool are kept,
+// leaving out the ones inherited from the default configuration.
+type clusterHealing struct {
+	Pool                string `yaml:",omitempty"`
+	Enabled             *bool  `yaml:",omitempty"`
+	MaxTimeSinceSuccess *int   `yaml:",omitempty"`
+	MaxUnresponsiveTime *int   `yaml:",omitempty"`
+}
+
+type clusterAutoScaleRule struct {
+	MetadataFilter    string
+	MaxContainerCount int
+	ScaleDownRatio    float32
+	MaxMemoryRatio    float32
+	Enabled           bool
+	PreventRebalance  bool
+}
+
+// clusterLogConfig is the docker log configuration of a pool, or the default
+// one when the pool is empty.
+type clusterLogConfig struct {
+	Pool    string            `yaml:",omitempty"`
+	Driver  string            `yaml:",omitempty"`
+	LogOpts map[string]string `yaml:",omitempty"`
+}
+
+// clusterQuota is the quota limit of an app or a user, -1 meaning unlimited.
+type clusterQuota struct {
+	Name  string
+	Limit int
+}
+
+// clusterResource is a type of resource in a snapshot. The resources are
+// listed in the order they're applied, so the ones a resource depends on
+// are created first.
+type clusterResource struct {
+	// name is the name of the resource, also used for its file.
+	name string
+	desc string
+	// data returns a pointer to the resources of this type in the snapshot.
+	data  func(s *clusterSnapshot) interface{}
+	fetch func(client *cmd.Client, s *clusterSnapshot) error
+	// diff returns the changes turning the live resources into the ones in
+	// the snapshot.
+	diff func(live, desired *clusterSnapshot) []clusterChange
+}
+
+var clusterResources = []clusterResource{
+	{
+		name:  "pools",
+		desc:  "pools",
+		data:  func(s *clusterSnapshot) interface{} { return &s.Pools },
+		fetch: fetchPools,
+		diff:  diffPools,
+	},
+	{
+		name:  "plans",
+		desc:  "plans",
+		data:  func(s *clusterSnapshot) interface{} { return &s.Plans },
+		fetch: fetchPlans,
+		diff:  diffPlans,
+	},
+	{
+		name:  "platforms",
+		desc:  "platforms",
+		data:  func(s *clusterSnapshot) interface{} { return &s.Platforms },
+		fetch: fetchPlatforms,
+		diff:  diffPlatforms,
+	},
+	{
+		name:  "machine-templates",
+		desc:  "machine templates",
+		data:  func(s *clusterSnapshot) interface{} { return &s.Templates },
+		fetch: fetchTemplates,
+		diff:  diffTemplates,
+	},
+	{
+		name:  "roles",
+		desc:  "roles",
+		data:  func(s *clusterSnapshot) interface{} { return &s.Roles },
+		fetch: fetchRoles,
+		diff:  diffRoles,
+	},
+	{
+		name:  "node-containers",
+		desc:  "node containers",
+		data:  func(s *clusterSnapshot) interface{} { return &s.NodeContainers },
+		fetch: fetchNodeContainers,
+		diff:  diffNodeContainers,
+	},
+	{
+		name:  "node-healing",
+		desc:  "node healing configurations",
+		data:  func(s *clusterSnapshot) interface{} { return &s.NodeHealing },
+		fetch: fetchNodeHealing,
+		diff:  diffNodeHealing,
+	},
+	{
+		name:  "autoscale-rules",
+		desc:  "autoscale rules",
+		data:  func(s *clusterSnapshot) interface{} { return &s.AutoScaleRules },
+		fetch: fetchAutoScaleRules,
+		diff:  diffAutoScaleRules,
+	},
+	{
+		name:  "docker-logs",
+		desc:  "docker log configurations",
+		data:  func(s *clusterSnapshot) interface{} { return &s.DockerLogs },
+		fetch: fetchDockerLogs,
+		diff:  diffDockerLogs,
+	},
+	{
+		name:  "app-quotas",
+		desc:  "app quotas",
+		data:  func(s *clusterSnapshot) interface{} { return &s.AppQuotas },
+		fetch: fetchAppQuotas,
+		diff:  diffAppQuotas,
+	},
+	{
+		name:  "user-quotas",
+		desc:  "user quotas",
+		data:  func(s *clusterSnapshot) interface{} { return &s.UserQuotas },
+		fetch: fetchUserQuotas,
+		diff:  diffUserQuotas,
+	},
+}
+
+func (r *clusterResource) path(dir string) string {
+	return filepath.Join(dir, r.name+".yaml")
+}
+
+func (r *clusterResource) count(s *clusterSnapshot) int {
+	return reflect.ValueOf(r.data(s)).Elem().Len()
+}
+
+// write writes the resources of this type in the snapshot to their file in
+// the directory.
+func (r *clusterResource) write(dir string, s *clusterSnapshot) error {
+	data, err := yaml.Marshal(r.data(s))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path(dir), data, 0600)
+}
+
+// read reads the resources of this type from their file in the directory
+// into the snapshot.
+func (r *clusterResource) read(dir string, s *clusterSnapshot) error {
+	data, err := ioutil.ReadFile(r.path(dir))
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(data, r.data(s)); err != nil {
+		return fmt.Errorf("unable to parse %s: %s", r.path(dir), err)
+	}
+	return nil
+}
+
+// pathSegment escapes the name for being used as a segment of the path of a
+// URL, including its slashes.
+func pathSegment(name string) string {
+	return strings.Replace((&url.URL{Path: name}).EscapedPath(), "/", "%2F", -1)
+}
+
+// getJSON sends a GET request to the tsuru API, decoding the response into
+// value, which is left untouched when there's no content.
+func getJSON(client *cmd.Client, path string, value interface{}) error {
+	u, err := cmd.GetURL(path)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+func fetchPools(client *cmd.Client, s *clusterSnapshot) error {
+	s.Pools = []provision.Pool{}
+	if err := getJSON(client, "/pools", &s.Pools); err != nil {
+		return err
+	}
+	for _, pool := range s.Pools {
+		sort.Strings(pool.Teams)
+	}
+	sort.Sort(poolsByName(s.Pools))
+	return nil
+}
+
+type poolsByName []provision.Pool
+
+func (l poolsByName) Len() int           { return len(l) }
+func (l poolsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l poolsByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+func fetchPlans(client *cmd.Client, s *clusterSnapshot) error {
+	s.Plans = []app.Plan{}
+	if err := getJSON(client, "/plans", &s.Plans); err != nil {
+		return err
+	}
+	sort.Sort(plansByName(s.Plans))
+	return nil
+}
+
+type plansByName []app.Plan
+
+func (l plansByName) Len() int           { return len(l) }
+func (l plansByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l plansByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+func fetchPlatforms(client *cmd.Client, s *clusterSnapshot) error {
+	s.Platforms = []app.Platform{}
+	if err := getJSON(client, "/platforms", &s.Platforms); err != nil {
+		return err
+	}
+	sort.Sort(platformsByName(s.Platforms))
+	return nil
+}
+
+type platformsByName []app.Platform
+
+func (l platformsByName) Len() int           { return len(l) }
+func (l platformsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l platformsByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+func fetchTemplates(client *cmd.Client, s *clusterSnapshot) error {
+	s.Templates = []iaas.Template{}
+	if err := getJSON(client, "/iaas/templates", &s.Templates); err != nil {
+		return err
+	}
+	for i := range s.Templates {
+		sort.Sort(s.Templates[i].Data)
+	}
+	sort.Sort(templatesByName(s.Templates))
+	return nil
+}
+
+// redactTemplates replaces the values of the sensitive parameters of the
+// templates, returning the number of parameters redacted.
+func redactTemplates(templates []iaas.Template) int {
+	var redacted int
+	for i := range templates {
+		for j, data := range templates[i].Data {
+			if sensitiveParam.MatchString(data.Name) {
+				templates[i].Data[j].Value = redactedValue
+				redacted++
+			}
+		}
+	}
+	return redacted
+}
+
+type templatesByName []iaas.Template
+
+func (l templatesByName) Len() int           { return len(l) }
+func (l templatesByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l templatesByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+func fetchRoles(client *cmd.Client, s *clusterSnapshot) error {
+	var roles []permission.Role
+	if err := getJSON(client, "/roles", &roles); err != nil {
+		return err
+	}
+	s.Roles = make([]clusterRole, len(roles))
+	for i, role := range roles {
+		sort.Strings(role.SchemeNames)
+		sort.Strings(role.Events)
+		s.Roles[i] = clusterRole{
+			Name:        role.Name,
+			Context:     string(role.ContextType),
+			Description: role.Description,
+			Permissions: role.SchemeNames,
+			Events:      role.Events,
+		}
+	}
+	sort.Sort(rolesByName(s.Roles))
+	return nil
+}
+
+type rolesByName []clusterRole
+
+func (l rolesByName) Len() int           { return len(l) }
+func (l rolesByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l rolesByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+func fetchNodeContainers(client *cmd.Client, s *clusterSnapshot) error {
+	s.NodeContainers = []nodecontainer.NodeContainerConfigGroup{}
+	if err := getJSON(client, "/docker/nodecontainers", &s.NodeContainers); err != nil {
+		return err
+	}
+	for _, group := range s.NodeContainers {
+		// The pinned image is set by the upgrades of the node container, it's
+		// not part of its configuration.
+		for pool, config := range group.ConfigPools {
+			config.PinnedImage = ""
+			group.ConfigPools[pool] = config
+		}
+	}
+	sort.Sort(nodecontainer.NodeContainerConfigGroupSlice(s.NodeContainers))
+	return nil
+}
+
+func fetchNodeHealing(client *cmd.Client, s *clusterSnapshot) error {
+	var configs map[string]healer.NodeHealerConfig
+	if err := getJSON(client, "/docker/healing/node", &configs); err != nil {
+		return err
+	}
+	s.NodeHealing = []clusterHealing{}
+	for _, pool := range sortedHealingPools(configs) {
+		config := configs[pool]
+		healing := clusterHealing{Pool: pool}
+		if !config.EnabledInherited {
+			healing.Enabled = config.Enabled
+		}
+		if !config.MaxTimeSinceSuccessInherited {
+			healing.MaxTimeSinceSuccess = config.MaxTimeSinceSuccess
+		}
+		if !config.MaxUnresponsiveTimeInherited {
+			healing.MaxUnresponsiveTime = config.MaxUnresponsiveTime
+		}
+		if healing.Enabled != nil || healing.MaxTimeSinceSuccess != nil || healing.MaxUnresponsiveTime != nil {
+			s.NodeHealing = append(s.NodeHealing, healing)
+		}
+	}
+	return nil
+}
+
+func sortedHealingPools(configs map[string]healer.NodeHealerConfig) []string {
+	pools := make([]string, 0, len(configs))
+	for pool := range configs {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+func fetchAutoScaleRules(client *cmd.Client, s *clusterSnapshot) error {
+	s.AutoScaleRules = []clusterAutoScaleRule{}
+	if err := getJSON(client, "/docker/autoscale/rules", &s.AutoScaleRules); err != nil {
+		return err
+	}
+	sort.Sort(autoScaleRulesByFilter(s.AutoScaleRules))
+	return nil
+}
+
+type autoScaleRulesByFilter []clusterAutoScaleRule
+
+func (l autoScaleRulesByFilter) Len() int           { return len(l) }
+func (l autoScaleRulesByFilter) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l autoScaleRulesByFilter) Less(i, j int) bool { return l[i].MetadataFilter < l[j].MetadataFilter }
+
+func fetchDockerLogs(client *cmd.Client, s *clusterSnapshot) error {
+	var configs map[string]container.DockerLogConfig
+	if err := getJSON(client, "/docker/logs", &configs); err != nil {
+		return err
+	}
+	pools := make([]string, 0, len(configs))
+	for pool := range configs {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	s.DockerLogs = make([]clusterLogConfig, len(pools))
+	for i, pool := range pools {
+		s.DockerLogs[i] = clusterLogConfig{Pool: pool, Driver: configs[pool].Driver, LogOpts: configs[pool].LogOpts}
+	}
+	return nil
+}
+
+func fetchAppQuotas(client *cmd.Client, s *clusterSnapshot) error {
+	apps, err := listApps(client, nil)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(apps))
+	for i, a := range apps {
+		names[i] = a.Name
+	}
+	s.AppQuotas, err = fetchQuotas(client, "/apps/", names)
+	return err
+}
+
+func fetchUserQuotas(client *cmd.Client, s *clusterSnapshot) error {
+	var users []struct{ Email string }
+	if err := getJSON(client, "/users", &users); err != nil {
+		return err
+	}
+	emails := make([]string, len(users))
+	for i, user := range users {
+		emails[i] = user.Email
+	}
+	var err error
+	s.UserQuotas, err = fetchQuotas(client, "/users/", emails)
+	return err
+}
+
+// fetchQuotas returns the quota limits of the apps or users with the given
+// names, sorted by name.
+func fetchQuotas(client *cmd.Client, prefix string, names []string) ([]clusterQuota, error) {
+	sort.Strings(names)
+	quotas := make([]clusterQuota, len(names))
+	for i, name := range names {
+		var q quota.Quota
+		if err := getJSON(client, prefix+pathSegment(name)+"/quota", &q); err != nil {
+			return nil, err
+		}
+		quotas[i] = clusterQuota{Name: name, Limit: q.Limit}
+	}
+	return quotas, nil
+}
+
+type clusterExport struct {
+	dir            string
+	includeSecrets bool
+	fs             *gnuflag.FlagSet
+}
+
+func (c *clusterExport) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "cluster-export",
+		Usage: "cluster-export -o <dir> [--include-secrets]",
+		Desc: `Exports the admin configuration of the tsuru installation to YAML files.
+
+The following resources are written to the directory, one file per type of
+resource: pools, with their teams and flags, plans, platforms and their state,
+machine templates, roles, with their permissions and the events they're
+assigned by default on, node containers, node healing configurations,
+autoscale rules, docker log configurations, app quotas and user quotas.
+
+Only the data returned by the tsuru API is exported, sorted by name, so
+exports may be kept in version control and applied to another tsuru
+installation using the [[cluster-apply]] command, for example:
+
+[[tsuru-admin cluster-export -o cluster/]]
+
+The values of the machine template parameters that look like credentials
+(passwords, tokens, keys and secrets) are replaced by "*****", and
+[[cluster-apply]] keeps their current values. Use the [[--include-secrets]]
+flag to export them. The files are only readable by their owner.`,
+		MinArgs: 0,
+		MaxArgs: 0,
+	}
+}
+
+func (c *clusterExport) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("cluster-export", gnuflag.ExitOnError)
+		dir := "Directory the YAML files are written to"
+		c.fs.StringVar(&c.dir, "output", "", dir)
+		c.fs.StringVar(&c.dir, "o", "", dir)
+		c.fs.BoolVar(&c.includeSecrets, "include-secrets", false, "Export the values of sensitive machine template parameters")
+	}
+	return c.fs
+}
+
+func (c *clusterExport) Run(context *cmd.Context, client *cmd.Client) error {
+	if c.dir == "" {
+		return errors.New("the output directory is required (-o/--output)")
+	}
+	var snapshot clusterSnapshot
+	for _, resource := range clusterResources {
+		if err := resource.fetch(client, &snapshot); err != nil {
+			return fmt.Errorf("unable to export %s: %s", resource.desc, err)
+		}
+	}
+	var redacted int
+	if c.includeSecrets {
+		fmt.Fprintln(context.Stderr, "WARNING: the exported files include the values of sensitive machine template parameters.")
+	} else {
+		redacted = redactTemplates(snapshot.Templates)
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	for _, resource := range clusterResources {
+		if err := resource.write(c.dir, &snapshot); err != nil {
+			return err
+		}
+		fmt.Fprintf(context.Stdout, "Exported %d %s to %s.\n", resource.count(&snapshot), resource.desc, resource.path(c.dir))
+	}
+	if redacted > 0 {
+		fmt.Fprintf(context.Stdout, "Redacted %d sensitive machine template parameter(s), use --include-secrets to export them.\n", redacted)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/docker/healer"
+	"github.com/tsuru/tsuru/provision/docker/nodecontainer"
+	"gopkg.in/check.v1"
+	"gopkg.in/yaml.v1"
+)
+
+func boolPtr(v bool) *bool { return &v }
+
+func intPtr(v int) *int { return &v }
+
+// seedCluster adds one resource of each type exported by cluster-export to
+// the fake API.
+func (api *fakeAPI) seedCluster() {
+	api.mu.Lock()
+	api.pools["prod"] = &provision.Pool{Name: "prod", Teams: []string{"ops", "dev"}}
+	api.pools["public"] = &provision.Pool{Name: "public", Public: true, Default: true}
+	api.plans["small"] = app.Plan{Name: "small", Memory: 536870912, CpuShare: 2, Default: true}
+	api.platforms["python"] = &app.Platform{Name: "python"}
+	api.platforms["java"] = &app.Platform{Name: "java", Disabled: true}
+	api.templates["ec2-small"] = iaas.Template{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-east-1"},
+		{Name: "image", Value: "ami-123"},
+	}}
+	api.roles["deployer"] = &permission.Role{
+		Name:        "deployer",
+		ContextType: "team",
+		Description: "Deploys apps",
+		SchemeNames: []string{"app.deploy", "app.read"},
+		Events:      []string{"team-create"},
+	}
+	api.nodeContainers["big-sibling"] = &nodecontainer.NodeContainerConfigGroup{
+		Name: "big-sibling",
+		ConfigPools: map[string]nodecontainer.NodeContainerConfig{
+			"": {
+				Name:        "big-sibling",
+				PinnedImage: "tsuru/bs@sha256:abc",
+				Config:      docker.Config{Image: "tsuru/bs:v1", Env: []string{"A=1"}},
+				HostConfig:  docker.HostConfig{Privileged: true},
+			},
+		},
+	}
+	api.healing[""] = healer.NodeHealerConfig{Enabled: boolPtr(true), MaxTimeSinceSuccess: intPtr(60), MaxUnresponsiveTime: intPtr(300)}
+	api.healing["prod"] = healer.NodeHealerConfig{MaxUnresponsiveTime: intPtr(120)}
+	api.autoScaleRules["pool=prod"] = clusterAutoScaleRule{MetadataFilter: "pool=prod", MaxContainerCount: 10, ScaleDownRatio: 1.5, Enabled: true}
+	api.logs[""] = container.DockerLogConfig{Driver: "syslog", LogOpts: map[string]string{"syslog-address": "udp://localhost:514"}}
+	api.mu.Unlock()
+	api.addApp(appSummary{Name: "myapp", Pool: "prod"})
+	api.appQuotas["myapp"].Limit = 4
+	api.addUser("admin@example.com")
+}
+
+func readClusterFile(c *check.C, dir, name string, value interface{}) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name+".yaml"))
+	c.Assert(err, check.IsNil)
+	err = yaml.Unmarshal(data, value)
+	c.Assert(err, check.IsNil)
+	return string(data)
+}
+
+func (s *S) TestClusterExport(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.seedCluster()
+	dir := filepath.Join(c.MkDir(), "cluster")
+	stdout := api.mustRun(c, "cluster-export", "-o", dir)
+	c.Assert(stdout, check.Equals, "Exported 2 pools to "+dir+"/pools.yaml.\n"+
+		"Exported 1 plans to "+dir+"/plans.yaml.\n"+
+		"Exported 2 platforms to "+dir+"/platforms.yaml.\n"+
+		"Exported 1 machine templates to "+dir+"/machine-templates.yaml.\n"+
+		"Exported 1 roles to "+dir+"/roles.yaml.\n"+
+		"Exported 1 node containers to "+dir+"/node-containers.yaml.\n"+
+		"Exported 2 node healing configurations to "+dir+"/node-healing.yaml.\n"+
+		"Exported 1 autoscale rules to "+dir+"/autoscale-rules.yaml.\n"+
+		"Exported 1 docker log configurations to "+dir+"/docker-logs.yaml.\n"+
+		"Exported 1 app quotas to "+dir+"/app-quotas.yaml.\n"+
+		"Exported 1 user quotas to "+dir+"/user-quotas.yaml.\n")
+	var pools []provision.Pool
+	data := readClusterFile(c, dir, "pools", &pools)
+	c.Assert(data, check.Equals, `- name: prod
+  teams:
+  - dev
+  - ops
+  public: false
+  default: false
+- name: public
+  teams: []
+  public: true
+  default: true
+`)
+	var roles []clusterRole
+	data = readClusterFile(c, dir, "roles", &roles)
+	c.Assert(data, check.Equals, `- name: deployer
+  context: team
+  description: Deploys apps
+  permissions:
+  - app.deploy
+  - app.read
+  events:
+  - team-create
+`)
+	var plans []app.Plan
+	readClusterFile(c, dir, "plans", &plans)
+	c.Assert(plans, check.DeepEquals, []app.Plan{{Name: "small", Memory: 536870912, CpuShare: 2, Default: true}})
+	var platforms []app.Platform
+	readClusterFile(c, dir, "platforms", &platforms)
+	c.Assert(platforms, check.DeepEquals, []app.Platform{{Name: "java", Disabled: true}, {Name: "python"}})
+	var templates []iaas.Template
+	readClusterFile(c, dir, "machine-templates", &templates)
+	c.Assert(templates, check.DeepEquals, []iaas.Template{{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "image", Value: "ami-123"},
+		{Name: "region", Value: "us-east-1"},
+	}}})
+	var groups []nodecontainer.NodeContainerConfigGroup
+	readClusterFile(c, dir, "node-containers", &groups)
+	c.Assert(groups, check.HasLen, 1)
+	c.Assert(groups[0].ConfigPools[""].PinnedImage, check.Equals, "")
+	c.Assert(groups[0].ConfigPools[""].Config.Image, check.Equals, "tsuru/bs:v1")
+	c.Assert(groups[0].ConfigPools[""].Config.Env, check.DeepEquals, []string{"A=1"})
+	c.Assert(groups[0].ConfigPools[""].HostConfig.Privileged, check.Equals, true)
+	var healing []clusterHealing
+	data = readClusterFile(c, dir, "node-healing", &healing)
+	c.Assert(data, check.Equals, `- enabled: true
+  maxtimesincesuccess: 60
+  maxunresponsivetime: 300
+- pool: prod
+  maxunresponsivetime: 120
+`)
+	var rules []clusterAutoScaleRule
+	readClusterFile(c, dir, "autoscale-rules", &rules)
+	c.Assert(rules, check.DeepEquals, []clusterAutoScaleRule{{MetadataFilter: "pool=prod", MaxContainerCount: 10, ScaleDownRatio: 1.5, Enabled: true}})
+	var logs []clusterLogConfig
+	readClusterFile(c, dir, "docker-logs", &logs)
+	c.Assert(logs, check.DeepEquals, []clusterLogConfig{{Driver: "syslog", LogOpts: map[string]string{"syslog-address": "udp://localhost:514"}}})
+	var appQuotas, userQuotas []clusterQuota
+	readClusterFile(c, dir, "app-quotas", &appQuotas)
+	c.Assert(appQuotas, check.DeepEquals, []clusterQuota{{Name: "myapp", Limit: 4}})
+	readClusterFile(c, dir, "user-quotas", &userQuotas)
+	c.Assert(userQuotas, check.DeepEquals, []clusterQuota{{Name: "admin@example.com", Limit: -1}})
+	for _, request := range api.requests {
+		c.Assert(request, check.Matches, "GET .*")
+	}
+}
+
+func (s *S) TestClusterExportRedactsSecrets(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.templates["ec2-small"] = iaas.Template{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-east-1"},
+		{Name: "secret-key", Value: "XYZ"},
+	}}
+	dir := c.MkDir()
+	stdout := api.mustRun(c, "cluster-export", "-o", dir)
+	c.Assert(stdout, check.Matches, "(?s).*\nRedacted 1 sensitive machine template parameter\\(s\\), use --include-secrets to export them.\n$")
+	var templates []iaas.Template
+	readClusterFile(c, dir, "machine-templates", &templates)
+	c.Assert(templates[0].Data, check.DeepEquals, iaas.TemplateDataList{
+		{Name: "region", Value: "us-east-1"},
+		{Name: "secret-key", Value: "*****"},
+	})
+	info, err := os.Stat(filepath.Join(dir, "machine-templates.yaml"))
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Mode().Perm(), check.Equals, os.FileMode(0600))
+	stdout = api.mustRun(c, "cluster-export", "-o", dir, "--include-secrets")
+	c.Assert(stdout, check.Not(check.Matches), "(?s).*Redacted.*")
+	var secrets []iaas.Template
+	readClusterFile(c, dir, "machine-templates", &secrets)
+	c.Assert(secrets[0].Data, check.DeepEquals, iaas.TemplateDataList{
+		{Name: "region", Value: "us-east-1"},
+		{Name: "secret-key", Value: "XYZ"},
+	})
+}
+
+func (s *S) TestClusterExportEscapesNames(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.addUser("john doe@example.com")
+	dir := c.MkDir()
+	api.mustRun(c, "cluster-export", "-o", dir)
+	var userQuotas []clusterQuota
+	readClusterFile(c, dir, "user-quotas", &userQuotas)
+	c.Assert(userQuotas, check.DeepEquals, []clusterQuota{{Name: "john doe@example.com", Limit: -1}})
+}
+
+func (s *S) TestPathSegment(c *check.C) {
+	c.Assert(pathSegment("john doe@example.com"), check.Equals, "john%20doe@example.com")
+	c.Assert(pathSegment("a/b?c#d"), check.Equals, "a%2Fb%3Fc%23d")
+	c.Assert(pathSegment("myapp"), check.Equals, "myapp")
+}
+
+func (s *S) TestClusterExportEmpty(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	dir := c.MkDir()
+	api.mustRun(c, "cluster-export", "--output", dir)
+	var rules []clusterAutoScaleRule
+	data := readClusterFile(c, dir, "autoscale-rules", &rules)
+	c.Assert(data, check.Equals, "[]\n")
+}
+
+func (s *S) TestClusterExportRequiresDirectory(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	_, err := api.run("cluster-export")
+	c.Assert(err, check.ErrorMatches, `the output directory is required \(-o/--output\)`)
+}
+
+func (s *S) TestClusterExportAPIError(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	dir := filepath.Join(c.MkDir(), "cluster")
+	api.server.Close()
+	_, err := api.run("cluster-export", "-o", dir)
+	c.Assert(err, check.ErrorMatches, "unable to export pools: .*")
+	_, err = ioutil.ReadDir(dir)
+	c.Assert(err, check.NotNil)
+}
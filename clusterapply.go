@@ -0,0 +1,1018 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ajg/form"
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/docker/nodecontainer"
+)
+
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeRemove = "remove"
+	// changeSkip is a difference that can't be applied through the tsuru
+	// API, the details holding the reason.
+	changeSkip = "skip"
+)
+
+// clusterChange is a change turning the live configuration of the tsuru
+// installation into the one in a snapshot.
+type clusterChange struct {
+	action  string
+	title   string
+	details []string
+	apply   func(client *cmd.Client) error
+}
+
+var changeSymbols = map[string]string{
+	changeCreate: "+",
+	changeUpdate: "~",
+	changeRemove: "-",
+	changeSkip:   "!",
+}
+
+var changeResults = map[string]string{
+	changeCreate: "Created",
+	changeUpdate: "Updated",
+	changeRemove: "Removed",
+}
+
+func (ch *clusterChange) write(w io.Writer) {
+	fmt.Fprintf(w, "%s %s\n", changeSymbols[ch.action], ch.title)
+	for _, detail := range ch.details {
+		fmt.Fprintf(w, "    %s\n", detail)
+	}
+}
+
+func removeChange(title, path string) clusterChange {
+	return clusterChange{action: changeRemove, title: title, apply: func(client *cmd.Client) error {
+		return sendForm(client, "DELETE", path, nil)
+	}}
+}
+
+// sendForm sends a request with the values form-encoded to the tsuru API.
+func sendForm(client *cmd.Client, method, path string, values url.Values) error {
+	u, err := cmd.GetURL(path)
+	if err != nil {
+		return err
+	}
+	return doRequest(client, u, method, values.Encode())
+}
+
+// sendStream sends a request to an endpoint of the tsuru API streaming its
+// response, discarding the messages and returning the error in the stream,
+// if any.
+func sendStream(client *cmd.Client, request *http.Request) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return cmd.StreamJSONResponse(ioutil.Discard, response)
+}
+
+const unsetValue = "(unset)"
+
+// valueChange describes the change of a field, returning nil when its value
+// is the same.
+func valueChange(field string, from, to interface{}) []string {
+	oldValue, newValue := fmt.Sprint(from), fmt.Sprint(to)
+	if oldValue == newValue {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: %s -> %s", field, oldValue, newValue)}
+}
+
+// mapChanges describes the changes of the entries of a map, one per entry.
+func mapChanges(field string, from, to map[string]string) []string {
+	keys := sortedKeys(from)
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var details []string
+	for _, key := range keys {
+		oldValue, ok := from[key]
+		if !ok {
+			oldValue = unsetValue
+		}
+		newValue, ok := to[key]
+		if !ok {
+			newValue = unsetValue
+		}
+		details = append(details, valueChange(field+" "+key, oldValue, newValue)...)
+	}
+	return details
+}
+
+// stringsDiff returns the items of to missing from from, and the items of
+// from missing from to.
+func stringsDiff(from, to []string) (added, removed []string) {
+	for _, item := range to {
+		if !containsString(from, item) {
+			added = append(added, item)
+		}
+	}
+	for _, item := range from {
+		if !containsString(to, item) {
+			removed = append(removed, item)
+		}
+	}
+	return added, removed
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// listChange describes the items added to and removed from a list,
+// returning nil when there's none.
+func listChange(field string, added, removed []string) []string {
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	var items []string
+	for _, item := range added {
+		items = append(items, "+"+item)
+	}
+	for _, item := range removed {
+		items = append(items, "-"+item)
+	}
+	return []string{fmt.Sprintf("%s: %s", field, strings.Join(items, " "))}
+}
+
+// poolTitle is the title of the configurations set per pool, the empty pool
+// being the default configuration.
+func poolTitle(kind, pool string) string {
+	if pool == "" {
+		return "default " + kind
+	}
+	return fmt.Sprintf("%s of pool %q", kind, pool)
+}
+
+func diffPools(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]provision.Pool, len(live.Pools))
+	for _, pool := range live.Pools {
+		current[pool.Name] = pool
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.Pools))
+	for _, pool := range desired.Pools {
+		pool := pool
+		wanted[pool.Name] = true
+		title := fmt.Sprintf("pool %q", pool.Name)
+		old, ok := current[pool.Name]
+		if !ok {
+			changes = append(changes, clusterChange{action: changeCreate, title: title, apply: func(client *cmd.Client) error {
+				return createPool(client, pool)
+			}})
+			continue
+		}
+		added, removed := stringsDiff(old.Teams, pool.Teams)
+		details := valueChange("public", old.Public, pool.Public)
+		details = append(details, valueChange("default", old.Default, pool.Default)...)
+		details = append(details, listChange("teams", added, removed)...)
+		if len(details) > 0 {
+			changes = append(changes, clusterChange{action: changeUpdate, title: title, details: details, apply: func(client *cmd.Client) error {
+				return updatePool(client, old, pool)
+			}})
+		}
+	}
+	for _, pool := range live.Pools {
+		if !wanted[pool.Name] {
+			changes = append(changes, removeChange(fmt.Sprintf("pool %q", pool.Name), "/pools/"+pool.Name))
+		}
+	}
+	return changes
+}
+
+// createPool creates the pool, forcing it to replace the current default
+// pool when it's the default one.
+func createPool(client *cmd.Client, pool provision.Pool) error {
+	v := url.Values{}
+	v.Set("name", pool.Name)
+	v.Set("public", strconv.FormatBool(pool.Public))
+	v.Set("default", strconv.FormatBool(pool.Default))
+	v.Set("force", "true")
+	if err := sendForm(client, "POST", "/pools", v); err != nil {
+		return err
+	}
+	if len(pool.Teams) == 0 {
+		return nil
+	}
+	return sendForm(client, "POST", "/pools/"+pool.Name+"/team", url.Values{"team": pool.Teams})
+}
+
+func updatePool(client *cmd.Client, old, pool provision.Pool) error {
+	if old.Public != pool.Public || old.Default != pool.Default {
+		v := url.Values{}
+		v.Set("public", strconv.FormatBool(pool.Public))
+		v.Set("default", strconv.FormatBool(pool.Default))
+		v.Set("force", "true")
+		if err := sendForm(client, "PUT", "/pools/"+pool.Name, v); err != nil {
+			return err
+		}
+	}
+	added, removed := stringsDiff(old.Teams, pool.Teams)
+	if len(added) > 0 {
+		if err := sendForm(client, "POST", "/pools/"+pool.Name+"/team", url.Values{"team": added}); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		v := url.Values{"team": removed}
+		return sendForm(client, "DELETE", "/pools/"+pool.Name+"/team?"+v.Encode(), nil)
+	}
+	return nil
+}
+
+func diffPlans(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]app.Plan, len(live.Plans))
+	for _, plan := range live.Plans {
+		current[plan.Name] = plan
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.Plans))
+	for _, plan := range desired.Plans {
+		plan := plan
+		wanted[plan.Name] = true
+		title := fmt.Sprintf("plan %q", plan.Name)
+		old, ok := current[plan.Name]
+		if !ok {
+			changes = append(changes, clusterChange{action: changeCreate, title: title, apply: func(client *cmd.Client) error {
+				return createPlan(client, plan)
+			}})
+			continue
+		}
+		details := valueChange("memory", old.Memory, plan.Memory)
+		details = append(details, valueChange("swap", old.Swap, plan.Swap)...)
+		details = append(details, valueChange("cpushare", old.CpuShare, plan.CpuShare)...)
+		details = append(details, valueChange("default", old.Default, plan.Default)...)
+		details = append(details, valueChange("router", old.Router, plan.Router)...)
+		if len(details) == 0 {
+			continue
+		}
+		// Plans can't be updated through the tsuru API.
+		details = append(details, "(the plan is removed and created again)")
+		changes = append(changes, clusterChange{action: changeUpdate, title: title, details: details, apply: func(client *cmd.Client) error {
+			if err := sendForm(client, "DELETE", "/plans/"+plan.Name, nil); err != nil {
+				return err
+			}
+			return createPlan(client, plan)
+		}})
+	}
+	for _, plan := range live.Plans {
+		if !wanted[plan.Name] {
+			changes = append(changes, removeChange(fmt.Sprintf("plan %q", plan.Name), "/plans/"+plan.Name))
+		}
+	}
+	return changes
+}
+
+func createPlan(client *cmd.Client, plan app.Plan) error {
+	v := url.Values{}
+	v.Set("name", plan.Name)
+	v.Set("memory", strconv.FormatInt(plan.Memory, 10))
+	v.Set("swap", strconv.FormatInt(plan.Swap, 10))
+	v.Set("cpushare", strconv.Itoa(plan.CpuShare))
+	v.Set("default", strconv.FormatBool(plan.Default))
+	v.Set("router", plan.Router)
+	return sendForm(client, "POST", "/plans", v)
+}
+
+func diffPlatforms(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]app.Platform, len(live.Platforms))
+	for _, platform := range live.Platforms {
+		current[platform.Name] = platform
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.Platforms))
+	for _, platform := range desired.Platforms {
+		platform := platform
+		wanted[platform.Name] = true
+		title := fmt.Sprintf("platform %q", platform.Name)
+		old, ok := current[platform.Name]
+		if !ok {
+			changes = append(changes, clusterChange{
+				action:  changeCreate,
+				title:   title,
+				details: []string{"image: tsuru/" + platform.Name},
+				apply: func(client *cmd.Client) error {
+					if err := sendPlatform(client, "POST", "/platforms", platform.Name, ""); err != nil {
+						return err
+					}
+					if !platform.Disabled {
+						return nil
+					}
+					return sendPlatform(client, "PUT", "/platforms/"+platform.Name, platform.Name, "true")
+				},
+			})
+			continue
+		}
+		if old.Disabled != platform.Disabled {
+			disabled := strconv.FormatBool(platform.Disabled)
+			changes = append(changes, clusterChange{
+				action:  changeUpdate,
+				title:   title,
+				details: valueChange("disabled", old.Disabled, platform.Disabled),
+				apply: func(client *cmd.Client) error {
+					return sendPlatform(client, "PUT", "/platforms/"+platform.Name, platform.Name, disabled)
+				},
+			})
+		}
+	}
+	for _, platform := range live.Platforms {
+		if !wanted[platform.Name] {
+			changes = append(changes, removeChange(fmt.Sprintf("platform %q", platform.Name), "/platforms/"+platform.Name))
+		}
+	}
+	return changes
+}
+
+// sendPlatform adds a platform, building it from its official image, or
+// enables or disables it, without building it again.
+func sendPlatform(client *cmd.Client, method, path, name, disabled string) error {
+	var body bytes.Buffer
+	writer, err := serializeDockerfile(name, &body, "", "", method == "POST")
+	if err != nil {
+		return err
+	}
+	if method == "POST" {
+		writer.WriteField("name", name)
+	} else {
+		writer.WriteField("disabled", disabled)
+	}
+	writer.Close()
+	u, err := cmd.GetURL(path)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+	return sendStream(client, request)
+}
+
+func diffTemplates(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]*iaas.Template, len(live.Templates))
+	for i := range live.Templates {
+		current[live.Templates[i].Name] = &live.Templates[i]
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.Templates))
+	for i := range desired.Templates {
+		template := &desired.Templates[i]
+		wanted[template.Name] = true
+		title := fmt.Sprintf("machine template %q", template.Name)
+		old := current[template.Name]
+		if missing := restoreRedactedParams(template, old); len(missing) > 0 {
+			changes = append(changes, clusterChange{
+				action:  changeSkip,
+				title:   title,
+				details: []string{fmt.Sprintf("(the values of %s were redacted by cluster-export)", strings.Join(missing, ", "))},
+			})
+			continue
+		}
+		if old == nil {
+			changes = append(changes, clusterChange{action: changeCreate, title: title, apply: func(client *cmd.Client) error {
+				return createTemplate(client, template)
+			}})
+			continue
+		}
+		details := valueChange("iaas", old.IaaSName, template.IaaSName)
+		details = append(details, mapChanges("param", templateParams(old), templateParams(template))...)
+		if len(details) == 0 {
+			continue
+		}
+		if old.IaaSName != template.IaaSName {
+			details = append(details, "(the template is removed and created again)")
+			changes = append(changes, clusterChange{action: changeUpdate, title: title, details: details, apply: func(client *cmd.Client) error {
+				return replaceTemplate(client, old, template)
+			}})
+			continue
+		}
+		update := &iaas.Template{Name: template.Name, Data: templateChanges(old, template)}
+		changes = append(changes, clusterChange{action: changeUpdate, title: title, details: details, apply: func(client *cmd.Client) error {
+			return updateTemplate(client, update)
+		}})
+	}
+	for _, template := range live.Templates {
+		if !wanted[template.Name] {
+			changes = append(changes, removeChange(fmt.Sprintf("machine template %q", template.Name), "/iaas/templates/"+template.Name))
+		}
+	}
+	return changes
+}
+
+// restoreRedactedParams replaces the parameters of the template redacted by
+// cluster-export with their current values, returning the names of the ones
+// the current template doesn't have.
+func restoreRedactedParams(template, current *iaas.Template) []string {
+	params := templateParams(current)
+	var missing []string
+	for i, data := range template.Data {
+		if data.Value != redactedValue {
+			continue
+		}
+		if value, ok := params[data.Name]; ok {
+			template.Data[i].Value = value
+		} else {
+			missing = append(missing, data.Name)
+		}
+	}
+	return missing
+}
+
+func diffRoles(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]clusterRole, len(live.Roles))
+	for _, role := range live.Roles {
+		current[role.Name] = role
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.Roles))
+	for _, role := range desired.Roles {
+		role := role
+		wanted[role.Name] = true
+		title := fmt.Sprintf("role %q", role.Name)
+		old, ok := current[role.Name]
+		if !ok {
+			changes = append(changes, clusterChange{action: changeCreate, title: title, apply: func(client *cmd.Client) error {
+				v := url.Values{}
+				v.Set("name", role.Name)
+				v.Set("context", role.Context)
+				v.Set("description", role.Description)
+				if err := sendForm(client, "POST", "/roles", v); err != nil {
+					return err
+				}
+				return updateRole(client, clusterRole{Name: role.Name}, role)
+			}})
+			continue
+		}
+		// Removing the role for creating it again would dissociate it from
+		// its users.
+		unsupported := valueChange("context", old.Context, role.Context)
+		unsupported = append(unsupported, valueChange("description", old.Description, role.Description)...)
+		if len(unsupported) > 0 {
+			changes = append(changes, clusterChange{
+				action:  changeSkip,
+				title:   title,
+				details: append(unsupported, "(the context and the description of roles can't be changed)"),
+			})
+		}
+		addedPerms, removedPerms := stringsDiff(old.Permissions, role.Permissions)
+		addedEvents, removedEvents := stringsDiff(old.Events, role.Events)
+		details := listChange("permissions", addedPerms, removedPerms)
+		details = append(details, listChange("events", addedEvents, removedEvents)...)
+		if len(details) > 0 {
+			changes = append(changes, clusterChange{action: changeUpdate, title: title, details: details, apply: func(client *cmd.Client) error {
+				return updateRole(client, old, role)
+			}})
+		}
+	}
+	for _, role := range live.Roles {
+		if !wanted[role.Name] {
+			changes = append(changes, removeChange(fmt.Sprintf("role %q", role.Name), "/roles/"+role.Name))
+		}
+	}
+	return changes
+}
+
+// updateRole adds and removes the permissions of the role and the events
+// it's assigned by default on.
+func updateRole(client *cmd.Client, old, role clusterRole) error {
+	added, removed := stringsDiff(old.Permissions, role.Permissions)
+	if len(added) > 0 {
+		if err := sendForm(client, "POST", "/roles/"+role.Name+"/permissions", url.Values{"permission": added}); err != nil {
+			return err
+		}
+	}
+	for _, permission := range removed {
+		if err := sendForm(client, "DELETE", "/roles/"+role.Name+"/permissions/"+permission, nil); err != nil {
+			return err
+		}
+	}
+	added, removed = stringsDiff(old.Events, role.Events)
+	if len(added) > 0 {
+		v := url.Values{}
+		for _, event := range added {
+			v.Add(event, role.Name)
+		}
+		if err := sendForm(client, "POST", "/role/default", v); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		v := url.Values{}
+		for _, event := range removed {
+			v.Add(event, role.Name)
+		}
+		return sendForm(client, "DELETE", "/role/default?"+v.Encode(), nil)
+	}
+	return nil
+}
+
+func diffNodeContainers(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]nodecontainer.NodeContainerConfigGroup, len(live.NodeContainers))
+	for _, group := range live.NodeContainers {
+		current[group.Name] = group
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.NodeContainers))
+	for _, group := range desired.NodeContainers {
+		wanted[group.Name] = true
+		title := fmt.Sprintf("node container %q", group.Name)
+		old, ok := current[group.Name]
+		var details, set, unset []string
+		for _, pool := range nodeContainerPools(group) {
+			oldConfig, inOld := old.ConfigPools[pool]
+			switch {
+			case !inOld:
+				set = append(set, pool)
+				details = append(details, nodeContainerPoolTitle(pool)+": added")
+			case !sameNodeContainerConfig(oldConfig, group.ConfigPools[pool]):
+				set = append(set, pool)
+				details = append(details, nodeContainerPoolTitle(pool)+": changed")
+			}
+		}
+		for _, pool := range nodeContainerPools(old) {
+			if _, ok := group.ConfigPools[pool]; !ok {
+				unset = append(unset, pool)
+				details = append(details, nodeContainerPoolTitle(pool)+": removed")
+			}
+		}
+		change := clusterChange{action: changeUpdate, title: title, details: details}
+		if !ok {
+			change.action, change.details = changeCreate, nil
+		}
+		if len(details) > 0 {
+			change.apply = applyNodeContainer(group, set, unset)
+			changes = append(changes, change)
+		}
+	}
+	for _, group := range live.NodeContainers {
+		if !wanted[group.Name] {
+			changes = append(changes, clusterChange{
+				action: changeRemove,
+				title:  fmt.Sprintf("node container %q", group.Name),
+				apply:  applyNodeContainer(group, nil, nodeContainerPools(group)),
+			})
+		}
+	}
+	return changes
+}
+
+// nodeContainerPools returns the pools the node container is configured
+// for, the default configuration, with the empty pool, coming first.
+func nodeContainerPools(group nodecontainer.NodeContainerConfigGroup) []string {
+	pools := make([]string, 0, len(group.ConfigPools))
+	for pool := range group.ConfigPools {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+func nodeContainerPoolTitle(pool string) string {
+	if pool == "" {
+		return "default configuration"
+	}
+	return fmt.Sprintf("pool %q", pool)
+}
+
+// sameNodeContainerConfig compares the configurations by their JSON
+// representation, as empty and missing values are the same for the API.
+func sameNodeContainerConfig(a, b nodecontainer.NodeContainerConfig) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// applyNodeContainer sets the configuration of the node container for the
+// pools in set and removes it from the pools in unset. The default
+// configuration is set before and removed after the ones of the pools.
+func applyNodeContainer(group nodecontainer.NodeContainerConfigGroup, set, unset []string) func(client *cmd.Client) error {
+	return func(client *cmd.Client) error {
+		for _, pool := range set {
+			config := group.ConfigPools[pool]
+			config.Name = group.Name
+			v, err := form.EncodeToValues(config)
+			if err != nil {
+				return err
+			}
+			v.Set("pool", pool)
+			if err = sendForm(client, "POST", "/docker/nodecontainers", v); err != nil {
+				return err
+			}
+		}
+		for i := len(unset) - 1; i >= 0; i-- {
+			v := url.Values{}
+			v.Set("pool", unset[i])
+			if err := sendForm(client, "DELETE", "/docker/nodecontainers/"+group.Name+"?"+v.Encode(), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func diffNodeHealing(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]clusterHealing, len(live.NodeHealing))
+	for _, healing := range live.NodeHealing {
+		current[healing.Pool] = healing
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.NodeHealing))
+	for _, healing := range desired.NodeHealing {
+		healing := healing
+		wanted[healing.Pool] = true
+		title := poolTitle("node healing configuration", healing.Pool)
+		old, ok := current[healing.Pool]
+		if !ok {
+			changes = append(changes, clusterChange{action: changeCreate, title: title, apply: func(client *cmd.Client) error {
+				return updateNodeHealing(client, clusterHealing{}, healing)
+			}})
+			continue
+		}
+		details := valueChange("enabled", optionalValue(old.Enabled), optionalValue(healing.Enabled))
+		details = append(details, valueChange("max time since success", optionalValue(old.MaxTimeSinceSuccess), optionalValue(healing.MaxTimeSinceSuccess))...)
+		details = append(details, valueChange("max unresponsive time", optionalValue(old.MaxUnresponsiveTime), optionalValue(healing.MaxUnresponsiveTime))...)
+		if len(details) > 0 {
+			changes = append(changes, clusterChange{action: changeUpdate, title: title, details: details, apply: func(client *cmd.Client) error {
+				return updateNodeHealing(client, old, healing)
+			}})
+		}
+	}
+	for _, healing := range live.NodeHealing {
+		if !wanted[healing.Pool] {
+			v := url.Values{}
+			v.Set("pool", healing.Pool)
+			changes = append(changes, removeChange(poolTitle("node healing configuration", healing.Pool), "/docker/healing/node?"+v.Encode()))
+		}
+	}
+	return changes
+}
+
+// optionalValue returns the value pointed by a *bool or an *int, or
+// unsetValue when it's nil.
+func optionalValue(value interface{}) string {
+	switch v := value.(type) {
+	case *bool:
+		if v != nil {
+			return strconv.FormatBool(*v)
+		}
+	case *int:
+		if v != nil {
+			return strconv.Itoa(*v)
+		}
+	}
+	return unsetValue
+}
+
+// updateNodeHealing sets the values of the healing configuration of the
+// pool, removing the ones not set anymore, so they're inherited from the
+// default configuration.
+func updateNodeHealing(client *cmd.Client, old, healing clusterHealing) error {
+	v := url.Values{}
+	v.Set("pool", healing.Pool)
+	if healing.Enabled != nil {
+		v.Set("Enabled", strconv.FormatBool(*healing.Enabled))
+	}
+	if healing.MaxTimeSinceSuccess != nil {
+		v.Set("MaxTimeSinceSuccess", strconv.Itoa(*healing.MaxTimeSinceSuccess))
+	}
+	if healing.MaxUnresponsiveTime != nil {
+		v.Set("MaxUnresponsiveTime", strconv.Itoa(*healing.MaxUnresponsiveTime))
+	}
+	if err := sendForm(client, "POST", "/docker/healing/node", v); err != nil {
+		return err
+	}
+	removed := url.Values{}
+	if old.Enabled != nil && healing.Enabled == nil {
+		removed.Add("name", "Enabled")
+	}
+	if old.MaxTimeSinceSuccess != nil && healing.MaxTimeSinceSuccess == nil {
+		removed.Add("name", "MaxTimeSinceSuccess")
+	}
+	if old.MaxUnresponsiveTime != nil && healing.MaxUnresponsiveTime == nil {
+		removed.Add("name", "MaxUnresponsiveTime")
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	removed.Set("pool", healing.Pool)
+	return sendForm(client, "DELETE", "/docker/healing/node?"+removed.Encode(), nil)
+}
+
+func autoScaleRuleTitle(filter string) string {
+	if filter == "" {
+		return "default autoscale rule"
+	}
+	return fmt.Sprintf("autoscale rule %q", filter)
+}
+
+func diffAutoScaleRules(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]clusterAutoScaleRule, len(live.AutoScaleRules))
+	for _, rule := range live.AutoScaleRules {
+		current[rule.MetadataFilter] = rule
+	}
+	var changes []clusterChange
+	wanted := make(map[string]bool, len(desired.AutoScaleRules))
+	for _, rule := range desired.AutoScaleRules {
+		rule := rule
+		wanted[rule.MetadataFilter] = true
+		change := clusterChange{action: changeCreate, title: autoScaleRuleTitle(rule.MetadataFilter)}
+		if old, ok := current[rule.MetadataFilter]; ok {
+			change.action = changeUpdate
+			change.details = valueChange("max container count", old.MaxContainerCount, rule.MaxContainerCount)
+			change.details = append(change.details, valueChange("scale down ratio", old.ScaleDownRatio, rule.ScaleDownRatio)...)
+			change.details = append(change.details, valueChange("max memory ratio", old.MaxMemoryRatio, rule.MaxMemoryRatio)...)
+			change.details = append(change.details, valueChange("enabled", old.Enabled, rule.Enabled)...)
+			change.details = append(change.details, valueChange("prevent rebalance", old.PreventRebalance, rule.PreventRebalance)...)
+			if len(change.details) == 0 {
+				continue
+			}
+		}
+		// The rules are created or replaced by the same endpoint.
+		change.apply = func(client *cmd.Client) error {
+			v, err := form.EncodeToValues(rule)
+			if err != nil {
+				return err
+			}
+			return sendForm(client, "POST", "/docker/autoscale/rules", v)
+		}
+		changes = append(changes, change)
+	}
+	for _, rule := range live.AutoScaleRules {
+		if !wanted[rule.MetadataFilter] {
+			changes = append(changes, removeChange(autoScaleRuleTitle(rule.MetadataFilter), "/docker/autoscale/rules/"+rule.MetadataFilter))
+		}
+	}
+	return changes
+}
+
+// diffDockerLogs never removes log configurations, as the tsuru API has no
+// endpoint for that.
+func diffDockerLogs(live, desired *clusterSnapshot) []clusterChange {
+	current := make(map[string]clusterLogConfig, len(live.DockerLogs))
+	for _, config := range live.DockerLogs {
+		current[config.Pool] = config
+	}
+	var changes []clusterChange
+	for _, config := range desired.DockerLogs {
+		config := config
+		change := clusterChange{action: changeCreate, title: poolTitle("docker log configuration", config.Pool)}
+		if old, ok := current[config.Pool]; ok {
+			change.action = changeUpdate
+			change.details = valueChange("driver", old.Driver, config.Driver)
+			change.details = append(change.details, mapChanges("log option", old.LogOpts, config.LogOpts)...)
+			if len(change.details) == 0 {
+				continue
+			}
+		}
+		change.apply = func(client *cmd.Client) error {
+			v, err := form.EncodeToValues(container.DockerLogConfig{Driver: config.Driver, LogOpts: config.LogOpts})
+			if err != nil {
+				return err
+			}
+			v.Set("pool", config.Pool)
+			v.Set("restart", "false")
+			u, err := cmd.GetURL("/docker/logs")
+			if err != nil {
+				return err
+			}
+			request, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+			if err != nil {
+				return err
+			}
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return sendStream(client, request)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func diffAppQuotas(live, desired *clusterSnapshot) []clusterChange {
+	return diffQuotas("app", "/apps/", live.AppQuotas, desired.AppQuotas)
+}
+
+func diffUserQuotas(live, desired *clusterSnapshot) []clusterChange {
+	return diffQuotas("user", "/users/", live.UserQuotas, desired.UserQuotas)
+}
+
+// diffQuotas changes the limits of the apps or users in the snapshot. Apps
+// and users are neither created nor removed, so quotas of the ones missing
+// from the target are skipped.
+func diffQuotas(kind, prefix string, live, desired []clusterQuota) []clusterChange {
+	current := make(map[string]clusterQuota, len(live))
+	for _, q := range live {
+		current[q.Name] = q
+	}
+	var changes []clusterChange
+	for _, q := range desired {
+		title := fmt.Sprintf("%s quota %q", kind, q.Name)
+		old, ok := current[q.Name]
+		if !ok {
+			changes = append(changes, clusterChange{
+				action:  changeSkip,
+				title:   title,
+				details: []string{fmt.Sprintf("(the %s doesn't exist)", kind)},
+			})
+			continue
+		}
+		if old.Limit == q.Limit {
+			continue
+		}
+		v := url.Values{}
+		v.Set("limit", strconv.Itoa(q.Limit))
+		path := prefix + pathSegment(q.Name) + "/quota"
+		changes = append(changes, clusterChange{
+			action:  changeUpdate,
+			title:   title,
+			details: valueChange("limit", old.Limit, q.Limit),
+			apply: func(client *cmd.Client) error {
+				return sendForm(client, "PUT", path, v)
+			},
+		})
+	}
+	return changes
+}
+
+type clusterApply struct {
+	cmd.ConfirmationCommand
+	dir    string
+	only   string
+	prune  bool
+	dryRun bool
+	fs     *gnuflag.FlagSet
+}
+
+func (c *clusterApply) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "cluster-apply",
+		Usage: "cluster-apply -d <dir> [--only <resources>] [--prune] [--dry-run] [-y]",
+		Desc: `Applies a configuration exported by cluster-export to the tsuru installation.
+
+The files in the directory are compared with the configuration of the target,
+and the plan with the resources to be created, updated and removed is
+displayed before asking for confirmation. Resources are created and updated
+in the order they depend on each other: pools, plans, platforms, machine
+templates, roles, node containers, node healing configurations, autoscale
+rules, docker log configurations, app quotas and user quotas. Removals happen
+in the reverse order.
+
+Resources missing from the files are only removed with the --prune flag.
+Docker log configurations are never removed, and quotas are only changed for
+the apps and users existing in the target. Missing platforms are built from
+their official images, plans are removed and created again when they change
+and machine templates are removed and created again when their IaaS changes,
+as those can't be updated. Machine template parameters redacted by
+[[cluster-export]] keep their current values, and templates are skipped when
+those parameters don't exist in the target.
+
+The --only flag takes a comma-separated list of resources to apply, named
+after their files, for example:
+
+[[tsuru-admin cluster-apply -d cluster/ --only plans,pools]]`,
+		MinArgs: 0,
+		MaxArgs: 0,
+	}
+}
+
+func (c *clusterApply) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		fs := gnuflag.NewFlagSet("cluster-apply", gnuflag.ExitOnError)
+		dir := "Directory with the YAML files written by cluster-export"
+		fs.StringVar(&c.dir, "dir", "", dir)
+		fs.StringVar(&c.dir, "d", "", dir)
+		fs.StringVar(&c.only, "only", "", "Comma-separated list of resources to apply, e.g. plans,pools")
+		fs.BoolVar(&c.prune, "prune", false, "Remove the resources missing from the files")
+		fs.BoolVar(&c.dryRun, "dry-run", false, "Only display the plan, without applying it")
+		c.fs = cmd.MergeFlagSet(c.ConfirmationCommand.Flags(), fs)
+	}
+	return c.fs
+}
+
+// resources returns the resources selected with --only, in the order
+// they're applied.
+func (c *clusterApply) resources() ([]clusterResource, error) {
+	if c.only == "" {
+		return clusterResources, nil
+	}
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(c.only, ",") {
+		selected[strings.TrimSpace(name)] = true
+	}
+	var resources []clusterResource
+	for _, resource := range clusterResources {
+		if selected[resource.name] {
+			resources = append(resources, resource)
+			delete(selected, resource.name)
+		}
+	}
+	for name := range selected {
+		names := make([]string, len(clusterResources))
+		for i, resource := range clusterResources {
+			names[i] = resource.name
+		}
+		return nil, fmt.Errorf("unknown resource %q, expected one of: %s", name, strings.Join(names, ", "))
+	}
+	return resources, nil
+}
+
+func (c *clusterApply) Run(context *cmd.Context, client *cmd.Client) error {
+	if c.dir == "" {
+		return errors.New("the snapshot directory is required (-d/--dir)")
+	}
+	resources, err := c.resources()
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(c.dir); err != nil {
+		return err
+	}
+	var live, desired clusterSnapshot
+	var changes, removals []clusterChange
+	counts := make(map[string]int)
+	for _, resource := range resources {
+		err = resource.read(c.dir, &desired)
+		if os.IsNotExist(err) && c.only == "" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = resource.fetch(client, &live); err != nil {
+			return fmt.Errorf("unable to fetch %s: %s", resource.desc, err)
+		}
+		for _, change := range resource.diff(&live, &desired) {
+			counts[change.action]++
+			if change.action == changeRemove {
+				if !c.prune {
+					continue
+				}
+				removals = append(removals, change)
+			}
+			change.write(context.Stdout)
+			if change.action != changeRemove && change.action != changeSkip {
+				changes = append(changes, change)
+			}
+		}
+	}
+	nothingToDo := len(changes) == 0 && len(removals) == 0
+	if nothingToDo {
+		fmt.Fprintln(context.Stdout, "No changes, the cluster already matches the snapshot.")
+	} else {
+		fmt.Fprintf(context.Stdout, "\nPlan: %d to create, %d to update, %d to remove.\n", counts[changeCreate], counts[changeUpdate], len(removals))
+	}
+	if kept := counts[changeRemove] - len(removals); kept > 0 {
+		fmt.Fprintf(context.Stdout, "%d resource(s) missing from the snapshot were kept, use --prune to remove them.\n", kept)
+	}
+	if nothingToDo || c.dryRun || !c.Confirm(context, "Apply the plan?") {
+		return nil
+	}
+	// Resources are removed after the others, in the reverse order, as
+	// they may be used by the ones depending on them.
+	for i := len(removals) - 1; i >= 0; i-- {
+		changes = append(changes, removals[i])
+	}
+	for _, change := range changes {
+		if err = change.apply(client); err != nil {
+			fmt.Fprintf(context.Stderr, "Failed to %s %s.\n", change.action, change.title)
+			return err
+		}
+		fmt.Fprintf(context.Stdout, "%s %s.\n", changeResults[change.action], change.title)
+	}
+	return nil
+}
@@ -0,0 +1,417 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/nodecontainer"
+	"gopkg.in/check.v1"
+	"gopkg.in/yaml.v1"
+)
+
+// exportCluster exports the cluster configuration of the fake API to a new
+// directory.
+func exportCluster(c *check.C, api *fakeAPI) string {
+	dir := c.MkDir()
+	api.mustRun(c, "cluster-export", "-o", dir)
+	return dir
+}
+
+func writeClusterFile(c *check.C, dir, name string, value interface{}) {
+	data, err := yaml.Marshal(value)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, name+".yaml"), data, 0644)
+	c.Assert(err, check.IsNil)
+}
+
+// changeRequests returns the requests changing data sent to the fake API.
+func (api *fakeAPI) changeRequests() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var requests []string
+	for _, request := range api.requests {
+		if !strings.HasPrefix(request, "GET ") {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func (s *S) TestClusterApply(c *check.C) {
+	source := newFakeAPI()
+	source.seedCluster()
+	dir := exportCluster(c, source)
+	source.close()
+	api := newFakeAPI()
+	defer api.close()
+	api.addApp(appSummary{Name: "myapp", Pool: "prod"})
+	api.addUser("admin@example.com")
+	api.mu.Lock()
+	api.userQuotas["admin@example.com"].Limit = 10
+	api.mu.Unlock()
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "-y")
+	c.Assert(stdout, check.Equals, `+ pool "prod"
++ pool "public"
++ plan "small"
++ platform "java"
+    image: tsuru/java
++ platform "python"
+    image: tsuru/python
++ machine template "ec2-small"
++ role "deployer"
++ node container "big-sibling"
++ default node healing configuration
++ node healing configuration of pool "prod"
++ autoscale rule "pool=prod"
++ default docker log configuration
+~ app quota "myapp"
+    limit: -1 -> 4
+~ user quota "admin@example.com"
+    limit: 10 -> -1
+
+Plan: 12 to create, 2 to update, 0 to remove.
+Created pool "prod".
+Created pool "public".
+Created plan "small".
+Created platform "java".
+Created platform "python".
+Created machine template "ec2-small".
+Created role "deployer".
+Created node container "big-sibling".
+Created default node healing configuration.
+Created node healing configuration of pool "prod".
+Created autoscale rule "pool=prod".
+Created default docker log configuration.
+Updated app quota "myapp".
+Updated user quota "admin@example.com".
+`)
+	applied := exportCluster(c, api)
+	for _, resource := range clusterResources {
+		expected, err := ioutil.ReadFile(resource.path(dir))
+		c.Assert(err, check.IsNil)
+		data, err := ioutil.ReadFile(resource.path(applied))
+		c.Assert(err, check.IsNil)
+		c.Check(string(data), check.Equals, string(expected), check.Commentf(resource.name))
+	}
+	stdout = api.mustRun(c, "cluster-apply", "-d", dir, "-y")
+	c.Assert(stdout, check.Equals, "No changes, the cluster already matches the snapshot.\n")
+}
+
+func (s *S) TestClusterApplyUpdates(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.seedCluster()
+	dir := exportCluster(c, api)
+	writeClusterFile(c, dir, "pools", []provision.Pool{
+		{Name: "prod", Teams: []string{"ops", "qa"}, Public: false, Default: true},
+		{Name: "public", Public: true},
+	})
+	writeClusterFile(c, dir, "plans", []app.Plan{{Name: "small", Memory: 1073741824, CpuShare: 2, Default: true}})
+	writeClusterFile(c, dir, "platforms", []app.Platform{{Name: "java"}, {Name: "python", Disabled: true}})
+	writeClusterFile(c, dir, "machine-templates", []iaas.Template{{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-west-2"},
+		{Name: "subnet", Value: "sub-1"},
+	}}})
+	writeClusterFile(c, dir, "roles", []clusterRole{{
+		Name:        "deployer",
+		Context:     "global",
+		Description: "Deploys apps",
+		Permissions: []string{"app.deploy", "app.update"},
+		Events:      []string{"team-create", "user-create"},
+	}})
+	writeClusterFile(c, dir, "node-healing", []clusterHealing{
+		{Enabled: boolPtr(true), MaxTimeSinceSuccess: intPtr(60), MaxUnresponsiveTime: intPtr(300)},
+		{Pool: "prod", Enabled: boolPtr(false)},
+	})
+	writeClusterFile(c, dir, "autoscale-rules", []clusterAutoScaleRule{{MetadataFilter: "pool=prod", MaxContainerCount: 20, ScaleDownRatio: 1.5, Enabled: true}})
+	writeClusterFile(c, dir, "docker-logs", []clusterLogConfig{{Driver: "syslog", LogOpts: map[string]string{"syslog-address": "udp://logs:514", "tag": "app"}}})
+	writeClusterFile(c, dir, "app-quotas", []clusterQuota{{Name: "myapp", Limit: 4}, {Name: "other", Limit: 2}})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "-y")
+	c.Assert(stdout, check.Equals, `~ pool "prod"
+    default: false -> true
+    teams: +qa -dev
+~ pool "public"
+    default: true -> false
+~ plan "small"
+    memory: 536870912 -> 1073741824
+    (the plan is removed and created again)
+~ platform "java"
+    disabled: true -> false
+~ platform "python"
+    disabled: false -> true
+~ machine template "ec2-small"
+    param image: ami-123 -> (unset)
+    param region: us-east-1 -> us-west-2
+    param subnet: (unset) -> sub-1
+! role "deployer"
+    context: team -> global
+    (the context and the description of roles can't be changed)
+~ role "deployer"
+    permissions: +app.update -app.read
+    events: +user-create
+~ node healing configuration of pool "prod"
+    enabled: (unset) -> false
+    max unresponsive time: 120 -> (unset)
+~ autoscale rule "pool=prod"
+    max container count: 10 -> 20
+~ default docker log configuration
+    log option syslog-address: udp://localhost:514 -> udp://logs:514
+    log option tag: (unset) -> app
+! app quota "other"
+    (the app doesn't exist)
+
+Plan: 0 to create, 10 to update, 0 to remove.
+Updated pool "prod".
+Updated pool "public".
+Updated plan "small".
+Updated platform "java".
+Updated platform "python".
+Updated machine template "ec2-small".
+Updated role "deployer".
+Updated node healing configuration of pool "prod".
+Updated autoscale rule "pool=prod".
+Updated default docker log configuration.
+`)
+	applied := exportCluster(c, api)
+	for _, name := range []string{"pools", "plans", "platforms", "machine-templates", "node-healing", "autoscale-rules", "docker-logs"} {
+		expected, err := ioutil.ReadFile(filepath.Join(dir, name+".yaml"))
+		c.Assert(err, check.IsNil)
+		data, err := ioutil.ReadFile(filepath.Join(applied, name+".yaml"))
+		c.Assert(err, check.IsNil)
+		c.Check(string(data), check.Equals, string(expected), check.Commentf(name))
+	}
+	var roles []clusterRole
+	readClusterFile(c, applied, "roles", &roles)
+	c.Assert(roles, check.DeepEquals, []clusterRole{{
+		Name:        "deployer",
+		Context:     "team",
+		Description: "Deploys apps",
+		Permissions: []string{"app.deploy", "app.update"},
+		Events:      []string{"team-create", "user-create"},
+	}})
+}
+
+func (s *S) TestClusterApplyTemplateIaaS(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.templates["ec2-small"] = iaas.Template{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-east-1"},
+	}}
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "machine-templates", []iaas.Template{{Name: "ec2-small", IaaSName: "ec2-west", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-west-2"},
+	}}})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "--only", "machine-templates", "-y")
+	c.Assert(stdout, check.Equals, `~ machine template "ec2-small"
+    iaas: ec2 -> ec2-west
+    param region: us-east-1 -> us-west-2
+    (the template is removed and created again)
+
+Plan: 0 to create, 1 to update, 0 to remove.
+Updated machine template "ec2-small".
+`)
+	c.Assert(api.changeRequests(), check.DeepEquals, []string{
+		"DELETE /iaas/templates/ec2-small",
+		"POST /iaas/templates",
+	})
+	c.Assert(api.templates["ec2-small"], check.DeepEquals, iaas.Template{Name: "ec2-small", IaaSName: "ec2-west", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-west-2"},
+	}})
+}
+
+func (s *S) TestClusterApplyRedactedParams(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.templates["ec2-small"] = iaas.Template{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-east-1"},
+		{Name: "secret-key", Value: "XYZ"},
+	}}
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "machine-templates", []iaas.Template{
+		{Name: "ec2-large", IaaSName: "ec2", Data: iaas.TemplateDataList{
+			{Name: "secret-key", Value: "*****"},
+		}},
+		{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+			{Name: "region", Value: "us-west-2"},
+			{Name: "secret-key", Value: "*****"},
+		}},
+	})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "--only", "machine-templates", "-y")
+	c.Assert(stdout, check.Equals, `! machine template "ec2-large"
+    (the values of secret-key were redacted by cluster-export)
+~ machine template "ec2-small"
+    param region: us-east-1 -> us-west-2
+
+Plan: 0 to create, 1 to update, 0 to remove.
+Updated machine template "ec2-small".
+`)
+	c.Assert(api.templates["ec2-small"], check.DeepEquals, iaas.Template{Name: "ec2-small", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "region", Value: "us-west-2"},
+		{Name: "secret-key", Value: "XYZ"},
+	}})
+	_, ok := api.templates["ec2-large"]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestClusterApplyEscapesNames(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.addUser("john doe@example.com")
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "user-quotas", []clusterQuota{{Name: "john doe@example.com", Limit: 5}})
+	api.mustRun(c, "cluster-apply", "-d", dir, "--only", "user-quotas", "-y")
+	c.Assert(api.userQuotas["john doe@example.com"].Limit, check.Equals, 5)
+}
+
+func (s *S) TestClusterApplyPrune(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.seedCluster()
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "pools", []provision.Pool{{Name: "public", Public: true, Default: true}})
+	writeClusterFile(c, dir, "plans", []app.Plan{})
+	writeClusterFile(c, dir, "node-containers", []interface{}{})
+	writeClusterFile(c, dir, "autoscale-rules", []clusterAutoScaleRule{})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "-y")
+	c.Assert(stdout, check.Equals, "No changes, the cluster already matches the snapshot.\n"+
+		"4 resource(s) missing from the snapshot were kept, use --prune to remove them.\n")
+	c.Assert(api.changeRequests(), check.HasLen, 0)
+	stdout = api.mustRun(c, "cluster-apply", "-d", dir, "--prune", "-y")
+	c.Assert(stdout, check.Equals, `- pool "prod"
+- plan "small"
+- node container "big-sibling"
+- autoscale rule "pool=prod"
+
+Plan: 0 to create, 0 to update, 4 to remove.
+Removed autoscale rule "pool=prod".
+Removed node container "big-sibling".
+Removed plan "small".
+Removed pool "prod".
+`)
+	c.Assert(api.changeRequests(), check.DeepEquals, []string{
+		"DELETE /docker/autoscale/rules/pool=prod",
+		"DELETE /docker/nodecontainers/big-sibling",
+		"DELETE /plans/small",
+		"DELETE /pools/prod",
+	})
+	c.Assert(api.pools, check.HasLen, 1)
+	c.Assert(api.plans, check.HasLen, 0)
+	c.Assert(api.nodeContainers, check.HasLen, 0)
+	c.Assert(api.autoScaleRules, check.HasLen, 0)
+}
+
+func (s *S) TestClusterApplyOnly(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "pools", []provision.Pool{{Name: "prod"}})
+	writeClusterFile(c, dir, "plans", []app.Plan{{Name: "small", Memory: 536870912, CpuShare: 2}})
+	writeClusterFile(c, dir, "platforms", []app.Platform{{Name: "python"}})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "--only", "plans,pools", "-y")
+	c.Assert(stdout, check.Equals, `+ pool "prod"
++ plan "small"
+
+Plan: 2 to create, 0 to update, 0 to remove.
+Created pool "prod".
+Created plan "small".
+`)
+	c.Assert(api.platforms, check.HasLen, 0)
+	_, err := api.run("cluster-apply", "-d", dir, "--only", "plans,roles")
+	c.Assert(err, check.ErrorMatches, "open .*/roles.yaml: no such file or directory")
+	_, err = api.run("cluster-apply", "-d", dir, "--only", "plans,teams")
+	c.Assert(err, check.ErrorMatches, `unknown resource "teams", expected one of: pools, plans, platforms, .*, user-quotas`)
+}
+
+func (s *S) TestClusterApplyDryRun(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "plans", []app.Plan{{Name: "small", Memory: 536870912, CpuShare: 2}})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "--dry-run")
+	c.Assert(stdout, check.Equals, "+ plan \"small\"\n\nPlan: 1 to create, 0 to update, 0 to remove.\n")
+	c.Assert(api.changeRequests(), check.HasLen, 0)
+}
+
+func (s *S) TestClusterApplyAbort(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "plans", []app.Plan{{Name: "small", Memory: 536870912, CpuShare: 2}})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir)
+	c.Assert(stdout, check.Equals, "+ plan \"small\"\n\nPlan: 1 to create, 0 to update, 0 to remove.\n"+
+		"Apply the plan? (y/n) Abort.\n")
+	c.Assert(api.changeRequests(), check.HasLen, 0)
+}
+
+func (s *S) TestClusterApplyFailure(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "plans", []app.Plan{
+		{Name: "small", Memory: 536870912, CpuShare: 2},
+		{Name: "tiny", Memory: 536870912, CpuShare: 1},
+		{Name: "xlarge", Memory: 536870912, CpuShare: 8},
+	})
+	stdout, err := api.run("cluster-apply", "-d", dir, "-y")
+	c.Assert(err, check.ErrorMatches, "invalid cpushare value\n")
+	c.Assert(exitCode(err), check.Equals, exitError)
+	c.Assert(stdout, check.Matches, `(?s).*Created plan "small".\n$`)
+	c.Assert(api.plans, check.HasLen, 1)
+}
+
+func (s *S) TestClusterApplyRequiresDirectory(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	_, err := api.run("cluster-apply")
+	c.Assert(err, check.ErrorMatches, `the snapshot directory is required \(-d/--dir\)`)
+	_, err = api.run("cluster-apply", "-d", filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, check.ErrorMatches, "stat .*/missing: no such file or directory")
+}
+
+func (s *S) TestClusterApplyNodeContainerPools(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	dir := c.MkDir()
+	writeClusterFile(c, dir, "node-containers", []nodecontainer.NodeContainerConfigGroup{{
+		Name: "big-sibling",
+		ConfigPools: map[string]nodecontainer.NodeContainerConfig{
+			"":     {Name: "big-sibling", Config: docker.Config{Image: "tsuru/bs:v1"}},
+			"prod": {Name: "big-sibling", Config: docker.Config{Env: []string{"A=1"}}},
+		},
+	}})
+	api.mustRun(c, "cluster-apply", "-d", dir, "--only", "node-containers", "-y")
+	c.Assert(api.changeRequests(), check.DeepEquals, []string{
+		"POST /docker/nodecontainers",
+		"POST /docker/nodecontainers",
+	})
+	c.Assert(api.nodeContainers["big-sibling"].ConfigPools["prod"].Config.Env, check.DeepEquals, []string{"A=1"})
+	writeClusterFile(c, dir, "node-containers", []nodecontainer.NodeContainerConfigGroup{{
+		Name: "big-sibling",
+		ConfigPools: map[string]nodecontainer.NodeContainerConfig{
+			"": {Name: "big-sibling", Config: docker.Config{Image: "tsuru/bs:v2"}},
+		},
+	}})
+	stdout := api.mustRun(c, "cluster-apply", "-d", dir, "--only", "node-containers", "-y")
+	c.Assert(stdout, check.Equals, `~ node container "big-sibling"
+    default configuration: changed
+    pool "prod": removed
+
+Plan: 0 to create, 1 to update, 0 to remove.
+Updated node container "big-sibling".
+`)
+	c.Assert(api.changeRequests()[2:], check.DeepEquals, []string{
+		"POST /docker/nodecontainers",
+		"DELETE /docker/nodecontainers/big-sibling",
+	})
+	c.Assert(api.nodeContainers["big-sibling"].ConfigPools, check.HasLen, 1)
+	c.Assert(api.nodeContainers["big-sibling"].ConfigPools[""].Config.Image, check.Equals, "tsuru/bs:v2")
+}
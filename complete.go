@@ -0,0 +1,212 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// Kinds of values that can be completed with data from the tsuru API.
+const (
+	completeApps      = "apps"
+	completePools     = "pools"
+	completePlans     = "plans"
+	completeTemplates = "templates"
+	completeMachines  = "machines"
+)
+
+// argumentCompletions maps commands to the kind of their positional
+// arguments.
+var argumentCompletions = map[string]string{
+	"pool-update":             completePools,
+	"pool-remove":             completePools,
+	"pool-teams-add":          completePools,
+	"pool-teams-remove":       completePools,
+	"plan-remove":             completePlans,
+	"machine-template-remove": completeTemplates,
+	"machine-template-update": completeTemplates,
+	"machine-template-copy":   completeTemplates,
+	"machine-template-export": completeTemplates,
+	"machine-destroy":         completeMachines,
+	"machine-info":            completeMachines,
+	"app-quota-view":          completeApps,
+	"app-quota-change":        completeApps,
+}
+
+// flagCompletions maps flags to the kind of their values, regardless of the
+// command.
+var flagCompletions = map[string]string{
+	"a":    completeApps,
+	"app":  completeApps,
+	"pool": completePools,
+	"plan": completePlans,
+}
+
+// completer finds the candidates for completing a word in a command line,
+// fetching names from the tsuru API only once.
+type completer struct {
+	manager *cmd.Manager
+	fetch   func(kind string) ([]string, error)
+	cache   map[string][]string
+}
+
+// candidates returns the sorted candidates starting with word, given the
+// arguments that precede it, which may start with global flags.
+func (c *completer) candidates(args []string, word string) []string {
+	var options []string
+	switch i := commandIndex(append(args[:len(args):len(args)], word)); {
+	case i < 0:
+		// The word is a global flag or the value of one.
+		if strings.HasPrefix(word, "-") {
+			options = globalFlagNames()
+		}
+	case i == len(args):
+		for name, command := range c.manager.Commands {
+			if _, removed := command.(*cmd.RemovedCommand); !removed {
+				options = append(options, name)
+			}
+		}
+	case strings.HasPrefix(word, "-"):
+		options = commandFlags(c.manager, args[i])
+	default:
+		options = c.values(valueKind(c.manager, args[i:]))
+	}
+	var result []string
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			result = append(result, option)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (c *completer) values(kind string) []string {
+	if kind == "" || c.fetch == nil {
+		return nil
+	}
+	if values, ok := c.cache[kind]; ok {
+		return values
+	}
+	values, err := c.fetch(kind)
+	if err != nil {
+		return nil
+	}
+	if c.cache == nil {
+		c.cache = make(map[string][]string)
+	}
+	c.cache[kind] = values
+	return values
+}
+
+// commandFlagSet returns the flags of the command, without changing the
+// registered command.
+func commandFlagSet(manager *cmd.Manager, name string) *gnuflag.FlagSet {
+	command, ok := manager.Commands[name]
+	if !ok {
+		return nil
+	}
+	if flagged, ok := freshCommand(command).(cmd.FlaggedCommand); ok {
+		return flagged.Flags()
+	}
+	return nil
+}
+
+func commandFlags(manager *cmd.Manager, name string) []string {
+	var flags []string
+	if fs := commandFlagSet(manager, name); fs != nil {
+		fs.VisitAll(func(flag *gnuflag.Flag) {
+			flags = append(flags, flagName(flag.Name))
+		})
+	}
+	return flags
+}
+
+func flagName(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+func isBoolFlag(flag *gnuflag.Flag) bool {
+	b, ok := flag.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// valueKind returns the kind of the value following the arguments: the value
+// of a flag, or a positional argument of the command.
+func valueKind(manager *cmd.Manager, args []string) string {
+	last := args[len(args)-1]
+	if len(args) > 1 && strings.HasPrefix(last, "-") && !strings.Contains(last, "=") {
+		name := strings.TrimLeft(last, "-")
+		if fs := commandFlagSet(manager, args[0]); fs != nil {
+			if flag := fs.Lookup(name); flag != nil && !isBoolFlag(flag) {
+				return flagCompletions[name]
+			}
+		}
+	}
+	return argumentCompletions[args[0]]
+}
+
+// fetchNames returns the names of the given kind from the tsuru API.
+func fetchNames(client *cmd.Client, kind string) ([]string, error) {
+	var path string
+	switch kind {
+	case completeApps:
+		path = "/apps"
+	case completePools:
+		path = "/pools"
+	case completePlans:
+		path = "/plans"
+	case completeTemplates:
+		path = "/iaas/templates"
+	case completeMachines:
+		path = "/iaas/machines"
+	default:
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+	url, err := cmd.GetURL(path)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var items []struct {
+		Name string
+		Id   string
+	}
+	err = json.NewDecoder(response.Body).Decode(&items)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.Name
+		if kind == completeMachines {
+			names[i] = item.Id
+		}
+	}
+	return names, nil
+}
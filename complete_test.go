@@ -0,0 +1,98 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func fakeCompleter(fetched *[]string) *completer {
+	values := map[string][]string{
+		completeApps:      {"app1", "app2", "web"},
+		completePools:     {"pool1", "pool2", "theonepool"},
+		completePlans:     {"small", "large"},
+		completeTemplates: {"tpl1"},
+		completeMachines:  {"i-0123", "i-0456"},
+	}
+	return &completer{
+		manager: buildManager("tsuru-admin"),
+		fetch: func(kind string) ([]string, error) {
+			*fetched = append(*fetched, kind)
+			return values[kind], nil
+		},
+	}
+}
+
+func (s *S) TestCompleterCandidates(c *check.C) {
+	var fetched []string
+	comp := fakeCompleter(&fetched)
+	c.Assert(comp.candidates(nil, "pool-"), check.DeepEquals, []string{"pool-add", "pool-remove", "pool-teams-add", "pool-teams-remove", "pool-update"})
+	c.Assert(comp.candidates([]string{"pool-update"}, "po"), check.DeepEquals, []string{"pool1", "pool2"})
+	c.Assert(comp.candidates([]string{"pool-update"}, "--"), check.DeepEquals, []string{"--default", "--force", "--public"})
+	c.Assert(comp.candidates([]string{"plan-remove"}, ""), check.DeepEquals, []string{"large", "small"})
+	c.Assert(comp.candidates([]string{"app-unlock", "-a"}, "app"), check.DeepEquals, []string{"app1", "app2"})
+	c.Assert(comp.candidates([]string{"app-unlock", "-y"}, ""), check.HasLen, 0)
+	c.Assert(comp.candidates([]string{"machine-destroy"}, "i-04"), check.DeepEquals, []string{"i-0456"})
+	c.Assert(comp.candidates([]string{"pool-update"}, "the"), check.DeepEquals, []string{"theonepool"})
+	c.Assert(fetched, check.DeepEquals, []string{completePools, completePlans, completeApps, completeMachines})
+}
+
+func (s *S) TestCompleterCandidatesAfterGlobalFlags(c *check.C) {
+	var fetched []string
+	comp := fakeCompleter(&fetched)
+	c.Assert(comp.candidates([]string{"--targets", "prod"}, "plan-r"), check.DeepEquals, []string{"plan-remove"})
+	c.Assert(comp.candidates([]string{"--dry-run", "--record", "session.har", "-v", "2"}, "plan-r"), check.DeepEquals, []string{"plan-remove"})
+	c.Assert(comp.candidates([]string{"--targets=prod"}, "plan-r"), check.DeepEquals, []string{"plan-remove"})
+	c.Assert(comp.candidates([]string{"--targets", "prod", "pool-update"}, "--p"), check.DeepEquals, []string{"--public"})
+	c.Assert(comp.candidates([]string{"--record", "f", "plan-remove"}, "s"), check.DeepEquals, []string{"small"})
+	c.Assert(comp.candidates([]string{"--record"}, "plan"), check.HasLen, 0)
+	c.Assert(comp.candidates([]string{"--dry-run"}, "--targ"), check.DeepEquals, []string{"--targets"})
+	c.Assert(fetched, check.DeepEquals, []string{completePlans})
+}
+
+func (s *S) TestCompleteLine(c *check.C) {
+	var fetched []string
+	comp := fakeCompleter(&fetched)
+	line, pos, options := completeLine(comp, "plan-rem", 8)
+	c.Assert(line, check.Equals, "plan-remove ")
+	c.Assert(pos, check.Equals, 12)
+	c.Assert(options, check.DeepEquals, []string{"plan-remove"})
+	line, pos, options = completeLine(comp, "app-unlock -a ap -y", 16)
+	c.Assert(line, check.Equals, "app-unlock -a app -y")
+	c.Assert(pos, check.Equals, 17)
+	c.Assert(options, check.DeepEquals, []string{"app1", "app2"})
+	line, pos, options = completeLine(comp, "unknown x", 9)
+	c.Assert(line, check.Equals, "unknown x")
+	c.Assert(pos, check.Equals, 9)
+	c.Assert(options, check.HasLen, 0)
+}
+
+func (s *S) TestFetchNames(c *check.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `[{"Id":"i-0123","Iaas":"ec2"},{"Id":"i-0456","Iaas":"ec2"}]`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/iaas/machines") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	names, err := fetchNames(client, completeMachines)
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"i-0123", "i-0456"})
+	trans = &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `[{"name":"pool1","public":true}]`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/pools") && req.Method == "GET"
+		},
+	}
+	client = cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	names, err = fetchNames(client, completePools)
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"pool1"})
+}
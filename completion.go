@@ -0,0 +1,305 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// completeCommand is the name of the hidden command called by the completion
+// scripts.
+const completeCommand = "__complete"
+
+type completion struct {
+	manager *cmd.Manager
+	program string
+}
+
+func (c *completion) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "completion",
+		Usage:   "completion <bash|zsh|fish>",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Desc: `Prints the completion script for the given shell.
+
+Command names and flags are completed from the commands available in this
+version of tsuru-admin. The names of pools, plans, machine templates,
+machines and apps are fetched from the tsuru API as they're completed.
+
+For loading the completion in the current shell session:
+
+  bash: source <(tsuru-admin completion bash)
+  zsh:  source <(tsuru-admin completion zsh)
+  fish: tsuru-admin completion fish | source`,
+	}
+}
+
+func (c *completion) Run(context *cmd.Context, client *cmd.Client) error {
+	script := completionScript{program: c.program, commands: completionCommands(c.manager)}
+	switch shell := context.Args[0]; shell {
+	case "bash":
+		return script.writeBash(context.Stdout)
+	case "zsh":
+		return script.writeZsh(context.Stdout)
+	case "fish":
+		return script.writeFish(context.Stdout)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+}
+
+// complete prints the candidates for completing a command line, one per
+// line. It receives the words of the command line after "--", the last one
+// being the word under completion, which is completed with data from the
+// tsuru API when needed.
+type complete struct {
+	manager *cmd.Manager
+}
+
+func (c *complete) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    completeCommand,
+		Usage:   completeCommand + " -- <words>...",
+		MinArgs: 1,
+	}
+}
+
+func (c *complete) Run(context *cmd.Context, client *cmd.Client) error {
+	completer := &completer{
+		manager: c.manager,
+		fetch: func(kind string) ([]string, error) {
+			return fetchNames(client, kind)
+		},
+	}
+	last := len(context.Args) - 1
+	for _, candidate := range completer.candidates(context.Args[:last], context.Args[last]) {
+		fmt.Fprintln(context.Stdout, candidate)
+	}
+	return nil
+}
+
+type completionCommand struct {
+	name  string
+	desc  string
+	flags []*gnuflag.Flag
+}
+
+// completionCommands returns the registered commands, sorted by name, along
+// with the first line of their descriptions and their flags.
+func completionCommands(manager *cmd.Manager) []completionCommand {
+	var commands []completionCommand
+	for name, command := range manager.Commands {
+		if _, removed := command.(*cmd.RemovedCommand); removed {
+			continue
+		}
+		desc := strings.TrimSpace(command.Info().Desc)
+		if i := strings.Index(desc, "\n"); i > -1 {
+			desc = desc[:i]
+		}
+		var flags []*gnuflag.Flag
+		if fs := commandFlagSet(manager, name); fs != nil {
+			fs.VisitAll(func(flag *gnuflag.Flag) {
+				flags = append(flags, flag)
+			})
+		}
+		commands = append(commands, completionCommand{name: name, desc: desc, flags: flags})
+	}
+	sort.Sort(completionCommandsByName(commands))
+	return commands
+}
+
+type completionCommandsByName []completionCommand
+
+func (l completionCommandsByName) Len() int           { return len(l) }
+func (l completionCommandsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l completionCommandsByName) Less(i, j int) bool { return l[i].name < l[j].name }
+
+type completionScript struct {
+	program  string
+	commands []completionCommand
+}
+
+var nonIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// function returns the name of the shell function that completes the
+// program, e.g. _tsuru_admin.
+func (s *completionScript) function() string {
+	return "_" + nonIdentifierChars.ReplaceAllString(s.program, "_")
+}
+
+func (s *completionScript) flagNames(command completionCommand) string {
+	names := make([]string, len(command.flags))
+	for i, flag := range command.flags {
+		names[i] = flagName(flag.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// valueFlagsPattern returns a shell case pattern matching the global flags
+// that take the next word as their value, which precede the command name.
+func (s *completionScript) valueFlagsPattern() string {
+	var patterns []string
+	for name := range globalFlags {
+		if takesValue(name) {
+			patterns = append(patterns, "-"+name, "--"+name)
+		}
+	}
+	for name := range managerValueFlags {
+		patterns = append(patterns, "-"+name, "--"+name)
+	}
+	sort.Strings(patterns)
+	return strings.Join(patterns, "|")
+}
+
+func (s *completionScript) writeBash(w io.Writer) error {
+	names := make([]string, len(s.commands))
+	for i, command := range s.commands {
+		names[i] = command.name
+	}
+	fmt.Fprintf(w, "# bash completion for %[1]s, generated by \"%[1]s completion bash\".\n\n", s.program)
+	fmt.Fprintf(w, "%s() {\n", s.function())
+	// The global flags and their values are skipped while looking for the
+	// command, bash splitting --flag=value into three words.
+	fmt.Fprintf(w, `    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local i=1
+    while [ "$i" -lt "$COMP_CWORD" ]; do
+        case "${COMP_WORDS[i]}" in
+            %[1]s)
+                i=$((i + 2))
+                [ "${COMP_WORDS[i-1]}" = "=" ] && i=$((i + 1)) ;;
+            -*)
+                i=$((i + 1))
+                [ "${COMP_WORDS[i]}" = "=" ] && i=$((i + 2)) ;;
+            *) break ;;
+        esac
+    done
+    if [ "$i" -gt "$COMP_CWORD" ]; then
+        return
+    fi
+    if [ "$i" -eq "$COMP_CWORD" ]; then
+        if [[ "$cur" == -* ]]; then
+            COMPREPLY=($(compgen -W "%[2]s" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -W "%[3]s" -- "$cur"))
+        fi
+        return
+    fi
+    local flags=""
+    case "${COMP_WORDS[i]}" in
+`, s.valueFlagsPattern(), strings.Join(globalFlagNames(), " "), strings.Join(names, " "))
+	for _, command := range s.commands {
+		if len(command.flags) > 0 {
+			fmt.Fprintf(w, "        %s) flags=%q ;;\n", command.name, s.flagNames(command))
+		}
+	}
+	_, err := fmt.Fprintf(w, `    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+        return
+    fi
+    local IFS=$'\n'
+    COMPREPLY=($(%[1]s %[2]s -- "${COMP_WORDS[@]:i:COMP_CWORD-i}" "$cur" 2>/dev/null))
+}
+
+complete -o default -F %[3]s %[1]s
+`, s.program, completeCommand, s.function())
+	return err
+}
+
+func (s *completionScript) writeZsh(w io.Writer) error {
+	fmt.Fprintf(w, "#compdef %[1]s\n# zsh completion for %[1]s, generated by \"%[1]s completion zsh\".\n\n", s.program)
+	fmt.Fprintf(w, "%s() {\n    local -a commands flags values\n    commands=(\n", s.function())
+	for _, command := range s.commands {
+		fmt.Fprintf(w, "        %s\n", shellQuote(command.name+":"+command.desc))
+	}
+	fmt.Fprintf(w, `    )
+    local i=2
+    while (( i < CURRENT )); do
+        case $words[i] in
+            %[1]s) (( i += 2 )) ;;
+            -*) (( i++ )) ;;
+            *) break ;;
+        esac
+    done
+    if (( i > CURRENT )); then
+        _files
+        return
+    fi
+    if (( i == CURRENT )); then
+        if [[ $words[CURRENT] == -* ]]; then
+            compadd -- %[2]s
+        else
+            _describe -t commands '%[3]s command' commands
+        fi
+        return
+    fi
+    case $words[i] in
+`, s.valueFlagsPattern(), strings.Join(globalFlagNames(), " "), s.program)
+	for _, command := range s.commands {
+		if len(command.flags) > 0 {
+			fmt.Fprintf(w, "        %s) flags=(%s) ;;\n", command.name, s.flagNames(command))
+		}
+	}
+	_, err := fmt.Fprintf(w, `    esac
+    if [[ $words[CURRENT] == -* ]]; then
+        compadd -a flags
+        return
+    fi
+    values=(${(f)"$(%[1]s %[2]s -- ${words[i,CURRENT-1]} "$words[CURRENT]" 2>/dev/null)"})
+    if (( ${#values} )); then
+        compadd -a values
+    else
+        _files
+    fi
+}
+
+if [ "$funcstack[1]" = "%[3]s" ]; then
+    %[3]s "$@"
+else
+    compdef %[3]s %[1]s
+fi
+`, s.program, completeCommand, s.function())
+	return err
+}
+
+func (s *completionScript) writeFish(w io.Writer) error {
+	fmt.Fprintf(w, "# fish completion for %[1]s, generated by \"%[1]s completion fish\".\n\n", s.program)
+	fmt.Fprintf(w, `function %[1]s
+    set -l words (commandline -opc)
+    %[2]s %[3]s -- $words[2..-1] (commandline -ct) 2>/dev/null
+end
+
+`, s.function(), s.program, completeCommand)
+	for _, command := range s.commands {
+		fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -f -a %s -d %s\n",
+			s.program, command.name, shellQuote(command.desc))
+	}
+	for _, command := range s.commands {
+		for _, flag := range command.flags {
+			option := "-l"
+			if len(flag.Name) == 1 {
+				option = "-s"
+			}
+			fmt.Fprintf(w, "complete -c %s -n '__fish_seen_subcommand_from %s' %s %s -d %s\n",
+				s.program, command.name, option, flag.Name, shellQuote(strings.Join(strings.Fields(flag.Usage), " ")))
+		}
+	}
+	_, err := fmt.Fprintf(w, "complete -c %s -n 'not __fish_use_subcommand' -a '(%s)'\n", s.program, s.function())
+	return err
+}
+
+// shellQuote quotes value with single quotes, which are understood the same
+// way by bash, zsh and fish.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
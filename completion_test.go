@@ -0,0 +1,109 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCompletionInfo(c *check.C) {
+	c.Assert((&completion{}).Info(), check.NotNil)
+}
+
+func (s *S) runCompletion(c *check.C, shell string) string {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{shell}, Stdout: &stdout, Stderr: &stderr}
+	command := completion{manager: buildManager("tsuru-admin"), program: "tsuru-admin"}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	return stdout.String()
+}
+
+func (s *S) TestCompletionBash(c *check.C) {
+	script := s.runCompletion(c, "bash")
+	c.Assert(script, check.Matches, `(?s)# bash completion for tsuru-admin.*\n_tsuru_admin\(\) \{\n.*`)
+	c.Assert(script, check.Matches, `(?s).*compgen -W "alias app-lock-list [^"]* plan-create plan-remove [^"]*".*`)
+	c.Assert(script, check.Not(check.Matches), `(?s).*compgen -W "[^"]*log-remove[^"]*".*`)
+	c.Assert(script, check.Matches, `(?s).*\n        pool-update\) flags="--default -f --force --public" ;;\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\n            [^\n]*\|--targets\|[^\n]*\|--verbosity\|-audit-log\|[^\n]*\)\n.*`)
+	c.Assert(script, check.Not(check.Matches), `(?s).*\n            [^\n]*\|--dry-run\|[^\n]*\)\n.*`)
+	c.Assert(script, check.Matches, `(?s).*compgen -W "--all-targets [^"]*--targets --timeout --verbosity -v".*`)
+	c.Assert(script, check.Matches, `(?s).*\n    case "\$\{COMP_WORDS\[i\]\}" in\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\$\(tsuru-admin __complete -- "\$\{COMP_WORDS\[@\]:i:COMP_CWORD-i\}" "\$cur" 2>/dev/null\).*`)
+	c.Assert(strings.HasSuffix(script, "\ncomplete -o default -F _tsuru_admin tsuru-admin\n"), check.Equals, true)
+}
+
+func (s *S) TestCompletionZsh(c *check.C) {
+	script := s.runCompletion(c, "zsh")
+	c.Assert(strings.HasPrefix(script, "#compdef tsuru-admin\n"), check.Equals, true)
+	c.Assert(script, check.Matches, `(?s).*\n        'plan-remove:Removes an existing plan.*'\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\n        pool-update\) flags=\(--default -f --force --public\) ;;\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\n            [^\n]*\|--record\|[^\n]*\) \(\( i \+= 2 \)\) ;;\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\n    case \$words\[i\] in\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\$\(tsuru-admin __complete -- \$\{words\[i,CURRENT-1\]\} "\$words\[CURRENT\]" 2>/dev/null\).*`)
+	c.Assert(script, check.Matches, `(?s).*\n    compdef _tsuru_admin tsuru-admin\n.*`)
+}
+
+func (s *S) TestCompletionFish(c *check.C) {
+	script := s.runCompletion(c, "fish")
+	c.Assert(script, check.Matches, `(?s).*\ncomplete -c tsuru-admin -n '__fish_use_subcommand' -f -a plan-remove -d 'Removes an existing plan.*'\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\ncomplete -c tsuru-admin -n '__fish_seen_subcommand_from pool-update' -s f -d '[^'\n]+'\n.*`)
+	c.Assert(script, check.Matches, `(?s).*\ncomplete -c tsuru-admin -n '__fish_seen_subcommand_from pool-update' -l public -d '[^'\n]+'\n.*`)
+	c.Assert(strings.HasSuffix(script, "\ncomplete -c tsuru-admin -n 'not __fish_use_subcommand' -a '(_tsuru_admin)'\n"), check.Equals, true)
+}
+
+func (s *S) TestCompletionUnsupportedShell(c *check.C) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Args: []string{"csh"}, Stdout: &stdout}
+	command := completion{manager: buildManager("tsuru-admin"), program: "tsuru-admin"}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, `unsupported shell "csh", expected bash, zsh or fish`)
+}
+
+func (s *S) TestCompletionScriptFunction(c *check.C) {
+	script := completionScript{program: "tsuru-admin.v2"}
+	c.Assert(script.function(), check.Equals, "_tsuru_admin_v2")
+}
+
+func (s *S) TestShellQuote(c *check.C) {
+	c.Assert(shellQuote("it's"), check.Equals, `'it'\''s'`)
+}
+
+func (s *S) TestComplete(c *check.C) {
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `[{"Name":"small"},{"Name":"large"}]`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/plans") && req.Method == "GET"
+		},
+	}
+	var stdout bytes.Buffer
+	context := cmd.Context{Args: []string{"plan-remove", "sm"}, Stdout: &stdout}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := complete{manager: buildManager("tsuru-admin")}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "small\n")
+}
+
+func (s *S) TestRunHiddenCommand(c *check.C) {
+	m := buildManager("tsuru-admin")
+	hidden := hiddenCommands(m, "tsuru-admin")
+	var stdout bytes.Buffer
+	context := cmd.Context{Args: []string{completeCommand, "--", "pool-update", "--f"}, Stdout: &stdout, Stderr: &stdout}
+	err := runHiddenCommand(m, hidden, &context)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "--force\n")
+	context = cmd.Context{Args: []string{"plan-remove", "small"}, Stdout: &stdout}
+	err = runHiddenCommand(m, hidden, &context)
+	c.Assert(err, check.Equals, cmd.ErrLookup)
+	_, ok := m.Commands[completeCommand]
+	c.Assert(ok, check.Equals, false)
+}
@@ -0,0 +1,291 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+	"gopkg.in/yaml.v1"
+)
+
+// adminConfig is the configuration of tsuru-admin, read from admin.yaml in
+// the tsuru directory of the user. Aliases map names to command lines, and
+// defaults map command names to the flags prepended to their arguments.
+type adminConfig struct {
+	Aliases  map[string]string `yaml:"aliases"`
+	Defaults map[string]string `yaml:"defaults"`
+}
+
+func configPath() string {
+	return cmd.JoinWithUserDir(".tsuru", "admin.yaml")
+}
+
+// loadConfig reads the configuration file, returning an empty configuration
+// when it doesn't exist.
+func loadConfig(path string) (*adminConfig, error) {
+	config := adminConfig{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %s", path, err)
+	}
+	for name, line := range config.Aliases {
+		if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid configuration file %s: %q is not a valid alias name", path, name)
+		}
+		args, err := splitCommandLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: alias %q: %s", path, name, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("invalid configuration file %s: alias %q is empty", path, name)
+		}
+		if strings.HasPrefix(args[0], "-") {
+			return nil, fmt.Errorf("invalid configuration file %s: alias %q must start with a command, not with the flag %q", path, name, args[0])
+		}
+	}
+	for name, line := range config.Defaults {
+		if _, err := splitCommandLine(line); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: defaults of %q: %s", path, name, err)
+		}
+	}
+	return &config, nil
+}
+
+// expand replaces the alias in the arguments handed to the manager with its
+// command line and inserts the default flags of the command right after its
+// name. Default flags also given in the alias or in the command line are
+// dropped, so they're overridden even when the flag accumulates values.
+// Aliases never override registered commands and can't refer to other
+// aliases.
+func (c *adminConfig) expand(args []string, commands map[string]cmd.Command) []string {
+	i := commandIndex(args)
+	if i < 0 {
+		return args
+	}
+	expanded := []string{args[i]}
+	if _, registered := commands[args[i]]; !registered {
+		if line, ok := c.Aliases[args[i]]; ok {
+			// The aliases are validated when the configuration is loaded.
+			expanded, _ = splitCommandLine(line)
+		}
+	}
+	defaults, _ := splitCommandLine(c.Defaults[expanded[0]])
+	given := append(append([]string{}, expanded[1:]...), args[i+1:]...)
+	defaults = withoutGivenFlags(commands[expanded[0]], defaults, given)
+	result := append([]string{}, args[:i]...)
+	result = append(result, expanded[0])
+	result = append(result, defaults...)
+	result = append(result, expanded[1:]...)
+	return append(result, args[i+1:]...)
+}
+
+// withoutGivenFlags removes from the default flags of the command the ones
+// also set by the given arguments.
+func withoutGivenFlags(command cmd.Command, defaults, given []string) []string {
+	if command == nil || len(defaults) == 0 {
+		return defaults
+	}
+	flagged, ok := freshCommand(command).(cmd.FlaggedCommand)
+	if !ok {
+		return defaults
+	}
+	fs := flagged.Flags()
+	set := make(map[gnuflag.Value]bool)
+	for _, group := range groupFlagArgs(fs, given) {
+		for _, value := range group.values {
+			set[value] = true
+		}
+	}
+	var result []string
+	for _, group := range groupFlagArgs(fs, defaults) {
+		overridden := false
+		for _, value := range group.values {
+			overridden = overridden || set[value]
+		}
+		if !overridden {
+			result = append(result, group.args...)
+		}
+	}
+	return result
+}
+
+// flagArgs is a group of arguments, like a flag and its value, along with the
+// values of the flags they set.
+type flagArgs struct {
+	args   []string
+	values []gnuflag.Value
+}
+
+// groupFlagArgs groups the arguments by the flags they set, following the
+// parsing rules of gnuflag. Positional arguments and unknown flags are kept
+// in groups setting no flag.
+func groupFlagArgs(fs *gnuflag.FlagSet, args []string) []flagArgs {
+	var groups []flagArgs
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		group := flagArgs{args: []string{arg}}
+		switch {
+		case arg == "--":
+			return append(groups, flagArgs{args: args[i:]})
+		case len(arg) < 2 || arg[0] != '-':
+		case arg[1] == '-':
+			name := arg[2:]
+			inline := strings.Contains(name, "=")
+			if inline {
+				name = name[:strings.Index(name, "=")]
+			}
+			if flag := fs.Lookup(name); flag != nil {
+				group.values = append(group.values, flag.Value)
+				if !inline && !isBoolFlag(flag) && i+1 < len(args) {
+					i++
+					group.args = append(group.args, args[i])
+				}
+			}
+		default:
+			// Single-rune flags may be combined, like -fy, and the last one
+			// may take the rest of the argument or the next one as value.
+			for rest := arg[1:]; rest != ""; {
+				r, n := utf8.DecodeRuneInString(rest)
+				rest = rest[n:]
+				flag := fs.Lookup(string(r))
+				if flag == nil {
+					break
+				}
+				group.values = append(group.values, flag.Value)
+				if isBoolFlag(flag) && !strings.HasPrefix(rest, "=") {
+					continue
+				}
+				if rest == "" && i+1 < len(args) {
+					i++
+					group.args = append(group.args, args[i])
+				}
+				break
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+type aliasDefinition struct {
+	Name    string
+	Command string
+}
+
+type commandDefaults struct {
+	Command string
+	Flags   string
+}
+
+type aliasListing struct {
+	Aliases  []aliasDefinition
+	Defaults []commandDefaults
+}
+
+func (c *adminConfig) listing() aliasListing {
+	listing := aliasListing{Aliases: []aliasDefinition{}, Defaults: []commandDefaults{}}
+	for _, name := range sortedKeys(c.Aliases) {
+		listing.Aliases = append(listing.Aliases, aliasDefinition{Name: name, Command: c.Aliases[name]})
+	}
+	for _, name := range sortedKeys(c.Defaults) {
+		listing.Defaults = append(listing.Defaults, commandDefaults{Command: name, Flags: c.Defaults[name]})
+	}
+	return listing
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type aliasCommand struct {
+	outputCommand
+	manager *cmd.Manager
+}
+
+func (c *aliasCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "alias",
+		Usage:   "alias list [-o <format>]",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Desc: `Lists the aliases and the default flags of commands in ~/.tsuru/admin.yaml.
+
+They're defined in the configuration file as:
+
+    aliases:
+      nodes-prod: docker-node-list -f pool=prod
+    defaults:
+      app-lock-list: -o json
+
+Aliases are replaced by their command lines, followed by the remaining
+arguments. The default flags of a command are inserted right after its name,
+unless the same flag is given in the alias or in the command line, so flags
+accumulating values, like the filters of docker-node-list, aren't merged with
+the defaults. Aliases must start with a command, never override commands and
+can't refer to other aliases.`,
+	}
+}
+
+func (c *aliasCommand) freshCopy() cmd.Command {
+	return &aliasCommand{manager: c.manager}
+}
+
+func (c *aliasCommand) Run(context *cmd.Context, client *cmd.Client) error {
+	if context.Args[0] != "list" {
+		return fmt.Errorf("unknown alias subcommand %q, expected list", context.Args[0])
+	}
+	config, err := loadConfig(configPath())
+	if err != nil {
+		return err
+	}
+	listing := config.listing()
+	return c.render(context.Stdout, listing, func() error {
+		if len(listing.Aliases) == 0 && len(listing.Defaults) == 0 {
+			fmt.Fprintf(context.Stdout, "No aliases or default flags defined in %s.\n", configPath())
+			return nil
+		}
+		var tables []string
+		if len(listing.Aliases) > 0 {
+			table := cmd.NewTable()
+			table.Headers = cmd.Row([]string{"Alias", "Command"})
+			for _, alias := range listing.Aliases {
+				command := alias.Command
+				if _, registered := c.manager.Commands[alias.Name]; registered {
+					command += " (overridden by command)"
+				}
+				table.AddRow(cmd.Row([]string{alias.Name, command}))
+			}
+			tables = append(tables, table.String())
+		}
+		if len(listing.Defaults) > 0 {
+			table := cmd.NewTable()
+			table.Headers = cmd.Row([]string{"Command", "Default flags"})
+			for _, defaults := range listing.Defaults {
+				table.AddRow(cmd.Row([]string{defaults.Command, defaults.Flags}))
+			}
+			tables = append(tables, table.String())
+		}
+		fmt.Fprint(context.Stdout, strings.Join(tables, "\n"))
+		return nil
+	})
+}
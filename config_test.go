@@ -0,0 +1,201 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuru/tsuru/cmd"
+	"gopkg.in/check.v1"
+)
+
+const testConfig = `aliases:
+  nodes-prod: docker-node-list -f pool=prod
+  locks: app-lock-list
+  plan-create: plan-remove
+defaults:
+  docker-node-list: -q
+  app-lock-list: -o "json"
+`
+
+// setUpConfig points HOME to a temporary directory holding the given
+// configuration file, returning a function restoring it.
+func setUpConfig(c *check.C, content string) (restore func()) {
+	home := c.MkDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	err := os.MkdirAll(filepath.Join(home, ".tsuru"), 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(home, ".tsuru", "admin.yaml"), []byte(content), 0644)
+	c.Assert(err, check.IsNil)
+	return func() { os.Setenv("HOME", oldHome) }
+}
+
+func (s *S) TestLoadConfig(c *check.C) {
+	restore := setUpConfig(c, testConfig)
+	defer restore()
+	config, err := loadConfig(configPath())
+	c.Assert(err, check.IsNil)
+	c.Assert(config, check.DeepEquals, &adminConfig{
+		Aliases: map[string]string{
+			"nodes-prod":  "docker-node-list -f pool=prod",
+			"locks":       "app-lock-list",
+			"plan-create": "plan-remove",
+		},
+		Defaults: map[string]string{
+			"docker-node-list": "-q",
+			"app-lock-list":    `-o "json"`,
+		},
+	})
+}
+
+func (s *S) TestLoadConfigMissingFile(c *check.C) {
+	config, err := loadConfig(filepath.Join(c.MkDir(), "admin.yaml"))
+	c.Assert(err, check.IsNil)
+	c.Assert(config, check.DeepEquals, &adminConfig{})
+}
+
+func (s *S) TestLoadConfigInvalid(c *check.C) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{"aliases: [a, b", "invalid configuration file .*admin.yaml: .*"},
+		{"aliases:\n  -x: plan-create", `invalid configuration file .*: "-x" is not a valid alias name`},
+		{"aliases:\n  x: \"\"", `invalid configuration file .*: alias "x" is empty`},
+		{"aliases:\n  x: plan-create 'small", `invalid configuration file .*: alias "x": unterminated quote.*`},
+		{"aliases:\n  x: --targets prod plan-list", `invalid configuration file .*: alias "x" must start with a command, not with the flag "--targets"`},
+		{"defaults:\n  plan-create: -c 'small", `invalid configuration file .*: defaults of "plan-create": unterminated quote.*`},
+	}
+	for _, test := range tests {
+		path := filepath.Join(c.MkDir(), "admin.yaml")
+		err := ioutil.WriteFile(path, []byte(test.content), 0644)
+		c.Assert(err, check.IsNil)
+		_, err = loadConfig(path)
+		c.Check(err, check.ErrorMatches, test.err, check.Commentf(test.content))
+	}
+}
+
+func (s *S) TestAdminConfigExpand(c *check.C) {
+	restore := setUpConfig(c, testConfig)
+	defer restore()
+	config, err := loadConfig(configPath())
+	c.Assert(err, check.IsNil)
+	commands := buildManager("tsuru-admin").Commands
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"nodes-prod"}, []string{"docker-node-list", "-q", "-f", "pool=prod"}},
+		{[]string{"nodes-prod", "-f", "iaas=ec2"}, []string{"docker-node-list", "-q", "-f", "pool=prod", "-f", "iaas=ec2"}},
+		{[]string{"-v", "2", "locks", "-o", "table"}, []string{"-v", "2", "app-lock-list", "-o", "table"}},
+		{[]string{"app-lock-list"}, []string{"app-lock-list", "-o", "json"}},
+		{[]string{"plan-create", "small"}, []string{"plan-create", "small"}},
+		{[]string{"machine-list"}, []string{"machine-list"}},
+		{[]string{"--help"}, []string{"--help"}},
+		{nil, nil},
+	}
+	for _, test := range tests {
+		c.Check(config.expand(test.args, commands), check.DeepEquals, test.expected, check.Commentf("%v", test.args))
+	}
+}
+
+func (s *S) TestAdminConfigExpandDefaultsApplyBeforeFlags(c *check.C) {
+	config := adminConfig{Defaults: map[string]string{"app-lock-list": "-o json"}}
+	manager := buildManager("tsuru-admin")
+	args := config.expand([]string{"app-lock-list", "-o", "yaml"}, manager.Commands)
+	command := freshCommand(manager.Commands["app-lock-list"]).(*appLockList)
+	err := command.Flags().Parse(true, args[1:])
+	c.Assert(err, check.IsNil)
+	c.Assert(command.format, check.Equals, "yaml")
+}
+
+func (s *S) TestAdminConfigExpandDropsGivenFlags(c *check.C) {
+	config := adminConfig{Defaults: map[string]string{
+		"docker-node-list": "-f pool=prod -q",
+		"app-unlock":       "--older-than 1h -y",
+	}}
+	commands := buildManager("tsuru-admin").Commands
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"docker-node-list"}, []string{"docker-node-list", "-f", "pool=prod", "-q"}},
+		{[]string{"docker-node-list", "--filter", "iaas=ec2"}, []string{"docker-node-list", "-q", "--filter", "iaas=ec2"}},
+		{[]string{"docker-node-list", "--filter=iaas=ec2"}, []string{"docker-node-list", "-q", "--filter=iaas=ec2"}},
+		{[]string{"docker-node-list", "-qfiaas=ec2"}, []string{"docker-node-list", "-qfiaas=ec2"}},
+		{[]string{"app-unlock", "--older-than=2h"}, []string{"app-unlock", "-y", "--older-than=2h"}},
+		{[]string{"app-unlock", "--", "-y"}, []string{"app-unlock", "--older-than", "1h", "-y", "--", "-y"}},
+	}
+	for _, test := range tests {
+		c.Check(config.expand(test.args, commands), check.DeepEquals, test.expected, check.Commentf("%v", test.args))
+	}
+}
+
+func (s *S) TestAliasList(c *check.C) {
+	restore := setUpConfig(c, testConfig)
+	defer restore()
+	var stdout bytes.Buffer
+	command := aliasCommand{manager: buildManager("tsuru-admin")}
+	context := cmd.Context{Args: []string{"list"}, Stdout: &stdout}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	expected := `+-------------+-------------------------------------+
+| Alias       | Command                             |
++-------------+-------------------------------------+
+| locks       | app-lock-list                       |
+| nodes-prod  | docker-node-list -f pool=prod       |
+| plan-create | plan-remove (overridden by command) |
++-------------+-------------------------------------+
+
++------------------+---------------+
+| Command          | Default flags |
++------------------+---------------+
+| app-lock-list    | -o "json"     |
+| docker-node-list | -q            |
++------------------+---------------+
+`
+	c.Assert(stdout.String(), check.Equals, expected)
+}
+
+func (s *S) TestAliasListJSON(c *check.C) {
+	restore := setUpConfig(c, "aliases:\n  locks: app-lock-list\n")
+	defer restore()
+	var stdout bytes.Buffer
+	command := aliasCommand{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{"-o", "json"})
+	context := cmd.Context{Args: []string{"list"}, Stdout: &stdout}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	var listing aliasListing
+	err = json.Unmarshal(stdout.Bytes(), &listing)
+	c.Assert(err, check.IsNil)
+	c.Assert(listing, check.DeepEquals, aliasListing{
+		Aliases:  []aliasDefinition{{Name: "locks", Command: "app-lock-list"}},
+		Defaults: []commandDefaults{},
+	})
+}
+
+func (s *S) TestAliasListEmpty(c *check.C) {
+	restore := setUpConfig(c, "")
+	defer restore()
+	var stdout bytes.Buffer
+	command := aliasCommand{manager: buildManager("tsuru-admin")}
+	context := cmd.Context{Args: []string{"list"}, Stdout: &stdout}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Matches, `No aliases or default flags defined in .*admin.yaml.\n`)
+}
+
+func (s *S) TestAliasUnknownSubcommand(c *check.C) {
+	command := aliasCommand{}
+	context := cmd.Context{Args: []string{"add"}, Stdout: &bytes.Buffer{}}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, `unknown alias subcommand "add", expected list`)
+}
@@ -0,0 +1,469 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+const docsFormats = "man,markdown,json"
+
+// docsGen generates the reference documentation from the registered
+// commands, so the docs are always in sync with the binary. It's a hidden
+// command, meant to be used by the maintainers.
+type docsGen struct {
+	manager *cmd.Manager
+	program string
+	dir     string
+	formats string
+	fs      *gnuflag.FlagSet
+}
+
+func (c *docsGen) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docs-gen",
+		Usage: "docs-gen [-d/--dir <dir>] [-f/--format <formats>]",
+		Desc: `Generates the reference documentation of all commands, including the
+deprecated and removed ones.
+
+The formats are "man", which writes one man page per command in the "man"
+directory, "markdown", which writes the "reference.md" file, and "json",
+which writes the "cmds.json" file used by the Sphinx docs.`,
+	}
+}
+
+func (c *docsGen) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("docs-gen", gnuflag.ExitOnError)
+		dir := "Directory where the docs are written."
+		c.fs.StringVar(&c.dir, "dir", "docs", dir)
+		c.fs.StringVar(&c.dir, "d", "docs", dir)
+		formats := "Comma separated list of formats to generate: man, markdown or json."
+		c.fs.StringVar(&c.formats, "format", docsFormats, formats)
+		c.fs.StringVar(&c.formats, "f", docsFormats, formats)
+	}
+	return c.fs
+}
+
+func (c *docsGen) Run(context *cmd.Context, client *cmd.Client) error {
+	docs, err := collectDocs(c.manager, c.program)
+	if err != nil {
+		return err
+	}
+	for _, format := range strings.Split(c.formats, ",") {
+		var files map[string]func(io.Writer) error
+		switch format = strings.TrimSpace(format); format {
+		case "man":
+			files = map[string]func(io.Writer) error{
+				filepath.Join("man", c.program+".1"): docs.writeManIndex,
+			}
+			for i := range docs.commands {
+				command := docs.commands[i]
+				name := filepath.Join("man", c.program+"-"+command.name+".1")
+				files[name] = func(w io.Writer) error {
+					return docs.writeManPage(w, command)
+				}
+			}
+		case "markdown":
+			files = map[string]func(io.Writer) error{"reference.md": docs.writeMarkdown}
+		case "json":
+			files = map[string]func(io.Writer) error{"cmds.json": docs.writeCmdsJSON}
+		default:
+			return fmt.Errorf("invalid format %q, expected man, markdown or json", format)
+		}
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := filepath.Join(c.dir, name)
+			if err := writeDocFile(path, files[name]); err != nil {
+				return err
+			}
+			fmt.Fprintf(context.Stdout, "Wrote %s.\n", path)
+		}
+	}
+	return nil
+}
+
+func writeDocFile(path string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+type flagDoc struct {
+	names    []string
+	defValue string
+	usage    string
+}
+
+type commandDoc struct {
+	name  string
+	usage string
+	desc  string
+	// help is the text displayed by "tsuru-admin help <command>", without
+	// the version and usage lines.
+	help    string
+	flags   []flagDoc
+	minArgs int
+	maxArgs int
+	// deprecatedBy is the name of the command replacing a deprecated one.
+	deprecatedBy string
+	removed      bool
+}
+
+// summary returns the first line of the description.
+func (d *commandDoc) summary() string {
+	if d.deprecatedBy != "" {
+		return fmt.Sprintf("Deprecated, use %s instead.", d.deprecatedBy)
+	}
+	return strings.SplitN(strings.TrimSpace(d.desc), "\n", 2)[0]
+}
+
+type topicDoc struct {
+	name    string
+	content string
+}
+
+type docs struct {
+	program  string
+	commands []commandDoc
+	topics   []topicDoc
+}
+
+// collectDocs gathers the documentation of the commands and topics from
+// the manager, sorted by name. Plugins are left out, as they're installed
+// separately.
+func collectDocs(manager *cmd.Manager, program string) (*docs, error) {
+	result := docs{program: program}
+	var names []string
+	for name, command := range manager.Commands {
+		if _, isPlugin := command.(*plugin); !isPlugin {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		command := manager.Commands[name]
+		doc := commandDoc{name: name, usage: program + " " + name}
+		switch c := command.(type) {
+		case *cmd.RemovedCommand:
+			doc.removed = true
+			doc.desc = c.Info().Desc
+			doc.help = doc.desc + "\n\n"
+		case *cmd.DeprecatedCommand:
+			doc.deprecatedBy = c.Info().Name
+			doc.desc = fmt.Sprintf("This command was deprecated. You should use `%s %s` instead.", program, doc.deprecatedBy)
+			doc.help = doc.desc + "\n\n"
+		default:
+			info := command.Info()
+			doc.usage = program + " " + info.Usage
+			doc.desc = info.Desc
+			doc.minArgs, doc.maxArgs = info.MinArgs, info.MaxArgs
+			doc.flags = flagDocs(commandFlagSet(manager, name))
+			help, err := helpOutput(manager, name)
+			if err != nil {
+				return nil, err
+			}
+			doc.help = help
+		}
+		result.commands = append(result.commands, doc)
+	}
+	topics, err := helpTopics(manager)
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range topics {
+		content, err := helpOutput(manager, topic)
+		if err != nil {
+			return nil, err
+		}
+		result.topics = append(result.topics, topicDoc{name: topic, content: content})
+	}
+	return &result, nil
+}
+
+// flagDocs groups the flags sharing the same value, like "-a" and "--app".
+func flagDocs(fs *gnuflag.FlagSet) []flagDoc {
+	if fs == nil {
+		return nil
+	}
+	var values []gnuflag.Value
+	groups := make(map[gnuflag.Value]*flagDoc)
+	fs.VisitAll(func(flag *gnuflag.Flag) {
+		doc, ok := groups[flag.Value]
+		if !ok {
+			doc = &flagDoc{defValue: shortenHome(flag.DefValue), usage: flag.Usage}
+			groups[flag.Value] = doc
+			values = append(values, flag.Value)
+		}
+		doc.names = append(doc.names, flagName(flag.Name))
+	})
+	result := make([]flagDoc, len(values))
+	for i, value := range values {
+		result[i] = *groups[value]
+		sort.Sort(flagNamesByLength(result[i].names))
+	}
+	sort.Sort(flagDocsByName(result))
+	return result
+}
+
+type flagNamesByLength []string
+
+func (l flagNamesByLength) Len() int      { return len(l) }
+func (l flagNamesByLength) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l flagNamesByLength) Less(i, j int) bool {
+	if len(l[i]) != len(l[j]) {
+		return len(l[i]) < len(l[j])
+	}
+	return l[i] < l[j]
+}
+
+type flagDocsByName []flagDoc
+
+func (l flagDocsByName) Len() int      { return len(l) }
+func (l flagDocsByName) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l flagDocsByName) Less(i, j int) bool {
+	return strings.TrimLeft(l[i].names[0], "-") < strings.TrimLeft(l[j].names[0], "-")
+}
+
+// helpOutput runs the help command for the given command or topic, skipping
+// the version line and, for commands, the usage line.
+func helpOutput(manager *cmd.Manager, name string) (string, error) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Args: []string{name}, Stdout: &stdout, Stderr: ioutil.Discard}
+	if err := manager.Commands["help"].Run(&context, nil); err != nil {
+		return "", err
+	}
+	output := stdout.String()
+	output = output[strings.Index(output, "\n\n")+2:]
+	if strings.HasPrefix(output, "Usage: ") {
+		output = strings.TrimPrefix(output[strings.Index(output, "\n")+1:], "\n")
+	}
+	if strings.TrimSpace(output) == "" {
+		return "", nil
+	}
+	return shortenHome(output), nil
+}
+
+// shortenHome replaces the home directory of the user generating the docs
+// with "~", so paths like the default of --history-file don't depend on who
+// generated them.
+func shortenHome(text string) string {
+	home := strings.TrimSuffix(cmd.JoinWithUserDir(), string(filepath.Separator))
+	if home == "" {
+		return text
+	}
+	return strings.Replace(text, home+string(filepath.Separator), "~"+string(filepath.Separator), -1)
+}
+
+// helpTopics returns the topics listed by the help command, as the manager
+// doesn't expose them.
+func helpTopics(manager *cmd.Manager) ([]string, error) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: ioutil.Discard}
+	if err := manager.Commands["help"].Run(&context, nil); err != nil {
+		return nil, err
+	}
+	output := stdout.String()
+	i := strings.Index(output, "\nAvailable topics:\n")
+	if i < 0 {
+		return nil, nil
+	}
+	var topics []string
+	for _, line := range strings.Split(output[i+len("\nAvailable topics:\n"):], "\n") {
+		if !strings.HasPrefix(line, "  ") {
+			break
+		}
+		topics = append(topics, strings.TrimSpace(line))
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// writeCmdsJSON writes the commands and topics in the format of the
+// docs/cmds.json file.
+func (d *docs) writeCmdsJSON(w io.Writer) error {
+	entries := make(map[string]map[string]string)
+	for _, command := range d.commands {
+		entries[command.name] = map[string]string{"usage": command.usage, "desc": command.help}
+	}
+	for _, topic := range d.topics {
+		entries[topic.name] = map[string]string{"topic": topic.content}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func (d *docs) writeGlobalFlags(w io.Writer, write func(names, usage string)) {
+	var names []string
+	for name := range globalFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag := globalFlags[name]
+		option := flagName(name)
+		if !flag.isBool {
+			option += " <value>"
+		}
+		usage := flag.usage
+		if flag.env != "" {
+			usage += fmt.Sprintf(" May also be set with the %s environment variable.", flag.env)
+		}
+		write(option, usage)
+	}
+}
+
+func (d *docs) writeMarkdown(w io.Writer) error {
+	fmt.Fprintf(w, "# %s reference\n\n", d.program)
+	fmt.Fprintf(w, "Generated by `%s docs-gen` from %s version %s.\n\n", d.program, d.program, version)
+	fmt.Fprintf(w, "## Global flags\n\nThese flags are given before the command name.\n\n")
+	d.writeGlobalFlags(w, func(names, usage string) {
+		fmt.Fprintf(w, "- `%s`: %s\n", names, usage)
+	})
+	fmt.Fprintf(w, "\n## Commands\n")
+	for _, command := range d.commands {
+		fmt.Fprintf(w, "\n### %s\n\n", command.name)
+		if command.deprecatedBy != "" {
+			fmt.Fprintf(w, "Deprecated, use [`%s`](#%s) instead.\n", command.deprecatedBy, command.deprecatedBy)
+			continue
+		}
+		if command.removed {
+			fmt.Fprintf(w, "%s\n", command.desc)
+			continue
+		}
+		fmt.Fprintf(w, "```\n%s\n```\n", command.usage)
+		if desc := strings.TrimSpace(command.desc); desc != "" {
+			fmt.Fprintf(w, "\n%s\n", desc)
+		}
+		if len(command.flags) > 0 {
+			fmt.Fprintf(w, "\n| Flag | Default | Description |\n|------|---------|-------------|\n")
+			for _, flag := range command.flags {
+				usage := strings.Replace(strings.Join(strings.Fields(flag.usage), " "), "|", `\|`, -1)
+				defValue := flag.defValue
+				if defValue != "" {
+					defValue = "`" + defValue + "`"
+				}
+				fmt.Fprintf(w, "| `%s` | %s | %s |\n", strings.Join(flag.names, "`, `"), defValue, usage)
+			}
+		}
+		if command.minArgs > 0 {
+			fmt.Fprintf(w, "\nMinimum number of arguments: %d.\n", command.minArgs)
+		}
+		if command.maxArgs > 0 {
+			fmt.Fprintf(w, "\nMaximum number of arguments: %d.\n", command.maxArgs)
+		}
+	}
+	if len(d.topics) > 0 {
+		fmt.Fprintf(w, "\n## Topics\n")
+		for _, topic := range d.topics {
+			fmt.Fprintf(w, "\n### %s\n\n%s\n", topic.name, strings.TrimSpace(topic.content))
+		}
+	}
+	return nil
+}
+
+// manEscape escapes text for troff, so backslashes, hyphens and lines
+// starting with control characters are displayed as they are.
+func manEscape(text string) string {
+	text = strings.Replace(text, `\`, `\e`, -1)
+	text = strings.Replace(text, "-", `\-`, -1)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// manParagraphs converts blank lines into paragraph breaks.
+func manParagraphs(text string) string {
+	var lines []string
+	for _, line := range strings.Split(manEscape(strings.TrimSpace(text)), "\n") {
+		if strings.TrimSpace(line) == "" {
+			line = ".PP"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (d *docs) writeManHeader(w io.Writer, name, summary string) {
+	fmt.Fprintf(w, ".TH %s 1 \"\" \"%s %s\" \"%s manual\"\n", manEscape(strings.ToUpper(name)), d.program, version, d.program)
+	fmt.Fprintf(w, ".SH NAME\n%s \\- %s\n", manEscape(name), manEscape(summary))
+}
+
+func (d *docs) writeManIndex(w io.Writer) error {
+	d.writeManHeader(w, d.program, "command line tool for tsuru administrators")
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B %s\n[\\fIglobal flags\\fR] \\fIcommand\\fR [\\fIargs\\fR]\n", manEscape(d.program))
+	fmt.Fprintf(w, ".SH GLOBAL FLAGS\n")
+	d.writeGlobalFlags(w, func(names, usage string) {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", manEscape(names), manEscape(usage))
+	})
+	fmt.Fprintf(w, ".SH COMMANDS\n")
+	for _, command := range d.commands {
+		fmt.Fprintf(w, ".TP\n.BR %s (1)\n%s\n", manEscape(d.program+"-"+command.name), manEscape(command.summary()))
+	}
+	for _, topic := range d.topics {
+		fmt.Fprintf(w, ".SH %s\n%s\n", manEscape(strings.ToUpper(topic.name)), manParagraphs(topic.content))
+	}
+	return nil
+}
+
+func (d *docs) writeManPage(w io.Writer, command commandDoc) error {
+	d.writeManHeader(w, d.program+"-"+command.name, command.summary())
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B %s\n", manEscape(command.usage))
+	fmt.Fprintf(w, ".SH DESCRIPTION\n%s\n", manParagraphs(command.desc))
+	if command.minArgs > 0 {
+		fmt.Fprintf(w, ".PP\nMinimum number of arguments: %d.\n", command.minArgs)
+	}
+	if command.maxArgs > 0 {
+		fmt.Fprintf(w, ".PP\nMaximum number of arguments: %d.\n", command.maxArgs)
+	}
+	if len(command.flags) > 0 {
+		fmt.Fprintf(w, ".SH FLAGS\n")
+		for _, flag := range command.flags {
+			names := make([]string, len(flag.names))
+			for i, name := range flag.names {
+				names[i] = `\fB` + manEscape(name) + `\fR`
+			}
+			fmt.Fprintf(w, ".TP\n%s", strings.Join(names, ", "))
+			if flag.defValue != "" {
+				fmt.Fprintf(w, " (default: %s)", manEscape(flag.defValue))
+			}
+			fmt.Fprintf(w, "\n%s\n", manEscape(strings.Join(strings.Fields(flag.usage), " ")))
+		}
+	}
+	fmt.Fprintf(w, ".SH SEE ALSO\n.BR %s (1)", manEscape(d.program))
+	if command.deprecatedBy != "" {
+		fmt.Fprintf(w, ",\n.BR %s (1)", manEscape(d.program+"-"+command.deprecatedBy))
+	}
+	fmt.Fprintf(w, "\n")
+	return nil
+}
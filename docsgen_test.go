@@ -0,0 +1,175 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"gopkg.in/check.v1"
+)
+
+func docsByName(d *docs) map[string]commandDoc {
+	result := make(map[string]commandDoc)
+	for _, command := range d.commands {
+		result[command.name] = command
+	}
+	return result
+}
+
+func (s *S) TestCollectDocs(c *check.C) {
+	d, err := collectDocs(buildManager("tsuru-admin"), "tsuru-admin")
+	c.Assert(err, check.IsNil)
+	commands := docsByName(d)
+	planCreate := commands["plan-create"]
+	c.Assert(planCreate.usage, check.Equals, "tsuru-admin plan-create <name> -c cpushare [-m memory] [-s swap] [-r router] [--default]")
+	c.Assert(planCreate.minArgs, check.Equals, 1)
+	c.Assert(planCreate.flags, check.HasLen, 5)
+	c.Assert(planCreate.flags[0], check.DeepEquals, flagDoc{
+		names:    []string{"-c", "--cpushare"},
+		defValue: "0",
+		usage:    planCreate.flags[0].usage,
+	})
+	removed := commands["log-remove"]
+	c.Assert(removed.removed, check.Equals, true)
+	c.Assert(removed.help, check.Equals, "This command was removed. This action is no longer supported.\n\n")
+	deprecated := commands["view-user-quota"]
+	c.Assert(deprecated.deprecatedBy, check.Equals, "user-quota-view")
+	c.Assert(deprecated.usage, check.Equals, "tsuru-admin view-user-quota")
+	c.Assert(deprecated.summary(), check.Equals, "Deprecated, use user-quota-view instead.")
+	_, ok := commands["docker-node-list"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(d.topics, check.HasLen, 1)
+	c.Assert(d.topics[0].name, check.Equals, "target")
+	c.Assert(d.topics[0].content, check.Matches, "(?s)In tsuru, a target is the address of the remote tsuru server.*")
+}
+
+func (s *S) TestCollectDocsShortensHome(c *check.C) {
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	os.Setenv("HOME", "/home/someone")
+	d, err := collectDocs(buildManager("tsuru-admin"), "tsuru-admin")
+	c.Assert(err, check.IsNil)
+	shell := docsByName(d)["shell"]
+	c.Assert(shell.flags, check.HasLen, 1)
+	c.Assert(shell.flags[0].defValue, check.Equals, "~/.tsuru/admin_history")
+	c.Assert(shell.help, check.Matches, `(?s).*--history-file \(= "~/.tsuru/admin_history"\).*`)
+	c.Assert(strings.Contains(shell.help, "/home/someone"), check.Equals, false)
+}
+
+func (s *S) TestDocsCmdsJSON(c *check.C) {
+	d, err := collectDocs(buildManager("tsuru-admin"), "tsuru-admin")
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = d.writeCmdsJSON(&buf)
+	c.Assert(err, check.IsNil)
+	var entries map[string]map[string]string
+	err = json.Unmarshal(buf.Bytes(), &entries)
+	c.Assert(err, check.IsNil)
+	c.Assert(entries["machine-template-remove"], check.DeepEquals, map[string]string{
+		"usage": "tsuru-admin machine-template-remove <name>",
+		"desc":  "Removes an existing machine template.\n\nMinimum # of arguments: 1\n",
+	})
+	c.Assert(entries["version"], check.DeepEquals, map[string]string{
+		"usage": "tsuru-admin version",
+		"desc":  "display the current version\n\n",
+	})
+	c.Assert(entries["help"]["desc"], check.Equals, "")
+	c.Assert(entries["change-user-quota"]["desc"], check.Equals, "This command was deprecated. You should use `tsuru-admin user-quota-change` instead.\n\n")
+	c.Assert(entries["target"]["topic"], check.Matches, "(?s)In tsuru, a target.*")
+}
+
+func (s *S) TestDocsMarkdown(c *check.C) {
+	d, err := collectDocs(buildManager("tsuru-admin"), "tsuru-admin")
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = d.writeMarkdown(&buf)
+	c.Assert(err, check.IsNil)
+	markdown := buf.String()
+	c.Assert(markdown, check.Matches, "(?s)# tsuru-admin reference\n.*\n- `--dry-run`: Print the requests.*")
+	c.Assert(markdown, check.Matches, "(?s).*\n### plan-remove\n\n```\ntsuru-admin plan-remove <name>\n```\n\nRemoves an existing plan.*")
+	c.Assert(markdown, check.Matches, "(?s).*\n\\| `-r`, `--router` \\|  \\| The name of the router used by this plan. \\|\n.*")
+	c.Assert(markdown, check.Matches, "(?s).*\n### change-app-quota\n\nDeprecated, use \\[`app-quota-change`\\]\\(#app-quota-change\\) instead.\n.*")
+	c.Assert(markdown, check.Matches, "(?s).*\n### log-remove\n\nThis command was removed. This action is no longer supported.\n.*")
+	c.Assert(markdown, check.Matches, "(?s).*\n## Topics\n\n### target\n\nIn tsuru.*")
+}
+
+func (s *S) TestDocsManPage(c *check.C) {
+	d, err := collectDocs(buildManager("tsuru-admin"), "tsuru-admin")
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = d.writeManPage(&buf, docsByName(d)["view-app-quota"])
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `.TH TSURU\-ADMIN\-VIEW\-APP\-QUOTA 1 "" "tsuru-admin `+version+`" "tsuru-admin manual"
+.SH NAME
+tsuru\-admin\-view\-app\-quota \- Deprecated, use app\-quota\-view instead.
+.SH SYNOPSIS
+.B tsuru\-admin view\-app\-quota
+.SH DESCRIPTION
+This command was deprecated. You should use `+"`tsuru\\-admin app\\-quota\\-view`"+` instead.
+.SH SEE ALSO
+.BR tsuru\-admin (1),
+.BR tsuru\-admin\-app\-quota\-view (1)
+`)
+	buf.Reset()
+	err = d.writeManIndex(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*\n\.SH COMMANDS\n\.TP\n\.BR tsuru\\-admin\\-alias \(1\)\n[^\n]*\n\.TP\n\.BR tsuru\\-admin\\-app\\-lock\\-list \(1\)\n.*`)
+	c.Assert(buf.String(), check.Matches, `(?s).*\n\.TP\n\.B \\-\\-dry\\-run\n.*`)
+}
+
+func (s *S) TestManEscape(c *check.C) {
+	c.Assert(manEscape("a-b\\c\n.line\n'quote"), check.Equals, "a\\-b\\ec\n\\&.line\n\\&'quote")
+}
+
+func (s *S) TestDocsGenRun(c *check.C) {
+	dir := c.MkDir()
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout}
+	command := docsGen{manager: buildManager("tsuru-admin"), program: "tsuru-admin"}
+	err := command.Flags().Parse(true, []string{"-d", dir, "-f", "markdown,json"})
+	c.Assert(err, check.IsNil)
+	err = command.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Wrote "+filepath.Join(dir, "reference.md")+".\nWrote "+filepath.Join(dir, "cmds.json")+".\n")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cmds.json"))
+	c.Assert(err, check.IsNil)
+	var entries map[string]interface{}
+	c.Assert(json.Unmarshal(data, &entries), check.IsNil)
+	command = docsGen{manager: buildManager("tsuru-admin"), program: "tsuru-admin"}
+	err = command.Flags().Parse(true, []string{"-d", dir, "-f", "man"})
+	c.Assert(err, check.IsNil)
+	err = command.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	files, err := filepath.Glob(filepath.Join(dir, "man", "*.1"))
+	c.Assert(err, check.IsNil)
+	c.Assert(len(files), check.Equals, len(command.manager.Commands)+1)
+}
+
+func (s *S) TestDocsGenInvalidFormat(c *check.C) {
+	command := docsGen{manager: buildManager("tsuru-admin"), program: "tsuru-admin", dir: c.MkDir(), formats: "html"}
+	err := command.Run(&cmd.Context{Stdout: ioutil.Discard}, nil)
+	c.Assert(err, check.ErrorMatches, `invalid format "html", expected man, markdown or json`)
+}
+
+func (s *S) TestDocsGenIsHidden(c *check.C) {
+	m := buildManager("tsuru-admin")
+	_, ok := m.Commands["docs-gen"]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(hiddenCommands(m, "tsuru-admin")["docs-gen"], check.FitsTypeOf, &docsGen{})
+}
+
+func (s *S) TestDocsMarkdownGlobalFlagsEnv(c *check.C) {
+	d := docs{program: "tsuru-admin"}
+	var buf bytes.Buffer
+	err := d.writeMarkdown(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, "(?s).*\n- `--timeout <value>`: Maximum duration of each request.* May also be set with the TSURU_ADMIN_TIMEOUT environment variable.\n.*")
+}
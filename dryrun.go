@@ -0,0 +1,99 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// dryRunTransport prints the requests that would change data in the tsuru
+// server instead of sending them. GET requests are still sent, so commands
+// are able to validate their input against the server.
+type dryRunTransport struct {
+	transport http.RoundTripper
+	out       io.Writer
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return t.transport.RoundTrip(req)
+	}
+	fmt.Fprintf(t.out, "Dry run: %s %s\n", req.Method, req.URL)
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		writeRequestBody(t.out, req.Header.Get("Content-Type"), body)
+	}
+	// No Content tells the commands decoding the response that there's
+	// nothing to decode.
+	return &http.Response{
+		Status:     "204 No Content",
+		StatusCode: http.StatusNoContent,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(&bytes.Buffer{}),
+		Request:    req,
+	}, nil
+}
+
+// writeRequestBody writes the body of a request in a readable way, decoding
+// forms and multipart bodies.
+func writeRequestBody(w io.Writer, contentType string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err == nil {
+			writeValues(w, values)
+			return
+		}
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			data, _ := ioutil.ReadAll(part)
+			if part.FileName() != "" {
+				fmt.Fprintf(w, "  %s: file %q (%d bytes)\n", part.FormName(), part.FileName(), len(data))
+			} else {
+				fmt.Fprintf(w, "  %s=%s\n", part.FormName(), data)
+			}
+		}
+		return
+	}
+	fmt.Fprintf(w, "  %s\n", strings.TrimRight(string(body), "\n"))
+}
+
+func writeValues(w io.Writer, values url.Values) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range values[key] {
+			fmt.Fprintf(w, "  %s=%s\n", key, value)
+		}
+	}
+}
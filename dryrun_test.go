@@ -0,0 +1,98 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestDryRunTransportSendsGET(c *check.C) {
+	var out bytes.Buffer
+	trans := &dryRunTransport{
+		transport: &cmdtest.Transport{Message: `[{"name":"router1"}]`, Status: http.StatusOK},
+		out:       &out,
+	}
+	req, err := http.NewRequest("GET", "http://localhost/plans/routers", nil)
+	c.Assert(err, check.IsNil)
+	rsp, err := trans.RoundTrip(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(rsp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(out.String(), check.Equals, "")
+}
+
+func (s *S) TestDryRunTransportForm(c *check.C) {
+	var out bytes.Buffer
+	trans := &dryRunTransport{transport: &cmdtest.Transport{Status: http.StatusInternalServerError}, out: &out}
+	v := url.Values{"name": []string{"plan1"}, "memory": []string{"512"}}
+	req, err := http.NewRequest("POST", "http://localhost/plans", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rsp, err := trans.RoundTrip(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(rsp.StatusCode, check.Equals, http.StatusNoContent)
+	c.Assert(out.String(), check.Equals, `Dry run: POST http://localhost/plans
+  memory=512
+  name=plan1
+`)
+}
+
+func (s *S) TestDryRunTransportMultipart(c *check.C) {
+	var out, body bytes.Buffer
+	trans := &dryRunTransport{transport: &cmdtest.Transport{Status: http.StatusInternalServerError}, out: &out}
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("name", "python")
+	file, err := writer.CreateFormFile("dockerfile_content", "Dockerfile")
+	c.Assert(err, check.IsNil)
+	file.Write([]byte("FROM tsuru/python"))
+	writer.Close()
+	req, err := http.NewRequest("PUT", "http://localhost/platforms/python", &body)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	_, err = trans.RoundTrip(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(out.String(), check.Equals, `Dry run: PUT http://localhost/platforms/python
+  name=python
+  dockerfile_content: file "Dockerfile" (17 bytes)
+`)
+}
+
+func (s *S) TestDryRunTransportWithCommand(c *check.C) {
+	var stdout, stderr, out bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &dryRunTransport{transport: &cmdtest.Transport{Status: http.StatusInternalServerError}, out: &out}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appLockDelete{}
+	command.Flags().Parse(true, []string{"--app", "app1", "-y"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(out.String(), check.Equals, "Dry run: DELETE http://localhost/1.0/apps/app1/lock\n")
+}
+
+func (s *S) TestDryRunTransportWithDecodingCommand(c *check.C) {
+	var stdout, stderr, out bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &dryRunTransport{transport: &cmdtest.Transport{Status: http.StatusInternalServerError}, out: &out}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := appRoutesRebuild{}
+	command.Flags().Parse(true, []string{"--app", "app1"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(out.String(), check.Equals, "Dry run: POST http://localhost/1.0/apps/app1/routes\n")
+	c.Assert(stdout.String(), check.Equals, "")
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestEndToEndPlans(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	c.Assert(api.mustRun(c, "plan-create", "small", "-c", "2", "-m", "512M", "--default"), check.Equals, "Plan successfully created!\n")
+	api.mustRun(c, "plan-create", "large", "-c", "8", "-m", "4G")
+	c.Assert(api.mustRun(c, completeCommand, "plan-remove", ""), check.Equals, "large\nsmall\n")
+	c.Assert(api.plans["small"], check.DeepEquals, app.Plan{Name: "small", Memory: 512 << 20, CpuShare: 2, Default: true})
+	_, err := api.run("plan-create", "small", "-c", "4")
+	c.Assert(err, check.FitsTypeOf, &tsuruErrors.HTTP{})
+	c.Assert(exitCode(err), check.Equals, exitConflict)
+	c.Assert(api.mustRun(c, "plan-remove", "small"), check.Equals, "Plan successfully removed!\n")
+	_, err = api.run("plan-remove", "small")
+	c.Assert(exitCode(err), check.Equals, exitNotFound)
+	c.Assert(api.mustRun(c, completeCommand, "plan-remove", ""), check.Equals, "large\n")
+}
+
+func (s *S) TestEndToEndPools(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.mustRun(c, "pool-add", "pool1", "--default")
+	api.mustRun(c, "pool-add", "pool2")
+	_, err := api.run("pool-update", "pool2", "--default=true")
+	c.Assert(err, check.IsNil)
+	c.Assert(api.pools["pool2"].Default, check.Equals, false)
+	c.Assert(api.mustRun(c, "pool-update", "pool2", "--default=true", "-f"), check.Equals, "Pool successfully updated.\n")
+	c.Assert(api.pools["pool1"].Default, check.Equals, false)
+	c.Assert(api.pools["pool2"].Default, check.Equals, true)
+	api.mustRun(c, "pool-teams-add", "pool1", "team1", "team2")
+	api.mustRun(c, "pool-teams-remove", "pool1", "team1")
+	c.Assert(api.pools["pool1"], check.DeepEquals, &provision.Pool{Name: "pool1", Teams: []string{"team2"}})
+	api.mustRun(c, "pool-remove", "pool1", "-y")
+	c.Assert(api.mustRun(c, completeCommand, "pool-update", ""), check.Equals, "pool2\n")
+}
+
+func (s *S) TestEndToEndTemplates(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.mustRun(c, "machine-template-add", "small", "ec2", "region=us-east-1", "type=t2.small")
+	api.mustRun(c, "machine-template-copy", "small", "large", "type=t2.large")
+	api.mustRun(c, "machine-template-update", "small", "type=t2.micro")
+	c.Assert(api.templates["small"], check.DeepEquals, iaas.Template{
+		Name:     "small",
+		IaaSName: "ec2",
+		Data:     iaas.TemplateDataList{{Name: "region", Value: "us-east-1"}, {Name: "type", Value: "t2.micro"}},
+	})
+	c.Assert(api.templates["large"].Data, check.DeepEquals, iaas.TemplateDataList{{Name: "region", Value: "us-east-1"}, {Name: "type", Value: "t2.large"}})
+	api.mustRun(c, "machine-template-remove", "small")
+	var templates []iaas.Template
+	err := json.Unmarshal([]byte(api.mustRun(c, "machine-template-list", "-o", "json")), &templates)
+	c.Assert(err, check.IsNil)
+	c.Assert(templates, check.HasLen, 1)
+	c.Assert(templates[0].Name, check.Equals, "large")
+	c.Assert(api.mustRun(c, completeCommand, "machine-template-remove", ""), check.Equals, "large\n")
+}
+
+func (s *S) TestEndToEndMachines(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.addMachine(iaas.Machine{Id: "m1", Iaas: "ec2", Status: "running", Address: "10.0.0.1"})
+	api.addMachine(iaas.Machine{Id: "m2", Iaas: "ec2", Status: "running", Address: "10.0.0.2"})
+	api.mustRun(c, "machine-destroy", "m1")
+	c.Assert(api.mustRun(c, completeCommand, "machine-destroy", ""), check.Equals, "m2\n")
+	_, err := api.run("machine-destroy", "m1")
+	c.Assert(exitCode(err), check.Equals, exitNotFound)
+}
+
+func (s *S) TestEndToEndQuotas(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.addUser("admin@example.com")
+	api.addApp(appSummary{Name: "app1"})
+	api.userQuotas["admin@example.com"].InUse = 2
+	api.mustRun(c, "user-quota-change", "admin@example.com", "5")
+	c.Assert(api.mustRun(c, "user-quota-view", "admin@example.com"), check.Equals, "User: admin@example.com\nApps usage: 2/5\n")
+	_, err := api.run("user-quota-change", "admin@example.com", "1")
+	c.Assert(exitCode(err), check.Equals, exitForbidden)
+	api.mustRun(c, "app-quota-change", "app1", "unlimited")
+	api.mustRun(c, "app-quota-change", "app1", "10")
+	c.Assert(api.appQuotas["app1"].Limit, check.Equals, 10)
+	_, err = api.run("app-quota-view", "app2")
+	c.Assert(exitCode(err), check.Equals, exitNotFound)
+}
+
+func (s *S) TestEndToEndPlatforms(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.mustRun(c, "platform-add", "python", "-i", "tsuru/python")
+	api.mustRun(c, "platform-update", "python", "--disable")
+	c.Assert(api.platforms["python"].Disabled, check.Equals, true)
+	api.mustRun(c, "platform-update", "python", "--enable")
+	c.Assert(api.platforms["python"].Disabled, check.Equals, false)
+	api.mustRun(c, "platform-remove", "python", "-y")
+	c.Assert(api.platforms, check.HasLen, 0)
+}
+
+func (s *S) TestEndToEndLocks(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	acquired := time.Date(2016, 5, 10, 12, 0, 0, 0, time.UTC)
+	api.addApp(appSummary{Name: "app1", Lock: app.AppLock{Locked: true, Owner: "admin", Reason: "deploy", AcquireDate: acquired}})
+	api.addApp(appSummary{Name: "app2", Lock: app.AppLock{Locked: true, Owner: "admin", Reason: "restart", AcquireDate: acquired}})
+	api.addApp(appSummary{Name: "app3"})
+	c.Assert(api.mustRun(c, "app-lock-list"), check.Equals, `+------+-------+---------+----------------------+
+| App  | Owner | Reason  | Acquire Date         |
++------+-------+---------+----------------------+
+| app1 | admin | deploy  | 2016-05-10T12:00:00Z |
+| app2 | admin | restart | 2016-05-10T12:00:00Z |
++------+-------+---------+----------------------+
+`)
+	api.mustRun(c, "app-unlock", "-a", "app1", "-y")
+	api.mustRun(c, "app-unlock", "--all", "-y")
+	c.Assert(api.mustRun(c, "app-lock-list"), check.Equals, "No locked apps.\n")
+	c.Assert(api.requests[len(api.requests)-1], check.Equals, "GET /apps")
+}
+
+func (s *S) TestFakeAPIUnknownPath(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	resp, err := http.Get(api.server.URL + "/1.0/unknown")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusNotFound)
+}
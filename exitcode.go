@@ -0,0 +1,256 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+)
+
+// Exit codes of tsuru-admin, documented in the reference.
+const (
+	exitError     = 1
+	exitNotFound  = 3
+	exitConflict  = 4
+	exitForbidden = 5
+	exitNetwork   = 6
+)
+
+const errorFormatEnv = "TSURU_ADMIN_ERROR_FORMAT"
+
+// parseErrorFormat validates the format of the errors reported by
+// tsuru-admin.
+func parseErrorFormat(value string) (string, error) {
+	switch value {
+	case "text", "json":
+		return value, nil
+	}
+	return "", fmt.Errorf("%q is not a valid error format, expected text or json", value)
+}
+
+// exitCode returns the exit code for the error returned by a command.
+func exitCode(err error) int {
+	if pluginErr, ok := err.(*pluginError); ok {
+		return pluginErr.status
+	}
+	if httpErr, ok := err.(*tsuruErrors.HTTP); ok {
+		switch httpErr.Code {
+		case http.StatusNotFound:
+			return exitNotFound
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			return exitConflict
+		case http.StatusForbidden:
+			return exitForbidden
+		}
+		return exitError
+	}
+	if isNetworkError(err) {
+		return exitNetwork
+	}
+	return exitError
+}
+
+// isNetworkError reports whether the error was caused by a failure to talk
+// to the tsuru API. cmd.Client replaces these errors with a message of its
+// own, which is also detected.
+func isNetworkError(err error) bool {
+	switch err.(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return strings.HasPrefix(err.Error(), "Failed to connect to tsuru server")
+}
+
+// errorReport is the error written by the json error format.
+type errorReport struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Method  string `json:"method"`
+	URL     string `json:"url"`
+	Status  int    `json:"status,omitempty"`
+}
+
+// errorReporter writes the errors returned by commands in the chosen format,
+// returning the exit code of the error. It keeps track of the last failed
+// request, reported along with the error.
+type errorReporter struct {
+	format string
+	stderr io.Writer
+	mu     sync.Mutex
+	method string
+	url    string
+}
+
+func newErrorReporter(format string, stderr io.Writer) *errorReporter {
+	return &errorReporter{format: format, stderr: stderr}
+}
+
+// commandError is the error of a command wrapped by errorReportingCommand.
+// cmd.Manager exits with status 1 by itself when a command fails, so the
+// error is raised as a panic, stopping the manager, and recovered by run.
+type commandError struct {
+	err error
+}
+
+func (e *commandError) Error() string {
+	return e.err.Error()
+}
+
+// run runs the command line with the manager, which exits by itself unless
+// the command fails, returning the exit code of the error in this case.
+func (r *errorReporter) run(manager *cmd.Manager, args []string) (code int) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			failure, ok := recovered.(*commandError)
+			if !ok {
+				panic(recovered)
+			}
+			code = r.report(failure.err)
+		}
+	}()
+	manager.Run(args)
+	return 0
+}
+
+// track wraps the transport of the client, so failed requests are reported
+// along with the errors.
+func (r *errorReporter) track(client *http.Client) {
+	client.Transport = &errorTrackingTransport{transport: transportOf(client), reporter: r}
+}
+
+func (r *errorReporter) failedRequest(req *http.Request) {
+	u := *req.URL
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = redactValues(u.Query()).Encode()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.method, r.url = req.Method, u.String()
+}
+
+// report writes the error, returning its exit code.
+func (r *errorReporter) report(err error) int {
+	code := exitCode(err)
+	message := strings.TrimSuffix(err.Error(), "\n")
+	if r.format == "json" {
+		r.mu.Lock()
+		report := errorReport{Code: code, Message: message, Method: r.method, URL: r.url}
+		r.mu.Unlock()
+		if httpErr, ok := err.(*tsuruErrors.HTTP); ok {
+			report.Status = httpErr.Code
+		}
+		json.NewEncoder(r.stderr).Encode(report)
+	} else {
+		fmt.Fprintf(r.stderr, "Error: %s\n", message)
+	}
+	return code
+}
+
+// wrap replaces the command about to be run by the manager with one that
+// reports its errors, given the arguments handed to the manager.
+func (r *errorReporter) wrap(manager *cmd.Manager, args []string) {
+	name := commandName(args)
+	command, ok := manager.Commands[name]
+	if !ok {
+		return
+	}
+	switch c := command.(type) {
+	case *cmd.RemovedCommand:
+	case *cmd.DeprecatedCommand:
+		c.Command = &errorReportingCommand{Command: c.Command, reporter: r}
+	default:
+		manager.Commands[name] = &errorReportingCommand{Command: command, reporter: r}
+	}
+}
+
+// commandName returns the name of the command in the arguments handed to
+// the manager, skipping the global flags handled by it.
+func commandName(args []string) string {
+	if i := commandIndex(args); i >= 0 {
+		return args[i]
+	}
+	return ""
+}
+
+// commandIndex returns the index of the command name in the arguments handed
+// to the manager, skipping the global flags and their values, or -1 when
+// there's none.
+func commandIndex(args []string) int {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return i
+		}
+		if name := strings.TrimLeft(arg, "-"); takesValue(name) {
+			i++
+		}
+	}
+	return -1
+}
+
+// errorReportingCommand raises the errors returned by the command as a
+// commandError, except for the first authentication error, left to the
+// manager so it's able to call the login command and run the command again.
+type errorReportingCommand struct {
+	cmd.Command
+	reporter *errorReporter
+	runs     int
+}
+
+func (c *errorReportingCommand) freshCopy() cmd.Command {
+	return &errorReportingCommand{Command: freshCommand(c.Command), reporter: c.reporter}
+}
+
+func (c *errorReportingCommand) Flags() *gnuflag.FlagSet {
+	if flagged, ok := c.Command.(cmd.FlaggedCommand); ok {
+		return flagged.Flags()
+	}
+	return gnuflag.NewFlagSet(c.Info().Name, gnuflag.ExitOnError)
+}
+
+func (c *errorReportingCommand) Run(context *cmd.Context, client *cmd.Client) error {
+	// The pager buffering the output is only flushed when the manager
+	// exits, which doesn't happen when the command fails, so the output
+	// isn't paged.
+	context.RawOutput()
+	c.runs++
+	err := c.Command.Run(context, client)
+	if err == nil || err == cmd.ErrAbortCommand {
+		return err
+	}
+	if httpErr, ok := err.(*tsuruErrors.HTTP); ok && httpErr.Code == http.StatusUnauthorized {
+		if c.runs == 1 {
+			return err
+		}
+		err = fmt.Errorf(`You're not authenticated or your session has expired. Please use %q command for authentication.`, "login")
+	}
+	panic(&commandError{err: err})
+}
+
+// errorTrackingTransport tells the reporter about the requests failing with
+// network errors or error statuses.
+type errorTrackingTransport struct {
+	transport http.RoundTripper
+	reporter  *errorReporter
+}
+
+func (t *errorTrackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		t.reporter.failedRequest(req)
+	}
+	return resp, err
+}
@@ -0,0 +1,889 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ajg/form"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/iaas"
+	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/docker/healer"
+	"github.com/tsuru/tsuru/provision/docker/nodecontainer"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/check.v1"
+)
+
+// fakeAPI is an in-process tsuru API keeping the state of the resources
+// managed by tsuru-admin, so commands are tested end to end, many commands
+// in a row, without building the responses of each request by hand.
+//
+// Only the behavior relied upon by tsuru-admin is implemented: resources are
+// validated by name, and the status codes match the ones of the tsuru API.
+type fakeAPI struct {
+	server *httptest.Server
+	target string
+	// client is the HTTP client used by the commands run against the API.
+	client *http.Client
+
+	mu         sync.Mutex
+	requests   []string
+	plans      map[string]app.Plan
+	routers    []router.PlanRouter
+	pools      map[string]*provision.Pool
+	platforms  map[string]*app.Platform
+	templates  map[string]iaas.Template
+	machines   map[string]iaas.Machine
+	apps       map[string]*appSummary
+	appQuotas  map[string]*quota.Quota
+	userQuotas map[string]*quota.Quota
+	roles      map[string]*permission.Role
+	// nodeContainers, healing, autoScaleRules and logs hold the resources
+	// of the docker provisioner. The healing configurations are kept as set,
+	// without the inherited values.
+	nodeContainers map[string]*nodecontainer.NodeContainerConfigGroup
+	healing        map[string]healer.NodeHealerConfig
+	autoScaleRules map[string]clusterAutoScaleRule
+	logs           map[string]container.DockerLogConfig
+}
+
+// newFakeAPI starts the fake API and points the tsuru target to it, until
+// it's closed.
+func newFakeAPI() *fakeAPI {
+	api := &fakeAPI{
+		plans:      make(map[string]app.Plan),
+		pools:      make(map[string]*provision.Pool),
+		platforms:  make(map[string]*app.Platform),
+		templates:  make(map[string]iaas.Template),
+		machines:   make(map[string]iaas.Machine),
+		apps:       make(map[string]*appSummary),
+		appQuotas:  make(map[string]*quota.Quota),
+		userQuotas: make(map[string]*quota.Quota),
+		roles:      make(map[string]*permission.Role),
+
+		nodeContainers: make(map[string]*nodecontainer.NodeContainerConfigGroup),
+		healing:        make(map[string]healer.NodeHealerConfig),
+		autoScaleRules: make(map[string]clusterAutoScaleRule),
+		logs:           make(map[string]container.DockerLogConfig),
+	}
+	api.client = &http.Client{}
+	api.server = httptest.NewServer(api)
+	api.target = os.Getenv("TSURU_TARGET")
+	os.Setenv("TSURU_TARGET", api.server.URL)
+	return api
+}
+
+func (api *fakeAPI) close() {
+	api.server.Close()
+	os.Setenv("TSURU_TARGET", api.target)
+}
+
+// run runs the command line against the fake API, returning the standard
+// output of the command.
+func (api *fakeAPI) run(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	m := buildManager("tsuru-admin")
+	context := &cmd.Context{Stdout: &stdout, Stderr: &stderr, Stdin: strings.NewReader("")}
+	client := cmd.NewClient(api.client, context, m)
+	var err error
+	if len(args) > 0 && hiddenCommands(m, "tsuru-admin")[args[0]] != nil {
+		context.Args = args
+		err = runHiddenCommand(m, hiddenCommands(m, "tsuru-admin"), context)
+	} else {
+		runner := commandRunner{manager: m, client: client}
+		err = runner.run(context, args)
+	}
+	return stdout.String(), err
+}
+
+// mustRun runs the command line, failing the test when it fails.
+func (api *fakeAPI) mustRun(c *check.C, args ...string) string {
+	stdout, err := api.run(args...)
+	c.Assert(err, check.IsNil, check.Commentf("%s: %s", strings.Join(args, " "), stdout))
+	return stdout
+}
+
+// addApp adds an app to the fake API, along with its quota.
+func (api *fakeAPI) addApp(a appSummary) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.apps[a.Name] = &a
+	api.appQuotas[a.Name] = &quota.Quota{Limit: -1}
+}
+
+// addUser adds a user to the fake API, with an unlimited quota.
+func (api *fakeAPI) addUser(email string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.userQuotas[email] = &quota.Quota{Limit: -1}
+}
+
+func (api *fakeAPI) addMachine(machine iaas.Machine) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.machines[machine.Id] = machine
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/1.0")
+	api.requests = append(api.requests, r.Method+" "+path)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	var err error
+	switch parts[0] {
+	case "plans":
+		err = api.servePlans(w, r, parts[1:])
+	case "pools":
+		err = api.servePools(w, r, parts[1:])
+	case "platforms":
+		err = api.servePlatforms(w, r, parts[1:])
+	case "iaas":
+		err = api.serveIaaS(w, r, parts[1:])
+	case "apps":
+		err = api.serveApps(w, r, parts[1:])
+	case "users":
+		err = api.serveUsers(w, r, parts[1:])
+	case "roles":
+		err = api.serveRoles(w, r, parts[1:])
+	case "role":
+		err = api.serveDefaultRoles(w, r, parts[1:])
+	case "docker":
+		err = api.serveDocker(w, r, parts[1:])
+	default:
+		err = errNotFound
+	}
+	if err != nil {
+		writeFakeError(w, err)
+	}
+}
+
+// fakeError is an error response of the fake API.
+type fakeError struct {
+	status  int
+	message string
+}
+
+func (e *fakeError) Error() string {
+	return e.message
+}
+
+var (
+	errNotFound         = &fakeError{http.StatusNotFound, "not found"}
+	errMethodNotAllowed = &fakeError{http.StatusMethodNotAllowed, "method not allowed"}
+)
+
+func writeFakeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*fakeError); ok {
+		status = e.status
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(data)
+}
+
+func (api *fakeAPI) servePlans(w http.ResponseWriter, r *http.Request, parts []string) error {
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		if len(api.plans) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		var names []string
+		for name := range api.plans {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		plans := make([]app.Plan, len(names))
+		for i, name := range names {
+			plans[i] = api.plans[name]
+		}
+		return writeJSON(w, plans)
+	case len(parts) == 0 && r.Method == "POST":
+		plan, err := planFromForm(r)
+		if err != nil {
+			return err
+		}
+		if _, ok := api.plans[plan.Name]; ok {
+			return &fakeError{http.StatusConflict, "plan already exists"}
+		}
+		if plan.Default {
+			for name, p := range api.plans {
+				p.Default = false
+				api.plans[name] = p
+			}
+		}
+		api.plans[plan.Name] = plan
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	case len(parts) == 1 && parts[0] == "routers" && r.Method == "GET":
+		return writeJSON(w, api.routers)
+	case len(parts) == 1 && r.Method == "DELETE":
+		if _, ok := api.plans[parts[0]]; !ok {
+			return &fakeError{http.StatusNotFound, "plan not found"}
+		}
+		delete(api.plans, parts[0])
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+func planFromForm(r *http.Request) (app.Plan, error) {
+	plan := app.Plan{
+		Name:    r.FormValue("name"),
+		Router:  r.FormValue("router"),
+		Default: r.FormValue("default") == "true",
+	}
+	if plan.Name == "" {
+		return plan, &fakeError{http.StatusBadRequest, "plan name is required"}
+	}
+	var err error
+	plan.CpuShare, err = strconv.Atoi(r.FormValue("cpushare"))
+	if err != nil || plan.CpuShare < 2 {
+		return plan, &fakeError{http.StatusBadRequest, "invalid cpushare value"}
+	}
+	if plan.Memory, err = parseFakeSize(r.FormValue("memory")); err != nil {
+		return plan, err
+	}
+	if plan.Swap, err = parseFakeSize(r.FormValue("swap")); err != nil {
+		return plan, err
+	}
+	return plan, nil
+}
+
+func parseFakeSize(value string) (int64, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	size, err := parseSize(value)
+	if err != nil {
+		return 0, &fakeError{http.StatusBadRequest, err.Error()}
+	}
+	return size, nil
+}
+
+func (api *fakeAPI) servePools(w http.ResponseWriter, r *http.Request, parts []string) error {
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		var names []string
+		for name := range api.pools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		pools := make([]provision.Pool, len(names))
+		for i, name := range names {
+			pools[i] = *api.pools[name]
+		}
+		return writeJSON(w, pools)
+	case len(parts) == 0 && r.Method == "POST":
+		name := r.FormValue("name")
+		if name == "" {
+			return &fakeError{http.StatusBadRequest, "pool name is required"}
+		}
+		if _, ok := api.pools[name]; ok {
+			return &fakeError{http.StatusConflict, "pool already exists"}
+		}
+		pool := &provision.Pool{Name: name, Public: r.FormValue("public") == "true"}
+		if err := api.setDefaultPool(pool, r.FormValue("default"), r.FormValue("force")); err != nil {
+			return err
+		}
+		api.pools[name] = pool
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	}
+	if len(parts) == 0 {
+		return errMethodNotAllowed
+	}
+	pool, ok := api.pools[parts[0]]
+	if !ok {
+		return &fakeError{http.StatusNotFound, "pool does not exist"}
+	}
+	switch {
+	case len(parts) == 1 && r.Method == "PUT":
+		if public := r.FormValue("public"); public != "" {
+			pool.Public = public == "true"
+		}
+		return api.setDefaultPool(pool, r.FormValue("default"), r.FormValue("force"))
+	case len(parts) == 1 && r.Method == "DELETE":
+		delete(api.pools, pool.Name)
+		return nil
+	case len(parts) == 2 && parts[1] == "team" && r.Method == "POST":
+		r.ParseForm()
+		for _, team := range r.Form["team"] {
+			if !containsString(pool.Teams, team) {
+				pool.Teams = append(pool.Teams, team)
+			}
+		}
+		return nil
+	case len(parts) == 2 && parts[1] == "team" && r.Method == "DELETE":
+		teams := r.URL.Query()["team"]
+		var remaining []string
+		for _, team := range pool.Teams {
+			if !containsString(teams, team) {
+				remaining = append(remaining, team)
+			}
+		}
+		pool.Teams = remaining
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+// setDefaultPool makes the pool the default one, failing with 412 when
+// there's another default pool and the change isn't forced, like the tsuru
+// API does.
+func (api *fakeAPI) setDefaultPool(pool *provision.Pool, value, force string) error {
+	if value == "" {
+		return nil
+	}
+	if value != "true" {
+		pool.Default = false
+		return nil
+	}
+	for _, p := range api.pools {
+		if p.Default && p.Name != pool.Name {
+			if force != "true" {
+				return &fakeError{http.StatusPreconditionFailed, "Default pool already exist."}
+			}
+			p.Default = false
+		}
+	}
+	pool.Default = true
+	return nil
+}
+
+func (api *fakeAPI) servePlatforms(w http.ResponseWriter, r *http.Request, parts []string) error {
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		var names []string
+		for name := range api.platforms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		platforms := make([]app.Platform, len(names))
+		for i, name := range names {
+			platforms[i] = *api.platforms[name]
+		}
+		return writeJSON(w, platforms)
+	case len(parts) == 0 && r.Method == "POST":
+		name := r.FormValue("name")
+		if name == "" {
+			return &fakeError{http.StatusBadRequest, "platform name is required"}
+		}
+		if _, ok := api.platforms[name]; ok {
+			return &fakeError{http.StatusConflict, "duplicate platform"}
+		}
+		api.platforms[name] = &app.Platform{Name: name}
+		writeMessage(w, "Platform successfully added!\n")
+		return nil
+	case len(parts) == 1 && (r.Method == "PUT" || r.Method == "DELETE"):
+		platform, ok := api.platforms[parts[0]]
+		if !ok {
+			return &fakeError{http.StatusNotFound, "platform does not exist"}
+		}
+		if r.Method == "DELETE" {
+			delete(api.platforms, platform.Name)
+			return nil
+		}
+		if disabled := r.FormValue("disabled"); disabled != "" {
+			platform.Disabled = disabled == "true"
+		}
+		writeMessage(w, "Platform successfully updated!\n")
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+// writeMessage writes a message in the format of the streaming responses of
+// the tsuru API.
+func writeMessage(w http.ResponseWriter, message string) {
+	writer := tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(w)}
+	writer.Write([]byte(message))
+}
+
+func (api *fakeAPI) serveIaaS(w http.ResponseWriter, r *http.Request, parts []string) error {
+	if len(parts) == 0 {
+		return errNotFound
+	}
+	switch {
+	case parts[0] == "templates" && len(parts) == 1 && r.Method == "GET":
+		var names []string
+		for name := range api.templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		templates := make([]iaas.Template, len(names))
+		for i, name := range names {
+			templates[i] = api.templates[name]
+		}
+		return writeJSON(w, templates)
+	case parts[0] == "templates" && len(parts) == 1 && r.Method == "POST":
+		template, err := templateFromForm(r)
+		if err != nil {
+			return err
+		}
+		if _, ok := api.templates[template.Name]; ok {
+			return &fakeError{http.StatusConflict, "template name already used"}
+		}
+		api.templates[template.Name] = template
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	case parts[0] == "templates" && len(parts) == 2 && (r.Method == "PUT" || r.Method == "DELETE"):
+		if _, ok := api.templates[parts[1]]; !ok {
+			return &fakeError{http.StatusNotFound, "template not found"}
+		}
+		if r.Method == "DELETE" {
+			delete(api.templates, parts[1])
+			return nil
+		}
+		changes, err := templateFromForm(r)
+		if err != nil {
+			return err
+		}
+		api.templates[parts[1]] = mergeTemplate(api.templates[parts[1]], changes)
+		return nil
+	case parts[0] == "machines" && len(parts) == 1 && r.Method == "GET":
+		var ids []string
+		for id := range api.machines {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		machines := make([]iaas.Machine, len(ids))
+		for i, id := range ids {
+			machines[i] = api.machines[id]
+		}
+		return writeJSON(w, machines)
+	case parts[0] == "machines" && len(parts) == 2 && r.Method == "DELETE":
+		if _, ok := api.machines[parts[1]]; !ok {
+			return &fakeError{http.StatusNotFound, "machine not found"}
+		}
+		delete(api.machines, parts[1])
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+func templateFromForm(r *http.Request) (iaas.Template, error) {
+	var template iaas.Template
+	if err := r.ParseForm(); err != nil {
+		return template, &fakeError{http.StatusBadRequest, err.Error()}
+	}
+	if err := form.DecodeValues(&template, r.Form); err != nil {
+		return template, &fakeError{http.StatusBadRequest, err.Error()}
+	}
+	if template.Name == "" && r.Method == "POST" {
+		return template, &fakeError{http.StatusBadRequest, "template name is required"}
+	}
+	sort.Sort(template.Data)
+	return template, nil
+}
+
+// mergeTemplate applies the changes sent to the update endpoint to the
+// template like the tsuru API does: the IaaS and the "iaas" parameter are
+// ignored, parameters with empty values are removed and the others are added
+// or replaced.
+func mergeTemplate(template, changes iaas.Template) iaas.Template {
+	params := make(map[string]string)
+	for _, data := range template.Data {
+		params[data.Name] = data.Value
+	}
+	delete(params, "iaas")
+	for _, data := range changes.Data {
+		if data.Name == "iaas" {
+			continue
+		}
+		if data.Value == "" {
+			delete(params, data.Name)
+		} else {
+			params[data.Name] = data.Value
+		}
+	}
+	template.Data = nil
+	for name, value := range params {
+		template.Data = append(template.Data, iaas.TemplateData{Name: name, Value: value})
+	}
+	sort.Sort(template.Data)
+	return template
+}
+
+func (api *fakeAPI) serveApps(w http.ResponseWriter, r *http.Request, parts []string) error {
+	if len(parts) == 0 && r.Method == "GET" {
+		query := r.URL.Query()
+		var names []string
+		for name, a := range api.apps {
+			if query.Get("locked") == "true" && !a.Lock.Locked {
+				continue
+			}
+			if pool := query.Get("pool"); pool != "" && a.Pool != pool {
+				continue
+			}
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		sort.Strings(names)
+		apps := make([]appSummary, len(names))
+		for i, name := range names {
+			apps[i] = *api.apps[name]
+		}
+		return writeJSON(w, apps)
+	}
+	if len(parts) == 0 {
+		return errMethodNotAllowed
+	}
+	a, ok := api.apps[parts[0]]
+	if !ok {
+		return &fakeError{http.StatusNotFound, "App not found."}
+	}
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		return writeJSON(w, a)
+	case len(parts) == 2 && parts[1] == "lock" && r.Method == "DELETE":
+		a.Lock = app.AppLock{}
+		return nil
+	case len(parts) == 2 && parts[1] == "quota":
+		return serveQuota(w, r, api.appQuotas[a.Name])
+	}
+	return errMethodNotAllowed
+}
+
+func (api *fakeAPI) serveUsers(w http.ResponseWriter, r *http.Request, parts []string) error {
+	if len(parts) == 0 && r.Method == "GET" {
+		var emails []string
+		for email := range api.userQuotas {
+			emails = append(emails, email)
+		}
+		sort.Strings(emails)
+		users := make([]struct{ Email string }, len(emails))
+		for i, email := range emails {
+			users[i].Email = email
+		}
+		return writeJSON(w, users)
+	}
+	if len(parts) != 2 || parts[1] != "quota" {
+		return errNotFound
+	}
+	q, ok := api.userQuotas[parts[0]]
+	if !ok {
+		return &fakeError{http.StatusNotFound, "user not found"}
+	}
+	return serveQuota(w, r, q)
+}
+
+func serveQuota(w http.ResponseWriter, r *http.Request, q *quota.Quota) error {
+	switch r.Method {
+	case "GET":
+		return writeJSON(w, q)
+	case "PUT":
+		limit, err := strconv.Atoi(r.FormValue("limit"))
+		if err != nil {
+			return &fakeError{http.StatusBadRequest, "Invalid limit"}
+		}
+		if limit >= 0 && limit < q.InUse {
+			return &fakeError{http.StatusForbidden, "new limit is lesser than the current allocated value"}
+		}
+		q.Limit = limit
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+func (api *fakeAPI) serveRoles(w http.ResponseWriter, r *http.Request, parts []string) error {
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		var names []string
+		for name := range api.roles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		roles := make([]permission.Role, len(names))
+		for i, name := range names {
+			roles[i] = *api.roles[name]
+		}
+		return writeJSON(w, roles)
+	case len(parts) == 0 && r.Method == "POST":
+		name := r.FormValue("name")
+		if name == "" {
+			return &fakeError{http.StatusBadRequest, "invalid role name"}
+		}
+		if _, ok := api.roles[name]; ok {
+			return &fakeError{http.StatusConflict, "role already exists"}
+		}
+		role := &permission.Role{Name: name, Description: r.FormValue("description")}
+		// The type of the context isn't exported by the permission package.
+		reflect.ValueOf(&role.ContextType).Elem().SetString(r.FormValue("context"))
+		api.roles[name] = role
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	}
+	if len(parts) == 0 {
+		return errMethodNotAllowed
+	}
+	role, ok := api.roles[parts[0]]
+	if !ok {
+		return &fakeError{http.StatusNotFound, "role not found"}
+	}
+	switch {
+	case len(parts) == 1 && r.Method == "DELETE":
+		delete(api.roles, role.Name)
+		return nil
+	case len(parts) == 2 && parts[1] == "permissions" && r.Method == "POST":
+		r.ParseForm()
+		for _, name := range r.Form["permission"] {
+			if !containsString(role.SchemeNames, name) {
+				role.SchemeNames = append(role.SchemeNames, name)
+			}
+		}
+		return nil
+	case len(parts) == 3 && parts[1] == "permissions" && r.Method == "DELETE":
+		role.SchemeNames = removeString(role.SchemeNames, parts[2])
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+// serveDefaultRoles adds and removes the events roles are assigned by
+// default on, sent as <event>=<role>.
+func (api *fakeAPI) serveDefaultRoles(w http.ResponseWriter, r *http.Request, parts []string) error {
+	if len(parts) != 1 || parts[0] != "default" {
+		return errNotFound
+	}
+	values := r.URL.Query()
+	switch r.Method {
+	case "POST":
+		r.ParseForm()
+		values = r.PostForm
+	case "DELETE":
+	default:
+		return errMethodNotAllowed
+	}
+	for event, names := range values {
+		for _, name := range names {
+			role, ok := api.roles[name]
+			if !ok {
+				return &fakeError{http.StatusBadRequest, "role not found"}
+			}
+			if r.Method == "DELETE" {
+				role.Events = removeString(role.Events, event)
+			} else if !containsString(role.Events, event) {
+				role.Events = append(role.Events, event)
+			}
+		}
+	}
+	return nil
+}
+
+func removeString(list []string, value string) []string {
+	var result []string
+	for _, item := range list {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func (api *fakeAPI) serveDocker(w http.ResponseWriter, r *http.Request, parts []string) error {
+	path := strings.Join(parts, "/")
+	switch {
+	case path == "nodecontainers" && r.Method == "GET":
+		var names []string
+		for name := range api.nodeContainers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		groups := make([]nodecontainer.NodeContainerConfigGroup, len(names))
+		for i, name := range names {
+			groups[i] = *api.nodeContainers[name]
+		}
+		return writeJSON(w, groups)
+	case path == "healing/node" && r.Method == "GET":
+		return writeJSON(w, api.inheritedHealing())
+	case path == "autoscale/rules" && r.Method == "GET":
+		if len(api.autoScaleRules) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		var filters []string
+		for filter := range api.autoScaleRules {
+			filters = append(filters, filter)
+		}
+		sort.Strings(filters)
+		rules := make([]clusterAutoScaleRule, len(filters))
+		for i, filter := range filters {
+			rules[i] = api.autoScaleRules[filter]
+		}
+		return writeJSON(w, rules)
+	case path == "logs" && r.Method == "GET":
+		return writeJSON(w, api.logs)
+	case path == "nodecontainers" && r.Method == "POST":
+		return api.setNodeContainer(r)
+	case parts[0] == "nodecontainers" && len(parts) == 2 && r.Method == "DELETE":
+		group, ok := api.nodeContainers[parts[1]]
+		pool := r.URL.Query().Get("pool")
+		if !ok {
+			return &fakeError{http.StatusNotFound, "node container not found"}
+		}
+		if _, ok = group.ConfigPools[pool]; !ok {
+			return &fakeError{http.StatusNotFound, "node container not found for pool"}
+		}
+		delete(group.ConfigPools, pool)
+		if len(group.ConfigPools) == 0 {
+			delete(api.nodeContainers, group.Name)
+		}
+		return nil
+	case path == "healing/node" && r.Method == "POST":
+		r.ParseForm()
+		pool := r.FormValue("pool")
+		delete(r.Form, "pool")
+		var changes healer.NodeHealerConfig
+		if err := decodeFakeForm(&changes, r.Form); err != nil {
+			return err
+		}
+		config := api.healing[pool]
+		if changes.Enabled != nil {
+			config.Enabled = changes.Enabled
+		}
+		if changes.MaxTimeSinceSuccess != nil {
+			config.MaxTimeSinceSuccess = changes.MaxTimeSinceSuccess
+		}
+		if changes.MaxUnresponsiveTime != nil {
+			config.MaxUnresponsiveTime = changes.MaxUnresponsiveTime
+		}
+		api.healing[pool] = config
+		return nil
+	case path == "healing/node" && r.Method == "DELETE":
+		pool := r.URL.Query().Get("pool")
+		names := r.URL.Query()["name"]
+		if len(names) == 0 {
+			delete(api.healing, pool)
+			return nil
+		}
+		config := api.healing[pool]
+		for _, name := range names {
+			switch name {
+			case "Enabled":
+				config.Enabled = nil
+			case "MaxTimeSinceSuccess":
+				config.MaxTimeSinceSuccess = nil
+			case "MaxUnresponsiveTime":
+				config.MaxUnresponsiveTime = nil
+			}
+		}
+		api.healing[pool] = config
+		return nil
+	case path == "autoscale/rules" && r.Method == "POST":
+		r.ParseForm()
+		var rule clusterAutoScaleRule
+		if err := decodeFakeForm(&rule, r.Form); err != nil {
+			return err
+		}
+		api.autoScaleRules[rule.MetadataFilter] = rule
+		return nil
+	case strings.HasPrefix(path, "autoscale/rules") && len(parts) <= 3 && r.Method == "DELETE":
+		filter := strings.Join(parts[2:], "")
+		if _, ok := api.autoScaleRules[filter]; !ok {
+			return &fakeError{http.StatusNotFound, "rule not found"}
+		}
+		delete(api.autoScaleRules, filter)
+		return nil
+	case path == "logs" && r.Method == "POST":
+		r.ParseForm()
+		pool := r.FormValue("pool")
+		delete(r.Form, "pool")
+		delete(r.Form, "restart")
+		var config container.DockerLogConfig
+		if err := decodeFakeForm(&config, r.Form); err != nil {
+			return err
+		}
+		api.logs[pool] = config
+		writeMessage(w, "Log config successfully updated.\n")
+		return nil
+	}
+	return errNotFound
+}
+
+// setNodeContainer creates or replaces the configuration of a node
+// container for a pool.
+func (api *fakeAPI) setNodeContainer(r *http.Request) error {
+	r.ParseForm()
+	pool := r.FormValue("pool")
+	var config nodecontainer.NodeContainerConfig
+	if err := decodeFakeForm(&config, r.Form); err != nil {
+		return err
+	}
+	if config.Name == "" {
+		return &fakeError{http.StatusBadRequest, "node container config name cannot be empty"}
+	}
+	group, ok := api.nodeContainers[config.Name]
+	if !ok {
+		group = &nodecontainer.NodeContainerConfigGroup{
+			Name:        config.Name,
+			ConfigPools: make(map[string]nodecontainer.NodeContainerConfig),
+		}
+	}
+	if config.Config.Image == "" && (pool == "" || group.ConfigPools[""].Config.Image == "") {
+		return &fakeError{http.StatusBadRequest, "node container config image cannot be empty"}
+	}
+	api.nodeContainers[config.Name] = group
+	group.ConfigPools[pool] = config
+	return nil
+}
+
+// decodeFakeForm decodes the form the way the docker handlers of the tsuru
+// API do.
+func decodeFakeForm(value interface{}, values url.Values) error {
+	dec := form.NewDecoder(nil)
+	dec.IgnoreUnknownKeys(true)
+	dec.IgnoreCase(true)
+	if err := dec.DecodeValues(value, values); err != nil {
+		return &fakeError{http.StatusBadRequest, err.Error()}
+	}
+	return nil
+}
+
+// inheritedHealing returns the healing configurations the way the tsuru API
+// does, with the values not set for a pool inherited from the default one.
+func (api *fakeAPI) inheritedHealing() map[string]healer.NodeHealerConfig {
+	result := make(map[string]healer.NodeHealerConfig, len(api.healing))
+	base := api.healing[""]
+	for pool, config := range api.healing {
+		if pool != "" {
+			if config.Enabled == nil {
+				config.Enabled, config.EnabledInherited = base.Enabled, true
+			}
+			if config.MaxTimeSinceSuccess == nil {
+				config.MaxTimeSinceSuccess, config.MaxTimeSinceSuccessInherited = base.MaxTimeSinceSuccess, true
+			}
+			if config.MaxUnresponsiveTime == nil {
+				config.MaxUnresponsiveTime, config.MaxUnresponsiveTimeInherited = base.MaxUnresponsiveTime, true
+			}
+		}
+		result[pool] = config
+	}
+	return result
+}
@@ -0,0 +1,428 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// The types below are the subset of the HTTP Archive (HAR) 1.2 format used
+// by tsuru-admin for recording sessions.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	// Error is the network error of requests that got no response, a custom
+	// field as allowed by the format.
+	Error string `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// redactedHeaders are the request headers whose values are never recorded.
+var redactedHeaders = map[string]bool{"Authorization": true, "Cookie": true}
+
+func harHeaders(header http.Header, redacted map[string]bool) []harNameValue {
+	var names []string
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers := []harNameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			if redacted[name] {
+				value = redactedValue
+			}
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func harQueryString(values url.Values) []harNameValue {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	query := []harNameValue{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			query = append(query, harNameValue{Name: name, Value: value})
+		}
+	}
+	return query
+}
+
+// harURL returns the URL of the request as recorded, with the values of
+// sensitive parameters redacted.
+func harURL(u *url.URL) string {
+	recorded := *u
+	recorded.User = nil
+	if recorded.RawQuery != "" {
+		recorded.RawQuery = redactValues(recorded.Query()).Encode()
+	}
+	return recorded.String()
+}
+
+// recordTransport records the requests and responses in a HAR file, which
+// is rewritten after each request so it's valid even when tsuru-admin exits
+// abruptly. Tokens and the values of sensitive parameters are redacted.
+type recordTransport struct {
+	transport http.RoundTripper
+	path      string
+	mu        sync.Mutex
+	har       harFile
+}
+
+func newRecordTransport(transport http.RoundTripper, path string) *recordTransport {
+	return &recordTransport{
+		transport: transport,
+		path:      path,
+		har: harFile{Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "tsuru-admin", Version: version},
+			Entries: []harEntry{},
+		}},
+	}
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	entry := harEntry{
+		StartedDateTime: start,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         harURL(req.URL),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     harHeaders(req.Header, redactedHeaders),
+			QueryString: harQueryString(redactValues(req.URL.Query())),
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(body),
+		},
+		Response: harResponse{Headers: []harNameValue{}, Cookies: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+	}
+	if len(body) > 0 {
+		contentType := req.Header.Get("Content-Type")
+		entry.Request.PostData = &harPostData{MimeType: contentType, Text: redactBody(contentType, body)}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.setTime(start)
+		if _, writeErr := t.add(entry); writeErr != nil {
+			return nil, fmt.Errorf("failed to record the session: %s", writeErr)
+		}
+		return nil, err
+	}
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Headers:     harHeaders(resp.Header, nil),
+		Cookies:     []harNameValue{},
+		Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if entry.Response.HTTPVersion == "" {
+		entry.Response.HTTPVersion = "HTTP/1.1"
+	}
+	entry.setTime(start)
+	index, writeErr := t.add(entry)
+	if writeErr != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to record the session: %s", writeErr)
+	}
+	resp.Body = &recordedBody{ReadCloser: resp.Body, transport: t, index: index, start: start}
+	return resp, nil
+}
+
+func (e *harEntry) setTime(start time.Time) {
+	elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+	e.Time = elapsed
+	e.Timings = harTimings{Wait: elapsed}
+}
+
+// setContent records the response body in the entry, redacting sensitive
+// values and encoding binary bodies in base64.
+func (e *harEntry) setContent(body []byte) {
+	e.Response.Content.Size = len(body)
+	e.Response.BodySize = len(body)
+	if utf8.Valid(body) {
+		// Responses without a content type are redacted as JSON, so tokens
+		// aren't recorded when the API doesn't set it.
+		redactAs := e.Response.Content.MimeType
+		if redactAs == "" {
+			redactAs = "application/json"
+		}
+		e.Response.Content.Text = redactBody(redactAs, body)
+	} else {
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		e.Response.Content.Encoding = "base64"
+	}
+}
+
+// add appends the entry to the HAR file, returning its index.
+func (t *recordTransport) add(entry harEntry) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.har.Log.Entries = append(t.har.Log.Entries, entry)
+	return len(t.har.Log.Entries) - 1, t.save()
+}
+
+// update changes the entry at the given index and rewrites the HAR file.
+func (t *recordTransport) update(index int, fn func(entry *harEntry)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&t.har.Log.Entries[index])
+	return t.save()
+}
+
+func (t *recordTransport) save() error {
+	data, err := json.MarshalIndent(t.har, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.path, data, 0600)
+}
+
+// recordedBody copies the response body as the caller reads it, so streamed
+// responses are displayed as they arrive, and records it in the HAR entry of
+// the request once it's fully read or closed.
+type recordedBody struct {
+	io.ReadCloser
+	transport *recordTransport
+	index     int
+	start     time.Time
+	buf       bytes.Buffer
+	once      sync.Once
+	err       error
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.record()
+	}
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.record()
+	if b.err != nil {
+		return b.err
+	}
+	return err
+}
+
+func (b *recordedBody) record() {
+	b.once.Do(func() {
+		err := b.transport.update(b.index, func(entry *harEntry) {
+			entry.setContent(b.buf.Bytes())
+			entry.setTime(b.start)
+		})
+		if err != nil {
+			b.err = fmt.Errorf("failed to record the session: %s", err)
+		}
+	})
+}
+
+// replayTransport serves the responses recorded in a HAR file instead of
+// sending the requests. Each request is answered by the first unused entry
+// with the same method, path and query string, regardless of the target.
+type replayTransport struct {
+	mu      sync.Mutex
+	entries []harEntry
+	used    []bool
+}
+
+func newReplayTransport(path string) (*replayTransport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var har harFile
+	if err = json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("invalid HAR file %s: %s", path, err)
+	}
+	return &replayTransport{entries: har.Log.Entries, used: make([]bool, len(har.Log.Entries))}, nil
+}
+
+// target returns the scheme and host of the first recorded request, used as
+// the target when none is set.
+func (t *replayTransport) target() string {
+	if len(t.entries) == 0 {
+		return ""
+	}
+	u, err := url.Parse(t.entries[0].Request.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func replayKey(method string, u *url.URL) string {
+	key := method + " " + u.Path
+	if u.RawQuery != "" {
+		key += "?" + redactValues(u.Query()).Encode()
+	}
+	return key
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := replayKey(req.Method, req.URL)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, entry := range t.entries {
+		if t.used[i] {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil || replayKey(entry.Request.Method, u) != key {
+			continue
+		}
+		t.used[i] = true
+		if entry.Error != "" {
+			return nil, errors.New(entry.Error)
+		}
+		return entry.Response.httpResponse(req)
+	}
+	return nil, fmt.Errorf("no recorded response for %s", key)
+}
+
+func (r *harResponse) httpResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Content.Text)
+	if r.Content.Encoding == "base64" {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(r.Content.Text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	header := http.Header{}
+	for _, h := range r.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	header.Del("Content-Length")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, r.StatusText),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// setReplayTarget points the tsuru target to the recorded one when no target
+// is set, so sessions are replayed on machines without targets.
+func setReplayTarget(t *replayTransport) {
+	if _, err := cmd.ReadTarget(); err == nil {
+		return
+	}
+	if target := t.target(); target != "" {
+		os.Setenv("TSURU_TARGET", target)
+	}
+}
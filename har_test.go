@@ -0,0 +1,231 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/check.v1"
+)
+
+func readHAR(c *check.C, path string) harFile {
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	var har harFile
+	err = json.Unmarshal(data, &har)
+	c.Assert(err, check.IsNil)
+	return har
+}
+
+func (s *S) TestRecordTransport(c *check.C) {
+	dir, err := ioutil.TempDir("", "har")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.har")
+	trans := &cmdtest.Transport{Message: `{"token":"abc123","name":"admin"}`, Status: http.StatusCreated}
+	client := &http.Client{Transport: newRecordTransport(trans, path)}
+	req, err := http.NewRequest("POST", "http://localhost/1.0/users?key=secret", strings.NewReader("email=admin%40example.com&password=123456"))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "bearer mytoken")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(body), check.Equals, `{"token":"abc123","name":"admin"}`)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Not(check.Matches), `(?s).*(mytoken|123456|abc123|secret).*`)
+	har := readHAR(c, path)
+	c.Assert(har.Log.Version, check.Equals, "1.2")
+	c.Assert(har.Log.Creator.Name, check.Equals, "tsuru-admin")
+	c.Assert(har.Log.Entries, check.HasLen, 1)
+	entry := har.Log.Entries[0]
+	c.Assert(entry.Request.Method, check.Equals, "POST")
+	c.Assert(entry.Request.URL, check.Equals, "http://localhost/1.0/users?key=%2A%2A%2A%2A%2A")
+	c.Assert(entry.Request.Headers, check.DeepEquals, []harNameValue{
+		{Name: "Authorization", Value: redactedValue},
+		{Name: "Content-Type", Value: "application/x-www-form-urlencoded"},
+	})
+	c.Assert(entry.Request.QueryString, check.DeepEquals, []harNameValue{{Name: "key", Value: redactedValue}})
+	c.Assert(entry.Request.PostData.Text, check.Equals, "email=admin%40example.com&password=%2A%2A%2A%2A%2A")
+	c.Assert(entry.Response.Status, check.Equals, http.StatusCreated)
+}
+
+func (s *S) TestRecordTransportNetworkError(c *check.C) {
+	dir, err := ioutil.TempDir("", "har")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.har")
+	client := &http.Client{Transport: newRecordTransport(&flakyTransport{failures: 1}, path)}
+	_, err = client.Get("http://localhost/1.0/pools")
+	c.Assert(err, check.ErrorMatches, ".*connection reset by peer")
+	har := readHAR(c, path)
+	c.Assert(har.Log.Entries, check.HasLen, 1)
+	c.Assert(har.Log.Entries[0].Error, check.Equals, "connection reset by peer")
+}
+
+type streamTransport struct {
+	body io.ReadCloser
+}
+
+func (t *streamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		Header:     http.Header{"Content-Type": []string{"application/x-json-stream"}},
+		Body:       t.body,
+	}, nil
+}
+
+func (s *S) TestRecordTransportStreamsBody(c *check.C) {
+	dir, err := ioutil.TempDir("", "har")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.har")
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write([]byte("line1\n"))
+		writer.Write([]byte("line2\n"))
+		writer.Close()
+	}()
+	client := &http.Client{Transport: newRecordTransport(&streamTransport{body: reader}, path)}
+	resp, err := client.Get("http://localhost/1.0/apps/myapp/deploy")
+	c.Assert(err, check.IsNil)
+	har := readHAR(c, path)
+	c.Assert(har.Log.Entries, check.HasLen, 1)
+	c.Assert(har.Log.Entries[0].Response.Status, check.Equals, http.StatusOK)
+	c.Assert(har.Log.Entries[0].Response.Content.Text, check.Equals, "")
+	line := make([]byte, 6)
+	_, err = io.ReadFull(resp.Body, line)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(line), check.Equals, "line1\n")
+	_, err = io.ReadFull(resp.Body, line)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(line), check.Equals, "line2\n")
+	c.Assert(resp.Body.Close(), check.IsNil)
+	har = readHAR(c, path)
+	c.Assert(har.Log.Entries, check.HasLen, 1)
+	content := har.Log.Entries[0].Response.Content
+	c.Assert(content.Text, check.Equals, "line1\nline2\n")
+	c.Assert(content.Size, check.Equals, 12)
+}
+
+func (s *S) TestReplayTransport(c *check.C) {
+	dir, err := ioutil.TempDir("", "har")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.har")
+	har := harFile{Log: harLog{Version: "1.2", Entries: []harEntry{
+		{
+			Request:  harRequest{Method: "GET", URL: "http://tsuru.example.com/1.0/pools"},
+			Response: harResponse{Status: http.StatusOK, StatusText: "OK", Content: harContent{Text: `[{"Name":"pool1"}]`}},
+		},
+		{
+			Request:  harRequest{Method: "GET", URL: "http://tsuru.example.com/1.0/pools"},
+			Response: harResponse{Status: http.StatusOK, StatusText: "OK", Content: harContent{Text: "W10=", Encoding: "base64"}},
+		},
+		{
+			Request: harRequest{Method: "DELETE", URL: "http://tsuru.example.com/1.0/pools/pool1"},
+			Error:   "connection refused",
+		},
+	}}}
+	data, err := json.Marshal(har)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(path, data, 0600)
+	c.Assert(err, check.IsNil)
+	replay, err := newReplayTransport(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(replay.target(), check.Equals, "http://tsuru.example.com")
+	client := &http.Client{Transport: replay}
+	for _, expected := range []string{`[{"Name":"pool1"}]`, "[]"} {
+		resp, err := client.Get("http://localhost:8080/1.0/pools")
+		c.Assert(err, check.IsNil)
+		c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+		body, _ := ioutil.ReadAll(resp.Body)
+		c.Assert(string(body), check.Equals, expected)
+	}
+	_, err = client.Get("http://localhost:8080/1.0/pools")
+	c.Assert(err, check.ErrorMatches, ".*no recorded response for GET /1.0/pools")
+	req, _ := http.NewRequest("DELETE", "http://localhost:8080/1.0/pools/pool1", nil)
+	_, err = client.Do(req)
+	c.Assert(err, check.ErrorMatches, ".*connection refused")
+}
+
+func (s *S) TestReplayTransportInvalidFile(c *check.C) {
+	dir, err := ioutil.TempDir("", "har")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.har")
+	err = ioutil.WriteFile(path, []byte("not json"), 0600)
+	c.Assert(err, check.IsNil)
+	_, err = newReplayTransport(path)
+	c.Assert(err, check.ErrorMatches, "invalid HAR file .*session.har: .*")
+	_, err = newReplayTransport(filepath.Join(dir, "missing.har"))
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestParseGlobalOptionsRecordReplay(c *check.C) {
+	opts, args, err := parseGlobalOptions([]string{"--record", "session.har", "plan-create", "small"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.recordFile, check.Equals, "session.har")
+	c.Assert(args, check.DeepEquals, []string{"plan-create", "small"})
+	c.Assert(opts.forwardArgs, check.DeepEquals, []string{"plan-create", "small"})
+	_, _, err = parseGlobalOptions([]string{"--record", "a.har", "--replay", "b.har", "plan-create"})
+	c.Assert(err, check.ErrorMatches, "the --record and --replay flags can't be used together")
+	_, _, err = parseGlobalOptions([]string{"--replay", "b.har", "--all-targets", "plan-create"})
+	c.Assert(err, check.ErrorMatches, "the --record and --replay flags can't be used with --targets or --all-targets")
+}
+
+func (s *S) TestGlobalOptionsApplyRecord(c *check.C) {
+	client := &http.Client{}
+	opts := globalOptions{recordFile: "session.har"}
+	err := opts.apply(client, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	retry, ok := client.Transport.(*retryTransport)
+	c.Assert(ok, check.Equals, true)
+	record, ok := retry.transport.(*recordTransport)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(record.path, check.Equals, "session.har")
+	c.Assert(record.transport, check.Equals, http.DefaultTransport)
+}
+
+func (s *S) TestGlobalOptionsApplyReplayMissingFile(c *check.C) {
+	opts := globalOptions{replayFile: "/nonexistent/session.har"}
+	err := opts.apply(&http.Client{}, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestRecordAndReplaySession(c *check.C) {
+	dir, err := ioutil.TempDir("", "har")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.har")
+	api := newFakeAPI()
+	api.routers = []router.PlanRouter{{Name: "galeb", Type: "galeb"}}
+	api.client = &http.Client{Transport: newRecordTransport(http.DefaultTransport, path)}
+	api.mustRun(c, "plan-create", "small", "-c", "2")
+	recorded := api.mustRun(c, "router-list")
+	_, err = api.run("plan-remove", "large")
+	c.Assert(exitCode(err), check.Equals, exitNotFound)
+	api.close()
+	replay, err := newReplayTransport(path)
+	c.Assert(err, check.IsNil)
+	api.client = &http.Client{Transport: replay}
+	c.Assert(api.mustRun(c, "plan-create", "small", "-c", "2"), check.Equals, "Plan successfully created!\n")
+	c.Assert(api.mustRun(c, "router-list"), check.Equals, recorded)
+	_, err = api.run("plan-remove", "large")
+	c.Assert(exitCode(err), check.Equals, exitNotFound)
+	_, err = api.run("router-list")
+	c.Assert(err, check.ErrorMatches, "Failed to connect to tsuru server.*")
+}
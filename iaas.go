@@ -7,22 +7,31 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"sort"
 	"strings"
 
 	"github.com/cezarsa/form"
+	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/yaml.v1"
 )
 
-type machineList struct{}
+type machineList struct {
+	outputCommand
+}
 
 func (c *machineList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "machine-list",
-		Usage: "machine-list",
+		Usage: "machine-list [-o <format>]",
 		Desc: `Lists all machines created using an IaaS provider.
 These machines were created with the [[docker-node-add]] command.`,
 		MinArgs: 0,
@@ -47,6 +56,12 @@ func (c *machineList) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
+	return c.render(context.Stdout, machines, func() error {
+		return renderMachines(context.Stdout, machines)
+	})
+}
+
+func renderMachines(w io.Writer, machines []iaas.Machine) error {
 	table := cmd.NewTable()
 	table.Headers = cmd.Row([]string{"Id", "IaaS", "Address", "Creation Params"})
 	table.LineSeparator = true
@@ -59,7 +74,203 @@ func (c *machineList) Run(context *cmd.Context, client *cmd.Client) error {
 		table.AddRow(cmd.Row([]string{machine.Id, machine.Iaas, machine.Address, strings.Join(params, "\n")}))
 	}
 	table.Sort()
-	context.Stdout.Write(table.Bytes())
+	_, err := w.Write(table.Bytes())
+	return err
+}
+
+type machineInfo struct {
+	outputCommand
+}
+
+type machineNode struct {
+	Address  string
+	Metadata map[string]string
+	Status   string
+}
+
+func (c *machineInfo) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "machine-info",
+		Usage: "machine-info <machine id> [-o <format>]",
+		Desc: `Displays information about a machine created using an IaaS provider.
+
+Besides the machine fields, it shows the template used to create the machine
+(when known), the docker node running on it and the containers currently
+running on that node. Using the [[-o]] flag, the same information is displayed
+in other formats, with the Machine, Template, Node and Containers fields.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *machineInfo) Run(context *cmd.Context, client *cmd.Client) error {
+	machine, err := c.getMachine(client, context.Args[0])
+	if err != nil {
+		return err
+	}
+	var template *iaas.Template
+	if templateName := machine.CreationParams["template"]; templateName != "" {
+		template, err = findTemplate(client, templateName)
+		if err != nil {
+			return err
+		}
+	}
+	node, err := c.getNode(client, machine.Address)
+	if err != nil {
+		return err
+	}
+	var containers []container.Container
+	if node != nil {
+		containers, err = c.getContainers(client, node.Address)
+		if err != nil {
+			return err
+		}
+	}
+	details := machineDetails{Machine: *machine, Template: template, Node: node, Containers: containers}
+	return c.render(context.Stdout, details, func() error {
+		return c.renderDetails(context, &details)
+	})
+}
+
+// machineDetails is the information displayed by machine-info.
+type machineDetails struct {
+	Machine    iaas.Machine
+	Template   *iaas.Template
+	Node       *machineNode
+	Containers []container.Container
+}
+
+func (c *machineInfo) getMachine(client *cmd.Client, id string) (*iaas.Machine, error) {
+	u, err := cmd.GetURL("/iaas/machines")
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var machines []iaas.Machine
+	err = json.NewDecoder(response.Body).Decode(&machines)
+	if err != nil {
+		return nil, err
+	}
+	for i := range machines {
+		if machines[i].Id == id {
+			return &machines[i], nil
+		}
+	}
+	return nil, fmt.Errorf("machine %q not found", id)
+}
+
+func (c *machineInfo) getNode(client *cmd.Client, address string) (*machineNode, error) {
+	u, err := cmd.GetURL("/docker/node")
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var result struct {
+		Nodes []machineNode
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result.Nodes {
+		if net.URLToHost(result.Nodes[i].Address) == address {
+			return &result.Nodes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *machineInfo) getContainers(client *cmd.Client, address string) ([]container.Container, error) {
+	u, err := cmd.GetURL("/docker/node/" + pathSegment(address) + "/containers")
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var containers []container.Container
+	err = json.NewDecoder(response.Body).Decode(&containers)
+	if err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (c *machineInfo) renderDetails(context *cmd.Context, details *machineDetails) error {
+	machine, template, node, containers := &details.Machine, details.Template, details.Node, details.Containers
+	fmt.Fprintf(context.Stdout, "Id: %s\n", machine.Id)
+	fmt.Fprintf(context.Stdout, "IaaS: %s\n", machine.Iaas)
+	fmt.Fprintf(context.Stdout, "Status: %s\n", machine.Status)
+	fmt.Fprintf(context.Stdout, "Address: %s\n", machine.Address)
+	fmt.Fprintf(context.Stdout, "Port: %d\n", machine.Port)
+	if len(machine.CreationParams) > 0 {
+		t := cmd.Table{Headers: cmd.Row([]string{"Name", "Value"})}
+		for k, v := range machine.CreationParams {
+			t.AddRow(cmd.Row([]string{k, v}))
+		}
+		t.Sort()
+		fmt.Fprintf(context.Stdout, "\nCreation Params:\n%s", t.String())
+	}
+	if template != nil {
+		fmt.Fprintf(context.Stdout, "\nTemplate: %s (%s)\n", template.Name, template.IaaSName)
+		t := cmd.Table{Headers: cmd.Row([]string{"Name", "Value"})}
+		for _, data := range template.Data {
+			t.AddRow(cmd.Row([]string{data.Name, data.Value}))
+		}
+		t.Sort()
+		context.Stdout.Write(t.Bytes())
+	}
+	if node == nil {
+		fmt.Fprintln(context.Stdout, "\nNo docker node found for this machine.")
+		return nil
+	}
+	fmt.Fprintf(context.Stdout, "\nNode: %s\n", node.Address)
+	fmt.Fprintf(context.Stdout, "Node Status: %s\n", node.Status)
+	if len(node.Metadata) > 0 {
+		t := cmd.Table{Headers: cmd.Row([]string{"Name", "Value"})}
+		for k, v := range node.Metadata {
+			t.AddRow(cmd.Row([]string{k, v}))
+		}
+		t.Sort()
+		fmt.Fprintf(context.Stdout, "\nNode Metadata:\n%s", t.String())
+	}
+	if len(containers) == 0 {
+		fmt.Fprintln(context.Stdout, "\nNo containers running on this machine.")
+		return nil
+	}
+	t := cmd.Table{Headers: cmd.Row([]string{"Id", "App", "Process", "Type", "Status", "IP", "Host Port"})}
+	for _, cont := range containers {
+		t.AddRow(cmd.Row([]string{cont.ShortID(), cont.AppName, cont.ProcessName, cont.Type, cont.Status, cont.IP, cont.HostPort}))
+	}
+	t.Sort()
+	fmt.Fprintf(context.Stdout, "\nContainers:\n%s", t.String())
 	return nil
 }
 
@@ -91,35 +302,201 @@ func (c *machineDestroy) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type templateList struct{}
+func listTemplates(client *cmd.Client) ([]iaas.Template, error) {
+	url, err := cmd.GetURL("/iaas/templates")
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var templates []iaas.Template
+	err = json.NewDecoder(response.Body).Decode(&templates)
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
 
-func (c *templateList) Info() *cmd.Info {
-	return &cmd.Info{
-		Name:    "machine-template-list",
-		Usage:   "machine-template-list",
-		Desc:    "Lists all machine templates.",
-		MinArgs: 0,
+// findTemplate returns the template with the given name, or nil if there's no
+// such template.
+func findTemplate(client *cmd.Client, name string) (*iaas.Template, error) {
+	templates, err := listTemplates(client)
+	if err != nil {
+		return nil, err
 	}
+	for i := range templates {
+		if templates[i].Name == name {
+			return &templates[i], nil
+		}
+	}
+	return nil, nil
 }
 
-func (c *templateList) Run(context *cmd.Context, client *cmd.Client) error {
-	url, err := cmd.GetURL("/iaas/templates")
+func createTemplate(client *cmd.Client, template *iaas.Template) error {
+	v, err := form.EncodeToValues(template)
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("GET", url, nil)
+	u, err := cmd.GetURL("/iaas/templates")
 	if err != nil {
 		return err
 	}
-	response, err := client.Do(request)
+	request, err := http.NewRequest("POST", u, bytes.NewBufferString(v.Encode()))
 	if err != nil {
 		return err
 	}
-	var templates []iaas.Template
-	err = json.NewDecoder(response.Body).Decode(&templates)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = client.Do(request)
+	return err
+}
+
+func updateTemplate(client *cmd.Client, template *iaas.Template) error {
+	v, err := form.EncodeToValues(template)
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/iaas/templates/%s", template.Name))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", url, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = client.Do(request)
+	return err
+}
+
+func removeTemplate(client *cmd.Client, name string) error {
+	url, err := cmd.GetURL("/iaas/templates/" + name)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	return err
+}
+
+// replaceTemplate removes the template and creates it again, as the tsuru API
+// ignores the IaaS in updates. The previous template is created back when the
+// new one can't be created.
+func replaceTemplate(client *cmd.Client, old, template *iaas.Template) error {
+	if err := removeTemplate(client, old.Name); err != nil {
+		return err
+	}
+	if err := createTemplate(client, template); err != nil {
+		if restoreErr := createTemplate(client, old); restoreErr != nil {
+			return fmt.Errorf("%s\nThe template %q was removed and restoring it failed: %s", strings.TrimSpace(err.Error()), old.Name, strings.TrimSpace(restoreErr.Error()))
+		}
+		return err
+	}
+	return nil
+}
+
+// templateChanges returns the list of parameters that must be sent in an
+// update for turning from into to. Parameters removed in to are sent with an
+// empty value, which makes the API remove them.
+func templateChanges(from, to *iaas.Template) iaas.TemplateDataList {
+	oldParams := templateParams(from)
+	newParams := templateParams(to)
+	var changes iaas.TemplateDataList
+	for name, value := range newParams {
+		if oldValue, ok := oldParams[name]; !ok || oldValue != value {
+			changes = append(changes, iaas.TemplateData{Name: name, Value: value})
+		}
+	}
+	for name := range oldParams {
+		if _, ok := newParams[name]; !ok {
+			changes = append(changes, iaas.TemplateData{Name: name, Value: ""})
+		}
+	}
+	sort.Sort(changes)
+	return changes
+}
+
+func templateParams(template *iaas.Template) map[string]string {
+	params := map[string]string{}
+	if template != nil {
+		for _, data := range template.Data {
+			params[data.Name] = data.Value
+		}
+	}
+	return params
+}
+
+// writeTemplateDiff writes the differences between two versions of a template
+// using "-" for removed parameters, "+" for added parameters and " " for
+// unchanged ones. A nil from template means the template is being created.
+func writeTemplateDiff(w io.Writer, from, to *iaas.Template) {
+	iaasName := to.IaaSName
+	if from != nil && from.IaaSName != to.IaaSName {
+		iaasName = fmt.Sprintf("%s -> %s", from.IaaSName, to.IaaSName)
+	}
+	fmt.Fprintf(w, "Template %q (%s):\n", to.Name, iaasName)
+	oldParams := templateParams(from)
+	newParams := templateParams(to)
+	var names []string
+	for name := range oldParams {
+		names = append(names, name)
+	}
+	for name := range newParams {
+		if _, ok := oldParams[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		oldValue, inOld := oldParams[name]
+		newValue, inNew := newParams[name]
+		switch {
+		case inOld && inNew && oldValue == newValue:
+			fmt.Fprintf(w, "  %s=%s\n", name, newValue)
+		case inOld && inNew:
+			fmt.Fprintf(w, "- %s=%s\n", name, oldValue)
+			fmt.Fprintf(w, "+ %s=%s\n", name, newValue)
+		case inOld:
+			fmt.Fprintf(w, "- %s=%s\n", name, oldValue)
+		default:
+			fmt.Fprintf(w, "+ %s=%s\n", name, newValue)
+		}
+	}
+}
+
+type templateList struct {
+	outputCommand
+}
+
+func (c *templateList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "machine-template-list",
+		Usage:   "machine-template-list [-o <format>]",
+		Desc:    "Lists all machine templates.",
+		MinArgs: 0,
+	}
+}
+
+func (c *templateList) Run(context *cmd.Context, client *cmd.Client) error {
+	templates, err := listTemplates(client)
 	if err != nil {
 		return err
 	}
+	return c.render(context.Stdout, templates, func() error {
+		return renderTemplates(context.Stdout, templates)
+	})
+}
+
+func renderTemplates(w io.Writer, templates []iaas.Template) error {
 	table := cmd.NewTable()
 	table.Headers = cmd.Row([]string{"Name", "IaaS", "Params"})
 	table.LineSeparator = true
@@ -132,25 +509,41 @@ func (c *templateList) Run(context *cmd.Context, client *cmd.Client) error {
 		table.AddRow(cmd.Row([]string{template.Name, template.IaaSName, strings.Join(params, "\n")}))
 	}
 	table.Sort()
-	context.Stdout.Write(table.Bytes())
-	return nil
+	_, err := w.Write(table.Bytes())
+	return err
 }
 
-type templateAdd struct{}
+type templateAdd struct {
+	strict bool
+	fs     *gnuflag.FlagSet
+}
 
 func (c *templateAdd) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "machine-template-add",
-		Usage: "machine-template-add <name> <iaas> <param>=<value>...",
+		Usage: "machine-template-add <name> <iaas> <param>=<value>... [--strict]",
 		Desc: `Creates a new machine template.
 
 Templates can be used with the [[docker-node-add]] command running it with
 the [[template=<template name>]] parameter. Templates can contain a list of
-parameters that will be sent to the IaaS provider.`,
+parameters that will be sent to the IaaS provider.
+
+Parameters are checked against the ones known to be used by the ec2,
+cloudstack and digitalocean providers, and a warning is displayed for unknown
+parameters or invalid values. Using the [[--strict]] flag, the template is not
+created when any problem is found.`,
 		MinArgs: 3,
 	}
 }
 
+func (c *templateAdd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("machine-template-add", gnuflag.ExitOnError)
+		c.fs.BoolVar(&c.strict, "strict", false, "Fail when parameters are unknown to the IaaS or have invalid values")
+	}
+	return c.fs
+}
+
 func (c *templateAdd) Run(context *cmd.Context, client *cmd.Client) error {
 	var template iaas.Template
 	template.Name = context.Args[0]
@@ -164,20 +557,11 @@ func (c *templateAdd) Run(context *cmd.Context, client *cmd.Client) error {
 			})
 		}
 	}
-	v, err := form.EncodeToValues(&template)
-	if err != nil {
-		return err
-	}
-	u, err := cmd.GetURL("/iaas/templates")
-	if err != nil {
-		return err
-	}
-	request, err := http.NewRequest("POST", u, bytes.NewBufferString(v.Encode()))
+	err := checkTemplateParams(context, template.IaaSName, template.Data, c.strict)
 	if err != nil {
 		return err
 	}
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	_, err = client.Do(request)
+	err = createTemplate(client, &template)
 	if err != nil {
 		context.Stderr.Write([]byte("Failed to add template.\n"))
 		return err
@@ -198,15 +582,7 @@ func (c *templateRemove) Info() *cmd.Info {
 }
 
 func (c *templateRemove) Run(context *cmd.Context, client *cmd.Client) error {
-	url, err := cmd.GetURL("/iaas/templates/" + context.Args[0])
-	if err != nil {
-		return err
-	}
-	request, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return err
-	}
-	_, err = client.Do(request)
+	err := removeTemplate(client, context.Args[0])
 	if err != nil {
 		context.Stderr.Write([]byte("Failed to remove template.\n"))
 		return err
@@ -215,46 +591,337 @@ func (c *templateRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type templateUpdate struct{}
+type templateUpdate struct {
+	iaasName string
+	remove   cmd.StringSliceFlag
+	strict   bool
+	fs       *gnuflag.FlagSet
+}
 
 func (c *templateUpdate) Info() *cmd.Info {
 	return &cmd.Info{
-		Name:    "machine-template-update",
-		Usage:   "machine-template-update <name> <param>=<value>...",
-		Desc:    "Update an existing machine template.",
-		MinArgs: 2,
+		Name:  "machine-template-update",
+		Usage: "machine-template-update <name> [<param>=<value>...] [<param>-...] [--remove <param>]... [--iaas <name>] [--strict]",
+		Desc: `Update an existing machine template.
+
+Parameters given as [[<param>=<value>]] are added to the template or have their
+values replaced. Parameters can be removed from the template either using
+[[<param>-]] or the [[--remove]] flag. The IaaS of the template can be changed
+with the [[--iaas]] flag, which removes the template and creates it again, as
+the tsuru API doesn't update the IaaS of existing templates.
+
+Parameters are checked against the ones known to be used by the IaaS provider,
+like in [[machine-template-add]]. Using the [[--strict]] flag, the template is
+not updated when any problem is found.
+
+After updating, the template is fetched again and the differences between the
+previous and the current version are displayed.`,
+		MinArgs: 1,
 	}
 }
 
+func (c *templateUpdate) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("machine-template-update", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.iaasName, "iaas", "", "New IaaS of the template")
+		remove := "Remove a parameter from the template, may be used multiple times"
+		c.fs.Var(&c.remove, "remove", remove)
+		c.fs.Var(&c.remove, "r", remove)
+		c.fs.BoolVar(&c.strict, "strict", false, "Fail when parameters are unknown to the IaaS or have invalid values")
+	}
+	return c.fs
+}
+
 func (c *templateUpdate) Run(context *cmd.Context, client *cmd.Client) error {
-	template := iaas.Template{Name: context.Args[0]}
+	template := iaas.Template{Name: context.Args[0], IaaSName: c.iaasName}
+	removed := append([]string{}, c.remove...)
 	for _, param := range context.Args[1:] {
-		if strings.Contains(param, "=") {
-			keyValue := strings.SplitN(param, "=", 2)
-			template.Data = append(template.Data, iaas.TemplateData{
-				Name:  keyValue[0],
-				Value: keyValue[1],
-			})
+		if !strings.Contains(param, "=") && strings.HasSuffix(param, "-") && len(param) > 1 {
+			removed = append(removed, strings.TrimSuffix(param, "-"))
+			continue
+		}
+		data, err := parseTemplateParam(param)
+		if err != nil {
+			return err
 		}
+		template.Data = append(template.Data, data)
+	}
+	for _, name := range removed {
+		template.Data = append(template.Data, iaas.TemplateData{Name: name, Value: ""})
 	}
-	v, err := form.EncodeToValues(&template)
+	if len(template.Data) == 0 && template.IaaSName == "" {
+		return errors.New("nothing to update, please specify parameters to change or remove")
+	}
+	current, err := findTemplate(client, template.Name)
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/iaas/templates/%s", template.Name))
+	if current == nil {
+		return fmt.Errorf("template %q not found", template.Name)
+	}
+	iaasName := current.IaaSName
+	if template.IaaSName != "" {
+		iaasName = template.IaaSName
+	}
+	err = checkTemplateParams(context, iaasName, template.Data, c.strict)
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("PUT", url, strings.NewReader(v.Encode()))
+	if iaasName != current.IaaSName {
+		params := templateParams(current)
+		for _, data := range template.Data {
+			if data.Value == "" {
+				delete(params, data.Name)
+			} else {
+				params[data.Name] = data.Value
+			}
+		}
+		replacement := iaas.Template{Name: current.Name, IaaSName: iaasName}
+		for _, name := range sortedKeys(params) {
+			replacement.Data = append(replacement.Data, iaas.TemplateData{Name: name, Value: params[name]})
+		}
+		err = replaceTemplate(client, current, &replacement)
+	} else {
+		template.IaaSName = ""
+		err = updateTemplate(client, &template)
+	}
 	if err != nil {
+		context.Stderr.Write([]byte("Failed to update template.\n"))
 		return err
 	}
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	_, err = client.Do(request)
+	updated, err := findTemplate(client, template.Name)
 	if err != nil {
-		context.Stderr.Write([]byte("Failed to update template.\n"))
 		return err
 	}
+	if updated == nil {
+		return fmt.Errorf("template %q not found after updating it", template.Name)
+	}
+	writeTemplateDiff(context.Stdout, current, updated)
 	context.Stdout.Write([]byte("Template successfully updated.\n"))
 	return nil
 }
+
+type templateExport struct{}
+
+func (c *templateExport) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "machine-template-export",
+		Usage: "machine-template-export [name...]",
+		Desc: `Exports machine templates in YAML format.
+
+If no template name is given, all templates are exported. The output can be
+loaded in another tsuru installation using the [[machine-template-import]]
+command, for example:
+
+[[tsuru-admin machine-template-export > templates.yaml]]`,
+		MinArgs: 0,
+	}
+}
+
+func (c *templateExport) Run(context *cmd.Context, client *cmd.Client) error {
+	templates, err := listTemplates(client)
+	if err != nil {
+		return err
+	}
+	if len(context.Args) > 0 {
+		byName := make(map[string]iaas.Template, len(templates))
+		for _, template := range templates {
+			byName[template.Name] = template
+		}
+		templates = make([]iaas.Template, 0, len(context.Args))
+		for _, name := range context.Args {
+			template, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("template %q not found", name)
+			}
+			templates = append(templates, template)
+		}
+	}
+	for i := range templates {
+		sort.Sort(templates[i].Data)
+	}
+	data, err := yaml.Marshal(templates)
+	if err != nil {
+		return err
+	}
+	context.Stdout.Write(data)
+	return nil
+}
+
+type templateImport struct {
+	file   string
+	dryRun bool
+	fs     *gnuflag.FlagSet
+}
+
+func (c *templateImport) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "machine-template-import",
+		Usage: "machine-template-import -f <file> [--dry-run]",
+		Desc: `Imports machine templates from a YAML file.
+
+The file must be in the format generated by the [[machine-template-export]]
+command. Templates that don't exist are created and existing templates are
+updated to match the file. Templates whose IaaS changed are removed and created
+again, as the IaaS of a template can't be updated. The differences between the current templates and
+the ones in the file are displayed before applying them. Templates not present
+in the file are left untouched.
+
+Using the [[--dry-run]] flag, the differences are displayed but no template is
+changed.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *templateImport) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("machine-template-import", gnuflag.ExitOnError)
+		file := "Path to the YAML file containing the templates"
+		c.fs.StringVar(&c.file, "file", "", file)
+		c.fs.StringVar(&c.file, "f", "", file)
+		c.fs.BoolVar(&c.dryRun, "dry-run", false, "Display the changes without applying them")
+	}
+	return c.fs
+}
+
+func (c *templateImport) Run(context *cmd.Context, client *cmd.Client) error {
+	if c.file == "" {
+		return errors.New("the path to the templates file is required (-f/--file)")
+	}
+	data, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		return err
+	}
+	var templates []iaas.Template
+	err = yaml.Unmarshal(data, &templates)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s: %s", c.file, err)
+	}
+	for _, template := range templates {
+		if template.Name == "" || template.IaaSName == "" {
+			return fmt.Errorf("invalid template in %s: name and iaasname are required", c.file)
+		}
+	}
+	current, err := listTemplates(client)
+	if err != nil {
+		return err
+	}
+	currentByName := make(map[string]*iaas.Template, len(current))
+	for i := range current {
+		currentByName[current[i].Name] = &current[i]
+	}
+	var changed int
+	for i := range templates {
+		template := &templates[i]
+		old := currentByName[template.Name]
+		if old != nil && old.IaaSName == template.IaaSName && len(templateChanges(old, template)) == 0 {
+			continue
+		}
+		changed++
+		writeTemplateDiff(context.Stdout, old, template)
+		if c.dryRun {
+			continue
+		}
+		switch {
+		case old == nil:
+			err = createTemplate(client, template)
+		case old.IaaSName != template.IaaSName:
+			err = replaceTemplate(client, old, template)
+		default:
+			err = updateTemplate(client, &iaas.Template{
+				Name: template.Name,
+				Data: templateChanges(old, template),
+			})
+		}
+		if err != nil {
+			fmt.Fprintf(context.Stderr, "Failed to import template %q.\n", template.Name)
+			return err
+		}
+	}
+	switch {
+	case changed == 0:
+		fmt.Fprintln(context.Stdout, "Templates already up to date.")
+	case c.dryRun:
+		fmt.Fprintf(context.Stdout, "\nDry run: %d template(s) would be changed.\n", changed)
+	default:
+		fmt.Fprintf(context.Stdout, "\n%d template(s) successfully imported.\n", changed)
+	}
+	return nil
+}
+
+type templateCopy struct {
+	iaasName string
+	fs       *gnuflag.FlagSet
+}
+
+func (c *templateCopy) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "machine-template-copy",
+		Usage: "machine-template-copy <source name> <destination name> [param=value...] [--iaas <name>]",
+		Desc: `Creates a new machine template based on an existing one.
+
+All the parameters of the source template are copied to the new template.
+Parameters given in the command line override the copied ones, and parameters
+with an empty value (e.g. [[subnet=]]) are not copied. The IaaS of the new
+template can be changed with the [[--iaas]] flag.`,
+		MinArgs: 2,
+	}
+}
+
+func (c *templateCopy) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("machine-template-copy", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.iaasName, "iaas", "", "IaaS of the new template, defaults to the IaaS of the source template")
+	}
+	return c.fs
+}
+
+func (c *templateCopy) Run(context *cmd.Context, client *cmd.Client) error {
+	srcName, dstName := context.Args[0], context.Args[1]
+	overrides := map[string]string{}
+	for _, param := range context.Args[2:] {
+		data, err := parseTemplateParam(param)
+		if err != nil {
+			return err
+		}
+		overrides[data.Name] = data.Value
+	}
+	src, err := findTemplate(client, srcName)
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		return fmt.Errorf("template %q not found", srcName)
+	}
+	params := templateParams(src)
+	for name, value := range overrides {
+		if value == "" {
+			delete(params, name)
+		} else {
+			params[name] = value
+		}
+	}
+	template := iaas.Template{Name: dstName, IaaSName: src.IaaSName}
+	if c.iaasName != "" {
+		template.IaaSName = c.iaasName
+	}
+	for name, value := range params {
+		template.Data = append(template.Data, iaas.TemplateData{Name: name, Value: value})
+	}
+	sort.Sort(template.Data)
+	err = createTemplate(client, &template)
+	if err != nil {
+		context.Stderr.Write([]byte("Failed to copy template.\n"))
+		return err
+	}
+	context.Stdout.Write([]byte("Template successfully copied.\n"))
+	return nil
+}
+
+func parseTemplateParam(param string) (iaas.TemplateData, error) {
+	keyValue := strings.SplitN(param, "=", 2)
+	if len(keyValue) != 2 || keyValue[0] == "" {
+		return iaas.TemplateData{}, fmt.Errorf("invalid parameter %q, expected <param>=<value>", param)
+	}
+	return iaas.TemplateData{Name: keyValue[0], Value: keyValue[1]}, nil
+}
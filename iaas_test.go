@@ -7,13 +7,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 
 	"github.com/cezarsa/form"
+	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/cmd/cmdtest"
 	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/provision/docker/container"
 	"gopkg.in/check.v1"
 )
 
@@ -54,6 +58,264 @@ func (s *S) TestMachineListRun(c *check.C) {
 	c.Assert(stdout.String(), check.Equals, expected)
 }
 
+func (s *S) TestMachineListRunYAML(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	m1 := iaas.Machine{Id: "id1", Address: "addr1", Iaas: "iaas1", Port: 2375, CreationParams: map[string]string{
+		"param1": "value1",
+	}}
+	data, err := json.Marshal([]iaas.Machine{m1})
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.Transport{Message: string(data), Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := machineList{}
+	command.Flags().Parse(true, []string{"-o", "yaml"})
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `- Address: addr1
+  CreationParams:
+    param1: value1
+  Iaas: iaas1
+  Id: id1
+  Port: 2375
+  Status: ""
+`)
+}
+
+// nodeContainersCond matches the requests listing the containers of the node
+// the way the tsuru API routes them: paths with empty segments are redirected
+// by path cleaning, and the address is the variable of the route.
+func nodeContainersCond(address string) func(*http.Request) bool {
+	route := mux.NewRouter().Path("/{version:[0-9.]+}/docker/node/{address:.*}/containers").Methods("GET")
+	return func(req *http.Request) bool {
+		if strings.Contains(req.URL.EscapedPath(), "//") {
+			return false
+		}
+		var match mux.RouteMatch
+		return route.Match(req, &match) && match.Vars["address"] == address
+	}
+}
+
+func (s *S) TestMachineInfoRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"id1"},
+	}
+	m1 := iaas.Machine{Id: "id1", Address: "10.0.0.1", Iaas: "ec2", Status: "running", Port: 2375, CreationParams: map[string]string{
+		"template": "tpl1",
+		"pool":     "pool1",
+	}}
+	m2 := iaas.Machine{Id: "id2", Address: "10.0.0.2", Iaas: "ec2"}
+	machines, err := json.Marshal([]iaas.Machine{m1, m2})
+	c.Assert(err, check.IsNil)
+	tpl1 := iaas.Template{Name: "tpl1", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.small"},
+	}}
+	templates, err := json.Marshal([]iaas.Template{tpl1})
+	c.Assert(err, check.IsNil)
+	nodes, err := json.Marshal(map[string]interface{}{
+		"machines": []iaas.Machine{m1, m2},
+		"nodes": []machineNode{
+			{Address: "http://10.0.0.1:2375", Status: "ready", Metadata: map[string]string{"pool": "pool1"}},
+			{Address: "http://10.0.0.2:2375", Status: "ready"},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	containers, err := json.Marshal([]container.Container{
+		{ID: "0123456789abcdef", AppName: "myapp", ProcessName: "web", Type: "python", Status: "started", IP: "172.17.0.2", HostPort: "32768"},
+	})
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(machines), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/iaas/machines") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: string(templates), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: string(nodes), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/docker/node") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: string(containers), Status: http.StatusOK},
+				CondFunc:  nodeContainersCond("http://10.0.0.1:2375"),
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := machineInfo{}
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `Id: id1
+IaaS: ec2
+Status: running
+Address: 10.0.0.1
+Port: 2375
+
+Creation Params:
++----------+-------+
+| Name     | Value |
++----------+-------+
+| pool     | pool1 |
+| template | tpl1  |
++----------+-------+
+
+Template: tpl1 (ec2)
++------+----------+
+| Name | Value    |
++------+----------+
+| type | m1.small |
++------+----------+
+
+Node: http://10.0.0.1:2375
+Node Status: ready
+
+Node Metadata:
++------+-------+
+| Name | Value |
++------+-------+
+| pool | pool1 |
++------+-------+
+
+Containers:
++------------+-------+---------+--------+---------+------------+-----------+
+| Id         | App   | Process | Type   | Status  | IP         | Host Port |
++------------+-------+---------+--------+---------+------------+-----------+
+| 0123456789 | myapp | web     | python | started | 172.17.0.2 | 32768     |
++------------+-------+---------+--------+---------+------------+-----------+
+`
+	c.Assert(stdout.String(), check.Equals, expected)
+}
+
+func (s *S) TestMachineInfoRunWithoutNode(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"id1"},
+	}
+	m1 := iaas.Machine{Id: "id1", Address: "10.0.0.1", Iaas: "ec2", Status: "running"}
+	machines, err := json.Marshal([]iaas.Machine{m1})
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(machines), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/iaas/machines") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusNoContent},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/docker/node") && req.Method == "GET"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := machineInfo{}
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `Id: id1
+IaaS: ec2
+Status: running
+Address: 10.0.0.1
+Port: 0
+
+No docker node found for this machine.
+`
+	c.Assert(stdout.String(), check.Equals, expected)
+}
+
+func (s *S) TestMachineInfoRunOutputFormat(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"id1"},
+	}
+	m1 := iaas.Machine{Id: "id1", Address: "10.0.0.1", Iaas: "ec2", Status: "running"}
+	machines, err := json.Marshal([]iaas.Machine{m1})
+	c.Assert(err, check.IsNil)
+	nodes, err := json.Marshal(map[string]interface{}{
+		"nodes": []machineNode{{Address: "http://10.0.0.1:2375", Status: "ready"}},
+	})
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(machines), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/iaas/machines") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: string(nodes), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/docker/node") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+				CondFunc:  nodeContainersCond("http://10.0.0.1:2375"),
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := machineInfo{}
+	command.Flags().Parse(true, []string{"-o", "json"})
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	var details struct {
+		Machine    map[string]interface{}
+		Template   *iaas.Template
+		Node       machineNode
+		Containers []interface{}
+	}
+	err = json.Unmarshal(stdout.Bytes(), &details)
+	c.Assert(err, check.IsNil)
+	c.Assert(details.Machine["Id"], check.Equals, "id1")
+	c.Assert(details.Machine["Address"], check.Equals, "10.0.0.1")
+	c.Assert(details.Template, check.IsNil)
+	c.Assert(details.Node.Address, check.Equals, "http://10.0.0.1:2375")
+	c.Assert(details.Node.Status, check.Equals, "ready")
+	c.Assert(details.Containers, check.HasLen, 0)
+}
+
+func (s *S) TestMachineInfoRunNotFound(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Args:   []string{"id9"},
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/iaas/machines") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := machineInfo{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `machine "id9" not found`)
+}
+
 func (s *S) TestMachineDestroyRun(c *check.C) {
 	var stdout, stderr bytes.Buffer
 	context := cmd.Context{
@@ -114,8 +376,8 @@ func (s *S) TestTemplateListRun(c *check.C) {
 }
 
 func (s *S) TestTemplateAddCmdRun(c *check.C) {
-	var buf bytes.Buffer
-	context := cmd.Context{Args: []string{"my-tpl", "ec2", "zone=xyz", "image=ami-something"}, Stdout: &buf}
+	var buf, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"my-tpl", "ec2", "zone=xyz", "image=ami-something"}, Stdout: &buf, Stderr: &stderr}
 	expectedBody := iaas.Template{
 		Name:     "my-tpl",
 		IaaSName: "ec2",
@@ -146,6 +408,19 @@ func (s *S) TestTemplateAddCmdRun(c *check.C) {
 	err := cmd.Run(&context, client)
 	c.Assert(err, check.IsNil)
 	c.Assert(buf.String(), check.Equals, "Template successfully added.\n")
+	c.Assert(stderr.String(), check.Equals, "WARNING: unknown parameter \"zone\" for IaaS \"ec2\"\n")
+}
+
+func (s *S) TestTemplateAddCmdRunStrict(c *check.C) {
+	var buf, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"my-tpl", "ec2", "instancetyp=m1.small", "mincount=2"}, Stdout: &buf, Stderr: &stderr}
+	command := templateAdd{}
+	command.Flags().Parse(true, []string{"--strict"})
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, `invalid template parameters:
+  unknown parameter "instancetyp" for IaaS "ec2", did you mean "instancetype"\?
+  unknown parameter "mincount" for IaaS "ec2"`)
+	c.Assert(buf.String(), check.Equals, "")
 }
 
 func (s *S) TestTemplateRemoveCmdRun(c *check.C) {
@@ -166,36 +441,451 @@ func (s *S) TestTemplateRemoveCmdRun(c *check.C) {
 }
 
 func (s *S) TestTemplateUpdateCmdRun(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.templates["my-tpl"] = iaas.Template{Name: "my-tpl", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.small"},
+		{Name: "zone", Value: "xyz"},
+	}}
+	stdout := api.mustRun(c, "machine-template-update", "my-tpl", "zone=", "image=ami-something")
+	c.Assert(stdout, check.Equals, `Template "my-tpl" (ec2):
++ image=ami-something
+  type=m1.small
+- zone=xyz
+Template successfully updated.
+`)
+	c.Assert(api.changeRequests(), check.DeepEquals, []string{"PUT /iaas/templates/my-tpl"})
+	c.Assert(api.templates["my-tpl"], check.DeepEquals, iaas.Template{Name: "my-tpl", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "image", Value: "ami-something"},
+		{Name: "type", Value: "m1.small"},
+	}})
+}
+
+func (s *S) TestTemplateUpdateCmdRunRemoveAndChangeIaaS(c *check.C) {
+	api := newFakeAPI()
+	defer api.close()
+	api.templates["my-tpl"] = iaas.Template{Name: "my-tpl", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "subnet", Value: "subnet-1"},
+		{Name: "type", Value: "m1.small"},
+		{Name: "zone", Value: "xyz"},
+	}}
+	stdout := api.mustRun(c, "machine-template-update", "my-tpl", "zone-", "type=m1.large", "--remove", "subnet", "--iaas", "ec2-east")
+	c.Assert(stdout, check.Equals, `Template "my-tpl" (ec2 -> ec2-east):
+- subnet=subnet-1
+- type=m1.small
++ type=m1.large
+- zone=xyz
+Template successfully updated.
+`)
+	c.Assert(api.changeRequests(), check.DeepEquals, []string{
+		"DELETE /iaas/templates/my-tpl",
+		"POST /iaas/templates",
+	})
+	c.Assert(api.templates["my-tpl"], check.DeepEquals, iaas.Template{Name: "my-tpl", IaaSName: "ec2-east", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.large"},
+	}})
+}
+
+func (s *S) TestTemplateUpdateCmdRunChangeIaaSRestoresTemplate(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"my-tpl", "type=m1.large"}, Stdout: &stdout, Stderr: &stderr}
+	original := iaas.Template{Name: "my-tpl", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.small"},
+	}}
+	current, err := json.Marshal([]iaas.Template{original})
+	c.Assert(err, check.IsNil)
+	var created []iaas.Template
+	decodeCreated := func(req *http.Request) bool {
+		var template iaas.Template
+		dec := form.NewDecoder(nil)
+		dec.IgnoreUnknownKeys(true)
+		c.Assert(req.ParseForm(), check.IsNil)
+		c.Assert(dec.DecodeValues(&template, req.Form), check.IsNil)
+		created = append(created, template)
+		return req.Method == "POST"
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(current), Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.Method == "GET" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/iaas/templates/my-tpl") && req.Method == "DELETE"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "iaas not found", Status: http.StatusBadRequest},
+				CondFunc:  decodeCreated,
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusCreated},
+				CondFunc:  decodeCreated,
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateUpdate{}
+	command.Flags().Parse(true, []string{"--iaas", "unknown"})
+	err = command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, "iaas not found")
+	c.Assert(stderr.String(), check.Equals, "Failed to update template.\n")
+	c.Assert(created, check.DeepEquals, []iaas.Template{
+		{Name: "my-tpl", IaaSName: "unknown", Data: iaas.TemplateDataList{{Name: "type", Value: "m1.large"}}},
+		original,
+	})
+}
+
+func (s *S) TestTemplateUpdateCmdRunChangeIaaSRestoreFails(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"my-tpl", "type=m1.large"}, Stdout: &stdout, Stderr: &stderr}
+	current, err := json.Marshal([]iaas.Template{{Name: "my-tpl", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.small"},
+	}}})
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(current), Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.Method == "GET" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.Method == "DELETE" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "iaas not found", Status: http.StatusBadRequest},
+				CondFunc:  func(req *http.Request) bool { return req.Method == "POST" },
+			},
+			{
+				Transport: cmdtest.Transport{Message: "database is down", Status: http.StatusInternalServerError},
+				CondFunc:  func(req *http.Request) bool { return req.Method == "POST" },
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateUpdate{}
+	command.Flags().Parse(true, []string{"--iaas", "unknown"})
+	err = command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `iaas not found\nThe template "my-tpl" was removed and restoring it failed: database is down`)
+	c.Assert(trans.ConditionalTransports, check.HasLen, 0)
+}
+
+func (s *S) TestTemplateUpdateCmdRunInvalidParam(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"my-tpl", "zone"}, Stdout: &buf}
+	command := templateUpdate{}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, `invalid parameter "zone", expected <param>=<value>`)
+}
+
+func (s *S) TestTemplateUpdateCmdRunNothingToUpdate(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"my-tpl"}, Stdout: &buf}
+	command := templateUpdate{}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, "nothing to update, .*")
+}
+
+func (s *S) TestTemplateUpdateCmdRunNotFound(c *check.C) {
 	var buf bytes.Buffer
-	context := cmd.Context{Args: []string{"my-tpl", "zone=", "image=ami-something"}, Stdout: &buf}
+	context := cmd.Context{Args: []string{"my-tpl", "zone=a"}, Stdout: &buf}
 	trans := &cmdtest.ConditionalTransport{
-		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
 		CondFunc: func(req *http.Request) bool {
-			var template iaas.Template
-			dec := form.NewDecoder(nil)
-			dec.IgnoreUnknownKeys(true)
-			err := req.ParseForm()
-			c.Assert(err, check.IsNil)
-			err = dec.DecodeValues(&template, req.Form)
-			c.Assert(err, check.IsNil)
-			expected := iaas.Template{
-				Name: "my-tpl",
-				Data: iaas.TemplateDataList{
-					{Name: "zone", Value: ""},
-					{Name: "image", Value: "ami-something"},
+			return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateUpdate{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `template "my-tpl" not found`)
+}
+
+func (s *S) TestTemplateExportRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"tpl1"}, Stdout: &stdout, Stderr: &stderr}
+	tpl1 := iaas.Template{Name: "tpl1", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.small"},
+		{Name: "region", Value: "us-east-1"},
+	}}
+	tpl2 := iaas.Template{Name: "tpl2", IaaSName: "cloudstack"}
+	data, err := json.Marshal([]iaas.Template{tpl1, tpl2})
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(data), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateExport{}
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `- name: tpl1
+  iaasname: ec2
+  data:
+  - name: region
+    value: us-east-1
+  - name: type
+    value: m1.small
+`)
+}
+
+func (s *S) TestTemplateExportRunNotFound(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"tpl9"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateExport{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `template "tpl9" not found`)
+}
+
+func (s *S) writeTemplatesFile(c *check.C, content string) string {
+	f, err := ioutil.TempFile("", "templates")
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	_, err = f.WriteString(content)
+	c.Assert(err, check.IsNil)
+	return f.Name()
+}
+
+const templatesFile = `- name: tpl1
+  iaasname: ec2
+  data:
+  - name: region
+    value: us-east-1
+  - name: type
+    value: m1.large
+- name: tpl2
+  iaasname: ec2
+  data:
+  - name: type
+    value: m1.small
+- name: tpl3
+  iaasname: ec2
+`
+
+func (s *S) TestTemplateImportRun(c *check.C) {
+	path := s.writeTemplatesFile(c, templatesFile)
+	defer os.Remove(path)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	current, err := json.Marshal([]iaas.Template{
+		{Name: "tpl1", IaaSName: "ec2", Data: iaas.TemplateDataList{
+			{Name: "type", Value: "m1.small"},
+			{Name: "zone", Value: "a"},
+			{Name: "region", Value: "us-east-1"},
+		}},
+		{Name: "tpl3", IaaSName: "ec2"},
+	})
+	c.Assert(err, check.IsNil)
+	decodeTemplate := func(req *http.Request) iaas.Template {
+		var template iaas.Template
+		dec := form.NewDecoder(nil)
+		dec.IgnoreUnknownKeys(true)
+		err := req.ParseForm()
+		c.Assert(err, check.IsNil)
+		err = dec.DecodeValues(&template, req.Form)
+		c.Assert(err, check.IsNil)
+		return template
+	}
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(current), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					c.Assert(decodeTemplate(req), check.DeepEquals, iaas.Template{
+						Name: "tpl1",
+						Data: iaas.TemplateDataList{
+							{Name: "type", Value: "m1.large"},
+							{Name: "zone", Value: ""},
+						},
+					})
+					return strings.HasSuffix(req.URL.Path, "/iaas/templates/tpl1") && req.Method == "PUT"
 				},
-			}
-			c.Assert(template, check.DeepEquals, expected)
-			path := strings.HasSuffix(req.URL.Path, "/iaas/templates/my-tpl")
-			method := req.Method == "PUT"
-			contentType := req.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
-			return path && method && contentType
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					c.Assert(decodeTemplate(req), check.DeepEquals, iaas.Template{
+						Name:     "tpl2",
+						IaaSName: "ec2",
+						Data: iaas.TemplateDataList{
+							{Name: "type", Value: "m1.small"},
+						},
+					})
+					return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "POST"
+				},
+			},
 		},
 	}
-	manager := cmd.Manager{}
-	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
-	cmd := templateUpdate{}
-	err := cmd.Run(&context, client)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateImport{}
+	command.Flags().Parse(true, []string{"-f", path})
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `Template "tpl1" (ec2):
+  region=us-east-1
+- type=m1.small
++ type=m1.large
+- zone=a
+Template "tpl2" (ec2):
++ type=m1.small
+
+2 template(s) successfully imported.
+`)
+}
+
+func (s *S) TestTemplateImportRunChangeIaaS(c *check.C) {
+	path := s.writeTemplatesFile(c, "- name: tpl1\n  iaasname: ec2-east\n  data:\n  - name: type\n    value: m1.large\n")
+	defer os.Remove(path)
+	api := newFakeAPI()
+	defer api.close()
+	api.templates["tpl1"] = iaas.Template{Name: "tpl1", IaaSName: "ec2", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.large"},
+	}}
+	stdout := api.mustRun(c, "machine-template-import", "-f", path)
+	c.Assert(stdout, check.Equals, `Template "tpl1" (ec2 -> ec2-east):
+  type=m1.large
+
+1 template(s) successfully imported.
+`)
+	c.Assert(api.changeRequests(), check.DeepEquals, []string{
+		"DELETE /iaas/templates/tpl1",
+		"POST /iaas/templates",
+	})
+	c.Assert(api.templates["tpl1"], check.DeepEquals, iaas.Template{Name: "tpl1", IaaSName: "ec2-east", Data: iaas.TemplateDataList{
+		{Name: "type", Value: "m1.large"},
+	}})
+}
+
+func (s *S) TestTemplateImportRunDryRun(c *check.C) {
+	path := s.writeTemplatesFile(c, templatesFile)
+	defer os.Remove(path)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateImport{}
+	command.Flags().Parse(true, []string{"-f", path, "--dry-run"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, `Template "tpl1" (ec2):
++ region=us-east-1
++ type=m1.large
+Template "tpl2" (ec2):
++ type=m1.small
+Template "tpl3" (ec2):
+
+Dry run: 3 template(s) would be changed.
+`)
+}
+
+func (s *S) TestTemplateImportRunInvalidFile(c *check.C) {
+	path := s.writeTemplatesFile(c, "- name: tpl1\n")
+	defer os.Remove(path)
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	command := templateImport{}
+	command.Flags().Parse(true, []string{"-f", path})
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, "invalid template in .*: name and iaasname are required")
+}
+
+func (s *S) TestTemplateCopyRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"base", "large", "type=m1.large", "subnet=", "zone=b"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	current, err := json.Marshal([]iaas.Template{
+		{Name: "base", IaaSName: "ec2", Data: iaas.TemplateDataList{
+			{Name: "type", Value: "m1.small"},
+			{Name: "subnet", Value: "subnet-1"},
+			{Name: "region", Value: "us-east-1"},
+		}},
+	})
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: string(current), Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					var template iaas.Template
+					dec := form.NewDecoder(nil)
+					dec.IgnoreUnknownKeys(true)
+					err := req.ParseForm()
+					c.Assert(err, check.IsNil)
+					err = dec.DecodeValues(&template, req.Form)
+					c.Assert(err, check.IsNil)
+					c.Assert(template, check.DeepEquals, iaas.Template{
+						Name:     "large",
+						IaaSName: "ec2-other",
+						Data: iaas.TemplateDataList{
+							{Name: "region", Value: "us-east-1"},
+							{Name: "type", Value: "m1.large"},
+							{Name: "zone", Value: "b"},
+						},
+					})
+					return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "POST"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateCopy{}
+	command.Flags().Parse(true, []string{"--iaas", "ec2-other"})
+	err = command.Run(&context, client)
 	c.Assert(err, check.IsNil)
-	c.Assert(buf.String(), check.Equals, "Template successfully updated.\n")
+	c.Assert(stdout.String(), check.Equals, "Template successfully copied.\n")
+}
+
+func (s *S) TestTemplateCopyRunSourceNotFound(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"base", "large"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/iaas/templates") && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := templateCopy{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, `template "base" not found`)
+}
+
+func (s *S) TestTemplateCopyRunInvalidParam(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"base", "large", "type"}, Stdout: &stdout, Stderr: &stderr}
+	command := templateCopy{}
+	err := command.Run(&context, nil)
+	c.Assert(err, check.ErrorMatches, `invalid parameter "type", expected <param>=<value>`)
 }
@@ -5,11 +5,13 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/tsuru/tsuru-client/tsuru/platform"
 	"github.com/tsuru/tsuru-client/tsuru/pool"
 	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/net"
 	"github.com/tsuru/tsuru/provision"
 	_ "github.com/tsuru/tsuru/provision/docker"
 )
@@ -20,14 +22,23 @@ const (
 )
 
 func buildManager(name string) *cmd.Manager {
-	m := cmd.BuildBaseManager(name, version, header, nil)
+	var m *cmd.Manager
+	m = cmd.BuildBaseManager(name, version, header, func(context *cmd.Context) error {
+		err := runHiddenCommand(m, hiddenCommands(m, name), context)
+		if err != cmd.ErrLookup {
+			return err
+		}
+		return runPlugin(m, name, context)
+	})
 	m.RegisterRemoved("log-remove", "This action is no longer supported.")
 	m.Register(&platform.PlatformAdd{})
 	m.Register(&platformUpdate{})
 	m.Register(&platformRemove{})
 	m.Register(&machineList{})
+	m.Register(&machineInfo{})
 	m.Register(&machineDestroy{})
 	m.Register(&appLockDelete{})
+	m.Register(&appLockList{})
 	m.RegisterDeprecated(&userQuotaView{}, "view-user-quota")
 	m.RegisterDeprecated(&userChangeQuota{}, "change-user-quota")
 	m.RegisterDeprecated(&appQuotaView{}, "view-app-quota")
@@ -48,10 +59,41 @@ func buildManager(name string) *cmd.Manager {
 	m.Register(&cmd.ShellToContainerCmd{})
 	m.Register(&appRoutesRebuild{})
 	m.Register(&templateUpdate{})
+	m.Register(&templateExport{})
+	m.Register(&templateImport{})
+	m.Register(&templateCopy{})
+	m.Register(&batch{manager: m})
+	m.Register(&shell{manager: m})
+	m.Register(&completion{manager: m, program: name})
+	m.Register(&auditLogCommand{})
+	m.Register(&aliasCommand{manager: m})
+	m.Register(&clusterExport{})
+	m.Register(&clusterApply{})
 	registerProvisionersCommands(m)
 	return m
 }
 
+// hiddenCommands returns the commands that are looked up before the
+// registered ones, so they don't show up in the help.
+func hiddenCommands(m *cmd.Manager, name string) map[string]cmd.Command {
+	return map[string]cmd.Command{
+		completeCommand: &complete{manager: m},
+		"docs-gen":      &docsGen{manager: m, program: name},
+	}
+}
+
+func runHiddenCommand(m *cmd.Manager, hidden map[string]cmd.Command, context *cmd.Context) error {
+	if len(context.Args) == 0 {
+		return cmd.ErrLookup
+	}
+	command, ok := hidden[context.Args[0]]
+	if !ok {
+		return cmd.ErrLookup
+	}
+	runner := commandRunner{manager: m, client: cmd.NewClient(net.Dial5FullUnlimitedClient, context, m)}
+	return runner.runCommand(context, context.Args[0], command, context.Args[1:])
+}
+
 func registerProvisionersCommands(m *cmd.Manager) {
 	provisioners := provision.Registry()
 	for _, p := range provisioners {
@@ -67,6 +109,36 @@ func registerProvisionersCommands(m *cmd.Manager) {
 func main() {
 	name := cmd.ExtractProgramName(os.Args[0])
 	manager := buildManager(name)
-	args := os.Args[1:]
-	manager.Run(args)
+	opts, args, err := parseGlobalOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if opts.fanOut() {
+		labels := opts.targets
+		if opts.allTargets {
+			labels = nil
+		}
+		targets, err := selectTargets(labels)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(runOnTargets(targets, opts.forwardArgs, os.Stdout, os.Stderr, runExecutable))
+	}
+	if err := opts.apply(net.Dial5FullUnlimitedClient, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	config, err := loadConfig(configPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	args = config.expand(args, manager.Commands)
+	auditCommand(net.Dial5FullUnlimitedClient, manager.Commands, args)
+	reporter := newErrorReporter(opts.errorFormat, os.Stderr)
+	reporter.track(net.Dial5FullUnlimitedClient)
+	reporter.wrap(manager, args)
+	os.Exit(reporter.run(manager, args))
 }
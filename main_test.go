@@ -66,3 +66,59 @@ func (s *S) TestAppQuotaChangeIsRegistered(c *check.C) {
 	c.Assert(ok, check.Equals, true)
 	c.Assert(changeQuota, check.FitsTypeOf, &appQuotaChange{})
 }
+
+func (s *S) TestAppLockListIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	lockList, ok := manager.Commands["app-lock-list"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(lockList, check.FitsTypeOf, &appLockList{})
+}
+
+func (s *S) TestBatchIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	batchCmd, ok := manager.Commands["batch"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(batchCmd, check.FitsTypeOf, &batch{})
+}
+
+func (s *S) TestShellIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	shellCmd, ok := manager.Commands["shell"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(shellCmd, check.FitsTypeOf, &shell{})
+}
+
+func (s *S) TestCompletionIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	completionCmd, ok := manager.Commands["completion"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(completionCmd, check.FitsTypeOf, &completion{})
+}
+
+func (s *S) TestAuditLogIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	auditCmd, ok := manager.Commands["audit-log"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(auditCmd, check.FitsTypeOf, &auditLogCommand{})
+}
+
+func (s *S) TestAliasIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	alias, ok := manager.Commands["alias"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(alias, check.FitsTypeOf, &aliasCommand{})
+}
+
+func (s *S) TestClusterExportIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	export, ok := manager.Commands["cluster-export"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(export, check.FitsTypeOf, &clusterExport{})
+}
+
+func (s *S) TestClusterApplyIsRegistered(c *check.C) {
+	manager := buildManager("tsuru-admin")
+	apply, ok := manager.Commands["cluster-apply"]
+	c.Assert(ok, check.Equals, true)
+	c.Assert(apply, check.FitsTypeOf, &clusterApply{})
+}
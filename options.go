@@ -0,0 +1,399 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// globalOptions holds the global flags handled by tsuru-admin itself. They
+// must be given before the command name and are removed from the arguments
+// before they're handed to the command manager.
+type globalOptions struct {
+	dryRun     bool
+	targets    []string
+	allTargets bool
+	// auditLog is the path of the audit log file, empty when the requests
+	// aren't audited.
+	auditLog     string
+	auditMaxSize int64
+	// retries is the number of times failed idempotent requests are retried.
+	retries int
+	// verbosity is the value of the verbosity flag handled by cmd.Manager.
+	verbosity int
+	timeout   time.Duration
+	caFile    string
+	certFile  string
+	keyFile   string
+	insecure  bool
+	// errorFormat is the format of the errors reported by the commands, text
+	// or json.
+	errorFormat string
+	// recordFile and replayFile are the HAR files the session is recorded to
+	// or replayed from.
+	recordFile string
+	replayFile string
+	// forwardArgs are the arguments used when running the command against
+	// other targets, without the flags that select the targets.
+	forwardArgs []string
+}
+
+type globalFlag struct {
+	usage string
+	// set is called with the value of the flag, or with an empty string for
+	// boolean flags given without a value.
+	set    func(o *globalOptions, value string) error
+	isBool bool
+	// local flags are not forwarded when running the command against other
+	// targets.
+	local bool
+	// env is the environment variable holding the default value of the flag.
+	env string
+}
+
+// parseBool parses the value of a boolean flag, which is true when given
+// without a value.
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+var globalFlags = map[string]globalFlag{
+	"dry-run": {
+		usage:  "Print the requests that would change data in the tsuru server instead of sending them.",
+		isBool: true,
+		set: func(o *globalOptions, value string) (err error) {
+			o.dryRun, err = parseBool(value)
+			return err
+		},
+	},
+	"targets": {
+		usage: "Comma separated list of target labels to run the command against.",
+		local: true,
+		set: func(o *globalOptions, value string) error {
+			for _, label := range strings.Split(value, ",") {
+				if label = strings.TrimSpace(label); label != "" {
+					o.targets = append(o.targets, label)
+				}
+			}
+			if len(o.targets) == 0 {
+				return errors.New("at least one target is required")
+			}
+			return nil
+		},
+	},
+	"all-targets": {
+		usage:  "Run the command against all the targets.",
+		isBool: true,
+		local:  true,
+		set: func(o *globalOptions, value string) (err error) {
+			o.allTargets, err = parseBool(value)
+			return err
+		},
+	},
+	"request-retries": {
+		usage: "Number of times idempotent requests failing with network or gateway errors are retried.",
+		env:   requestRetriesEnv,
+		set: func(o *globalOptions, value string) (err error) {
+			o.retries, err = parseRetries(value)
+			return err
+		},
+	},
+	"audit-log": {
+		usage: "Append a JSON line describing each request that changes data in the tsuru server to the given file.",
+		env:   auditLogEnv,
+		set: func(o *globalOptions, value string) error {
+			o.auditLog = value
+			return nil
+		},
+	},
+	"audit-log-max-size": {
+		usage: "Size of the audit log file that triggers its rotation, in bytes or followed by K, M or G.",
+		env:   auditLogMaxSizeEnv,
+		set: func(o *globalOptions, value string) (err error) {
+			o.auditMaxSize, err = parseSize(value)
+			return err
+		},
+	},
+	"timeout": {
+		usage: "Maximum duration of each request, including the time reading the response, e.g. 30s or 5m.",
+		env:   "TSURU_ADMIN_TIMEOUT",
+		set: func(o *globalOptions, value string) (err error) {
+			o.timeout, err = time.ParseDuration(value)
+			return err
+		},
+	},
+	"ca-file": {
+		usage: "Path to a PEM file with the certificate authorities trusted for the tsuru API.",
+		env:   "TSURU_ADMIN_CA_FILE",
+		set: func(o *globalOptions, value string) error {
+			o.caFile = value
+			return nil
+		},
+	},
+	"cert": {
+		usage: "Path to a PEM file with the client certificate used for connecting to the tsuru API.",
+		env:   "TSURU_ADMIN_CERT",
+		set: func(o *globalOptions, value string) error {
+			o.certFile = value
+			return nil
+		},
+	},
+	"key": {
+		usage: "Path to a PEM file with the private key of the client certificate.",
+		env:   "TSURU_ADMIN_KEY",
+		set: func(o *globalOptions, value string) error {
+			o.keyFile = value
+			return nil
+		},
+	},
+	"insecure": {
+		usage:  "Skip the verification of the certificate of the tsuru API.",
+		isBool: true,
+		env:    "TSURU_ADMIN_INSECURE",
+		set: func(o *globalOptions, value string) (err error) {
+			o.insecure, err = parseBool(value)
+			return err
+		},
+	},
+	"record": {
+		usage: "Record the requests and responses of the session to the given HAR file, with the token redacted.",
+		local: true,
+		set: func(o *globalOptions, value string) error {
+			o.recordFile = value
+			return nil
+		},
+	},
+	"replay": {
+		usage: "Serve the responses recorded in the given HAR file instead of sending the requests.",
+		local: true,
+		set: func(o *globalOptions, value string) error {
+			o.replayFile = value
+			return nil
+		},
+	},
+	"error-format": {
+		usage: "Format of the errors written to the standard error, text or json.",
+		env:   errorFormatEnv,
+		set: func(o *globalOptions, value string) (err error) {
+			o.errorFormat, err = parseErrorFormat(value)
+			return err
+		},
+	},
+}
+
+// managerValueFlags are the global flags handled by cmd.Manager that take a
+// value, which must be skipped while looking for the command name.
+var managerValueFlags = map[string]bool{"v": true, "verbosity": true}
+
+// takesValue reports whether the global flag takes the next argument as its
+// value when given without one.
+func takesValue(name string) bool {
+	if flag, ok := globalFlags[name]; ok {
+		return !flag.isBool
+	}
+	return managerValueFlags[name]
+}
+
+// globalFlagNames returns the sorted names of the global flags, prefixed with
+// their dashes.
+func globalFlagNames() []string {
+	var names []string
+	for name := range globalFlags {
+		names = append(names, flagName(name))
+	}
+	for name := range managerValueFlags {
+		names = append(names, flagName(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseGlobalOptions extracts the global flags from the arguments preceding
+// the command name, returning the options and the remaining arguments.
+func parseGlobalOptions(args []string) (*globalOptions, []string, error) {
+	opts := &globalOptions{auditMaxSize: defaultAuditLogMaxSize, retries: defaultRequestRetries, errorFormat: "text"}
+	if err := opts.loadEnv(); err != nil {
+		return nil, nil, err
+	}
+	var remaining []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			remaining = append(remaining, args[i:]...)
+			opts.forwardArgs = append(opts.forwardArgs, args[i:]...)
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		var value string
+		hasValue := false
+		if idx := strings.Index(name, "="); idx >= 0 {
+			name, value, hasValue = name[:idx], name[idx+1:], true
+		}
+		flag, ok := globalFlags[name]
+		if !ok {
+			remaining = append(remaining, arg)
+			opts.forwardArgs = append(opts.forwardArgs, arg)
+			if managerValueFlags[name] && !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+				remaining = append(remaining, value)
+				opts.forwardArgs = append(opts.forwardArgs, value)
+			}
+			if managerValueFlags[name] {
+				opts.verbosity, _ = strconv.Atoi(value)
+			}
+			continue
+		}
+		if !flag.local {
+			opts.forwardArgs = append(opts.forwardArgs, arg)
+		}
+		if !flag.isBool && !hasValue {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag needs an argument: --%s", name)
+			}
+			i++
+			value = args[i]
+			if !flag.local {
+				opts.forwardArgs = append(opts.forwardArgs, value)
+			}
+		}
+		if err := flag.set(opts, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid value %q for flag --%s: %s", value, name, err)
+		}
+	}
+	if opts.recordFile != "" && opts.replayFile != "" {
+		return nil, nil, errors.New("the --record and --replay flags can't be used together")
+	}
+	if (opts.recordFile != "" || opts.replayFile != "") && opts.fanOut() {
+		return nil, nil, errors.New("the --record and --replay flags can't be used with --targets or --all-targets")
+	}
+	return opts, remaining, nil
+}
+
+// loadEnv sets the options from the environment variables of the flags.
+func (o *globalOptions) loadEnv() error {
+	var names []string
+	for name := range globalFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		flag := globalFlags[name]
+		if flag.env == "" {
+			continue
+		}
+		if value := os.Getenv(flag.env); value != "" {
+			if err := flag.set(o, value); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %s", value, flag.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fanOut reports whether the command must be run against other targets.
+func (o *globalOptions) fanOut() bool {
+	return o.allTargets || len(o.targets) > 0
+}
+
+// apply configures the HTTP client used by the commands according to the
+// options. Requests skipped by the dry run mode are not audited, and retried
+// requests are audited once, but recorded once per attempt.
+func (o *globalOptions) apply(client *http.Client, stdout, stderr io.Writer) error {
+	if o.timeout > 0 {
+		client.Timeout = o.timeout
+	}
+	if o.caFile != "" || o.certFile != "" || o.keyFile != "" || o.insecure {
+		tlsConfig, err := o.tlsConfig()
+		if err != nil {
+			return err
+		}
+		transport, ok := transportOf(client).(*http.Transport)
+		if !ok {
+			return errors.New("the TLS options can't be used with a custom HTTP transport")
+		}
+		if transport == http.DefaultTransport {
+			transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+		}
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	if o.replayFile != "" {
+		replay, err := newReplayTransport(o.replayFile)
+		if err != nil {
+			return err
+		}
+		setReplayTarget(replay)
+		client.Transport = replay
+	} else if o.recordFile != "" {
+		client.Transport = newRecordTransport(transportOf(client), o.recordFile)
+	}
+	client.Transport = &retryTransport{
+		transport: transportOf(client),
+		retries:   o.retries,
+		verbosity: o.verbosity,
+		out:       stdout,
+	}
+	if o.auditLog != "" {
+		client.Transport = &auditTransport{
+			transport:   transportOf(client),
+			log:         &auditLog{path: o.auditLog, maxSize: o.auditMaxSize},
+			commandLine: os.Args,
+			stderr:      stderr,
+		}
+	}
+	if o.dryRun {
+		client.Transport = &dryRunTransport{transport: transportOf(client), out: stdout}
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS configuration for connecting to the tsuru API.
+func (o *globalOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.insecure}
+	if o.caFile != "" {
+		data, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", o.caFile)
+		}
+		config.RootCAs = pool
+	}
+	if (o.certFile == "") != (o.keyFile == "") {
+		return nil, errors.New("the --cert and --key flags must be used together")
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func transportOf(client *http.Client) http.RoundTripper {
+	if client.Transport == nil {
+		return http.DefaultTransport
+	}
+	return client.Transport
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseGlobalOptions(c *check.C) {
+	opts, args, err := parseGlobalOptions([]string{"-v", "1", "--dry-run", "plan-create", "plan1", "-c", "2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.dryRun, check.Equals, true)
+	c.Assert(args, check.DeepEquals, []string{"-v", "1", "plan-create", "plan1", "-c", "2"})
+}
+
+func (s *S) TestParseGlobalOptionsOnlyBeforeCommand(c *check.C) {
+	opts, args, err := parseGlobalOptions([]string{"plan-remove", "--dry-run"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.dryRun, check.Equals, false)
+	c.Assert(args, check.DeepEquals, []string{"plan-remove", "--dry-run"})
+}
+
+func (s *S) TestParseGlobalOptionsNoArgs(c *check.C) {
+	opts, args, err := parseGlobalOptions(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.dryRun, check.Equals, false)
+	c.Assert(args, check.HasLen, 0)
+}
+
+func (s *S) TestGlobalOptionsApplyDryRun(c *check.C) {
+	var out bytes.Buffer
+	client := &http.Client{}
+	opts := globalOptions{dryRun: true}
+	err := opts.apply(client, &out, &out)
+	c.Assert(err, check.IsNil)
+	transport, ok := client.Transport.(*dryRunTransport)
+	c.Assert(ok, check.Equals, true)
+	retry, ok := transport.transport.(*retryTransport)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(retry.transport, check.Equals, http.DefaultTransport)
+	c.Assert(transport.out, check.Equals, &out)
+}
+
+func (s *S) TestParseGlobalOptionsTargets(c *check.C) {
+	opts, args, err := parseGlobalOptions([]string{"--targets", "prod-us, prod-eu", "--dry-run", "-v=1", "plan-remove", "plan1"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.fanOut(), check.Equals, true)
+	c.Assert(opts.targets, check.DeepEquals, []string{"prod-us", "prod-eu"})
+	c.Assert(args, check.DeepEquals, []string{"-v=1", "plan-remove", "plan1"})
+	c.Assert(opts.forwardArgs, check.DeepEquals, []string{"--dry-run", "-v=1", "plan-remove", "plan1"})
+}
+
+func (s *S) TestParseGlobalOptionsAllTargets(c *check.C) {
+	opts, _, err := parseGlobalOptions([]string{"--all-targets", "router-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.fanOut(), check.Equals, true)
+	c.Assert(opts.allTargets, check.Equals, true)
+	c.Assert(opts.forwardArgs, check.DeepEquals, []string{"router-list"})
+}
+
+func (s *S) TestParseGlobalOptionsMissingValue(c *check.C) {
+	_, _, err := parseGlobalOptions([]string{"--targets"})
+	c.Assert(err, check.ErrorMatches, "flag needs an argument: --targets")
+	_, _, err = parseGlobalOptions([]string{"--targets=,", "router-list"})
+	c.Assert(err, check.ErrorMatches, `invalid value "," for flag --targets: at least one target is required`)
+}
+
+func (s *S) TestParseGlobalOptionsTransport(c *check.C) {
+	opts, args, err := parseGlobalOptions([]string{"--timeout", "30s", "--ca-file", "/etc/ca.pem", "--cert=/etc/cert.pem", "--key", "/etc/key.pem", "--insecure", "plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.timeout, check.Equals, 30*time.Second)
+	c.Assert(opts.caFile, check.Equals, "/etc/ca.pem")
+	c.Assert(opts.certFile, check.Equals, "/etc/cert.pem")
+	c.Assert(opts.keyFile, check.Equals, "/etc/key.pem")
+	c.Assert(opts.insecure, check.Equals, true)
+	c.Assert(args, check.DeepEquals, []string{"plan-list"})
+	_, _, err = parseGlobalOptions([]string{"--timeout", "soon", "plan-list"})
+	c.Assert(err, check.ErrorMatches, `invalid value "soon" for flag --timeout: .*`)
+}
+
+func (s *S) TestParseGlobalOptionsTransportEnv(c *check.C) {
+	env := map[string]string{
+		"TSURU_ADMIN_TIMEOUT":  "1m",
+		"TSURU_ADMIN_CA_FILE":  "/etc/ca.pem",
+		"TSURU_ADMIN_INSECURE": "true",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	opts, _, err := parseGlobalOptions([]string{"--timeout", "10s", "plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.timeout, check.Equals, 10*time.Second)
+	c.Assert(opts.caFile, check.Equals, "/etc/ca.pem")
+	c.Assert(opts.insecure, check.Equals, true)
+	opts, _, err = parseGlobalOptions([]string{"--insecure=false", "plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.insecure, check.Equals, false)
+	os.Setenv("TSURU_ADMIN_INSECURE", "maybe")
+	_, _, err = parseGlobalOptions([]string{"plan-list"})
+	c.Assert(err, check.ErrorMatches, `invalid value "maybe" for TSURU_ADMIN_INSECURE: .*`)
+}
+
+func (s *S) TestGlobalOptionsApplyTimeout(c *check.C) {
+	client := &http.Client{}
+	opts := globalOptions{timeout: time.Minute}
+	err := opts.apply(client, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	c.Assert(client.Timeout, check.Equals, time.Minute)
+}
+
+func (s *S) TestGlobalOptionsApplyCAFile(c *check.C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	caFile := filepath.Join(c.MkDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	err := ioutil.WriteFile(caFile, cert, 0600)
+	c.Assert(err, check.IsNil)
+	client := &http.Client{Transport: &http.Transport{}}
+	_, err = client.Get(server.URL)
+	c.Assert(err, check.NotNil)
+	opts := globalOptions{caFile: caFile}
+	err = opts.apply(client, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	resp, err := client.Get(server.URL)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+}
+
+func (s *S) TestGlobalOptionsApplyInsecure(c *check.C) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := &http.Client{}
+	opts := globalOptions{insecure: true}
+	err := opts.apply(client, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	resp, err := client.Get(server.URL)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	retry, ok := client.Transport.(*retryTransport)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(retry.transport, check.Not(check.Equals), http.DefaultTransport)
+}
+
+func (s *S) TestGlobalOptionsApplyTLSErrors(c *check.C) {
+	dir := c.MkDir()
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	err := ioutil.WriteFile(invalidFile, []byte("not a certificate"), 0600)
+	c.Assert(err, check.IsNil)
+	opts := globalOptions{caFile: invalidFile}
+	err = opts.apply(&http.Client{}, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.ErrorMatches, "no certificates found in "+invalidFile)
+	opts = globalOptions{certFile: invalidFile}
+	err = opts.apply(&http.Client{}, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.ErrorMatches, "the --cert and --key flags must be used together")
+	opts = globalOptions{certFile: invalidFile, keyFile: invalidFile}
+	err = opts.apply(&http.Client{}, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.NotNil)
+	opts = globalOptions{caFile: filepath.Join(dir, "missing.pem")}
+	err = opts.apply(&http.Client{}, ioutil.Discard, ioutil.Discard)
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,171 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/tsuru/gnuflag"
+	"gopkg.in/yaml.v1"
+)
+
+const outputFormats = "table, json, yaml, csv or template=<go-template>"
+
+// outputCommand implements the -o/--output flag, shared by the commands that
+// display data. Besides the default table, data may be rendered as JSON, YAML,
+// CSV or using a Go template. The field names are always the same as the ones
+// in the underlying Go structs.
+type outputCommand struct {
+	format string
+	fs     *gnuflag.FlagSet
+}
+
+func (c *outputCommand) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("", gnuflag.ExitOnError)
+		desc := "Output format: " + outputFormats + "."
+		c.fs.StringVar(&c.format, "output", "table", desc)
+		c.fs.StringVar(&c.format, "o", "table", desc)
+	}
+	return c.fs
+}
+
+// render writes data in the chosen format, calling renderTable for the
+// default table format.
+func (c *outputCommand) render(w io.Writer, data interface{}, renderTable func() error) error {
+	switch format := c.format; {
+	case format == "" || format == "table":
+		return renderTable()
+	case format == "json":
+		encoded, err := json.MarshalIndent(plainValue(reflect.ValueOf(data)), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", encoded)
+		return err
+	case format == "yaml":
+		encoded, err := yaml.Marshal(plainValue(reflect.ValueOf(data)))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(encoded)
+		return err
+	case format == "csv":
+		return renderCSV(w, data)
+	case strings.HasPrefix(format, "template="):
+		tmpl, err := template.New("output").Parse(strings.TrimPrefix(format, "template="))
+		if err != nil {
+			return fmt.Errorf("invalid output template: %s", err)
+		}
+		return tmpl.Execute(w, data)
+	}
+	return fmt.Errorf("invalid output format %q, expected %s", c.format, outputFormats)
+}
+
+// plainValue converts value into maps, slices and scalars, using the Go field
+// names as keys for structs, regardless of their json or yaml tags.
+func plainValue(value reflect.Value) interface{} {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.CanInterface() {
+		if marshaler, ok := value.Interface().(encoding.TextMarshaler); ok {
+			text, err := marshaler.MarshalText()
+			if err == nil {
+				return string(text)
+			}
+		}
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		result := make(map[string]interface{}, value.NumField())
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath == "" {
+				result[field.Name] = plainValue(value.Field(i))
+			}
+		}
+		return result
+	case reflect.Map:
+		result := make(map[string]interface{}, value.Len())
+		for _, key := range value.MapKeys() {
+			result[fmt.Sprint(key.Interface())] = plainValue(value.MapIndex(key))
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, value.Len())
+		for i := range result {
+			result[i] = plainValue(value.Index(i))
+		}
+		return result
+	}
+	return value.Interface()
+}
+
+// renderCSV writes one line per element of data, which may be a struct or a
+// slice of structs. Nested values are encoded as JSON.
+func renderCSV(w io.Writer, data interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(data))
+	elemType := value.Type()
+	rows := []reflect.Value{value}
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		elemType = elemType.Elem()
+		if elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		rows = make([]reflect.Value, value.Len())
+		for i := range rows {
+			rows[i] = reflect.Indirect(value.Index(i))
+		}
+	}
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("csv output is not supported for %s", elemType)
+	}
+	var fields []int
+	var header []string
+	for i := 0; i < elemType.NumField(); i++ {
+		if field := elemType.Field(i); field.PkgPath == "" {
+			fields = append(fields, i)
+			header = append(header, field.Name)
+		}
+	}
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	for _, row := range rows {
+		record := make([]string, len(fields))
+		for i, field := range fields {
+			cell, err := csvCell(row.Field(field))
+			if err != nil {
+				return err
+			}
+			record[i] = cell
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvCell(value reflect.Value) (string, error) {
+	switch plain := plainValue(value).(type) {
+	case nil:
+		return "", nil
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(plain)
+		return string(encoded), err
+	default:
+		return fmt.Sprint(plain), nil
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/tsuru/tsuru/iaas"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestOutputCommandRenderTable(c *check.C) {
+	var buf bytes.Buffer
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{})
+	err := command.render(&buf, nil, func() error {
+		buf.WriteString("table")
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "table")
+}
+
+func (s *S) TestOutputCommandRenderJSON(c *check.C) {
+	var buf bytes.Buffer
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"-o", "json"})
+	routers := []router.PlanRouter{{Name: "router1", Type: "galeb"}}
+	err := command.render(&buf, routers, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `[
+  {
+    "Name": "router1",
+    "Type": "galeb"
+  }
+]
+`)
+}
+
+func (s *S) TestOutputCommandRenderJSONEmpty(c *check.C) {
+	var buf bytes.Buffer
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"--output", "json"})
+	var machines []iaas.Machine
+	err := command.render(&buf, machines, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "[]\n")
+}
+
+func (s *S) TestOutputCommandRenderYAML(c *check.C) {
+	var buf bytes.Buffer
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"-o", "yaml"})
+	templates := []iaas.Template{{Name: "tpl1", IaaSName: "ec2", Data: iaas.TemplateDataList{{Name: "region", Value: "us-east-1"}}}}
+	err := command.render(&buf, templates, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `- Data:
+  - Name: region
+    Value: us-east-1
+  IaaSName: ec2
+  Name: tpl1
+`)
+}
+
+func (s *S) TestOutputCommandRenderCSV(c *check.C) {
+	var buf bytes.Buffer
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"-o", "csv"})
+	machines := []iaas.Machine{
+		{Id: "id1", Iaas: "ec2", Address: "10.0.0.1", CreationParams: map[string]string{"region": "us-east-1"}},
+		{Id: "id2", Iaas: "ec2", Address: "10.0.0.2"},
+	}
+	err := command.render(&buf, machines, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `Id,Iaas,Status,Address,Port,CreationParams
+id1,ec2,,10.0.0.1,0,"{""region"":""us-east-1""}"
+id2,ec2,,10.0.0.2,0,{}
+`)
+}
+
+func (s *S) TestOutputCommandRenderCSVNotStruct(c *check.C) {
+	var buf bytes.Buffer
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"-o", "csv"})
+	err := command.render(&buf, []string{"a"}, nil)
+	c.Assert(err, check.ErrorMatches, "csv output is not supported for string")
+}
+
+func (s *S) TestOutputCommandRenderTemplate(c *check.C) {
+	var buf bytes.Buffer
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"-o", "template={{range .}}{{.Name}} {{.Type}}\n{{end}}"})
+	routers := []router.PlanRouter{{Name: "router1", Type: "galeb"}, {Name: "router2", Type: "hipache"}}
+	err := command.render(&buf, routers, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "router1 galeb\nrouter2 hipache\n")
+}
+
+func (s *S) TestOutputCommandRenderInvalidTemplate(c *check.C) {
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"-o", "template={{.Name"})
+	err := command.render(nil, nil, nil)
+	c.Assert(err, check.ErrorMatches, "invalid output template: .*")
+}
+
+func (s *S) TestOutputCommandRenderInvalidFormat(c *check.C) {
+	command := outputCommand{}
+	command.Flags().Parse(true, []string{"-o", "xml"})
+	err := command.render(nil, nil, func() error { return errors.New("table") })
+	c.Assert(err, check.ErrorMatches, `invalid output format "xml", expected table, json, yaml, csv or template=<go-template>`)
+}
@@ -122,12 +122,14 @@ func (c *planRemove) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type planRoutersList struct{}
+type planRoutersList struct {
+	outputCommand
+}
 
 func (c *planRoutersList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "router-list",
-		Usage:   "router-list",
+		Usage:   "router-list [-o <format>]",
 		Desc:    "List all routers available for plan creation.",
 		MinArgs: 0,
 	}
@@ -151,12 +153,14 @@ func (c *planRoutersList) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	table := cmd.NewTable()
-	table.Headers = cmd.Row([]string{"Name", "Type"})
-	table.LineSeparator = true
-	for _, router := range routers {
-		table.AddRow(cmd.Row([]string{router.Name, router.Type}))
-	}
-	context.Stdout.Write(table.Bytes())
-	return nil
+	return c.render(context.Stdout, routers, func() error {
+		table := cmd.NewTable()
+		table.Headers = cmd.Row([]string{"Name", "Type"})
+		table.LineSeparator = true
+		for _, router := range routers {
+			table.AddRow(cmd.Row([]string{router.Name, router.Type}))
+		}
+		_, err := context.Stdout.Write(table.Bytes())
+		return err
+	})
 }
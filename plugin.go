@@ -0,0 +1,192 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// pluginExit exits with the status of a plugin run by the manager.
+var pluginExit = os.Exit
+
+// pluginsDir returns the directory searched for plugins before the PATH.
+func pluginsDir() string {
+	return cmd.JoinWithUserDir(".tsuru", "admin-plugins")
+}
+
+// pluginName matches the names plugins may have, which are valid command
+// names, e.g. backup or pool-report.
+var pluginName = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+// pluginDirs returns the directories searched for plugins, in order.
+func pluginDirs() []string {
+	dirs := []string{pluginsDir()}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func isPluginFile(file os.FileInfo) bool {
+	return file.Mode().IsRegular() && file.Mode().Perm()&0111 != 0
+}
+
+// findPlugin returns the path of the plugin with the given name, or an empty
+// string when there's none. Plugins are executables named after the program
+// followed by a dash and the name of the plugin, e.g. tsuru-admin-backup,
+// found in the plugins directory or in the PATH. The first one found wins.
+func findPlugin(program, name string) string {
+	if !pluginName.MatchString(name) {
+		return ""
+	}
+	for _, dir := range pluginDirs() {
+		path := filepath.Join(dir, program+"-"+name)
+		if file, err := os.Stat(path); err == nil && isPluginFile(file) {
+			return path
+		}
+	}
+	return ""
+}
+
+// findPlugins returns the paths of all the plugins by name, as found by
+// findPlugin.
+func findPlugins(program string) map[string]string {
+	prefix := program + "-"
+	plugins := make(map[string]string)
+	for _, dir := range pluginDirs() {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name := strings.TrimPrefix(file.Name(), prefix)
+			if name == file.Name() || !pluginName.MatchString(name) {
+				continue
+			}
+			path := filepath.Join(dir, file.Name())
+			if info, err := os.Stat(path); err != nil || !isPluginFile(info) {
+				continue
+			}
+			if _, found := plugins[name]; !found {
+				plugins[name] = path
+			}
+		}
+	}
+	return plugins
+}
+
+// registerPlugins registers the plugins as commands, unless a command with
+// the same name is already registered.
+func registerPlugins(m *cmd.Manager, program string) {
+	for name, path := range findPlugins(program) {
+		if _, found := m.Commands[name]; !found {
+			m.Register(&plugin{name: name, path: path})
+		}
+	}
+}
+
+// plugin is a command implemented by an external executable. It receives
+// the arguments of the command, along with the target and the token in the
+// TSURU_TARGET and TSURU_TOKEN environment variables.
+type plugin struct {
+	name string
+	path string
+}
+
+func (p *plugin) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  p.name,
+		Usage: p.name + " [args...]",
+		Desc: fmt.Sprintf(`Runs the %s plugin.
+
+The plugin is installed at %s. The arguments are handed to it
+untouched, along with the target and the token in the TSURU_TARGET and
+TSURU_TOKEN environment variables.`, p.name, p.path),
+	}
+}
+
+func (p *plugin) Run(context *cmd.Context, client *cmd.Client) error {
+	target, err := cmd.ReadTarget()
+	if err != nil {
+		return err
+	}
+	token, err := cmd.ReadToken()
+	if err != nil {
+		return err
+	}
+	command := exec.Command(p.path, context.Args...)
+	command.Env = append(os.Environ(),
+		"TSURU_TARGET="+target,
+		"TSURU_TOKEN="+token,
+		"TSURU_PLUGIN_NAME="+p.name,
+	)
+	command.Stdin = context.Stdin
+	command.Stdout = context.Stdout
+	command.Stderr = context.Stderr
+	err = command.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return &pluginError{name: p.name, status: exitStatus(exitErr)}
+	}
+	return err
+}
+
+// pluginError is returned when a plugin exits with a non-zero status.
+type pluginError struct {
+	name   string
+	status int
+}
+
+func (e *pluginError) Error() string {
+	return fmt.Sprintf("plugin %q exited with status %d", e.name, e.status)
+}
+
+func exitStatus(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Exited() {
+		return status.ExitStatus()
+	}
+	return exitError
+}
+
+// runPlugin runs the plugin named by the first argument when there's no
+// command with this name, before the manager parses the flags, which are
+// handed untouched to the plugin. The manager exits with the status of the
+// plugin. The plugins are only searched for all at once by the help command,
+// which lists them.
+func runPlugin(m *cmd.Manager, program string, context *cmd.Context) error {
+	if len(context.Args) == 0 {
+		return cmd.ErrLookup
+	}
+	name := context.Args[0]
+	if name == "help" {
+		registerPlugins(m, program)
+		return cmd.ErrLookup
+	}
+	if _, found := m.Commands[name]; found {
+		return cmd.ErrLookup
+	}
+	path := findPlugin(program, name)
+	if path == "" {
+		return cmd.ErrLookup
+	}
+	p := &plugin{name: name, path: path}
+	context.Args = context.Args[1:]
+	err := p.Run(context, nil)
+	if pluginErr, ok := err.(*pluginError); ok {
+		pluginExit(pluginErr.status)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"gopkg.in/check.v1"
+)
+
+const envPlugin = `#!/bin/sh
+echo "$TSURU_PLUGIN_NAME $TSURU_TARGET $TSURU_TOKEN $*"
+`
+
+// setUpPlugins points HOME and PATH to temporary directories, returning a
+// function restoring them along with the plugins directory and a directory
+// in the PATH.
+func setUpPlugins(c *check.C) (restore func(), pluginsDir, pathDir string) {
+	home, pathDir := c.MkDir(), c.MkDir()
+	oldHome, oldPath := os.Getenv("HOME"), os.Getenv("PATH")
+	os.Setenv("HOME", home)
+	os.Setenv("PATH", pathDir)
+	pluginsDir = filepath.Join(home, ".tsuru", "admin-plugins")
+	err := os.MkdirAll(pluginsDir, 0755)
+	c.Assert(err, check.IsNil)
+	return func() {
+		os.Setenv("HOME", oldHome)
+		os.Setenv("PATH", oldPath)
+	}, pluginsDir, pathDir
+}
+
+func writePlugin(c *check.C, dir, name, script string, mode os.FileMode) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(script), mode)
+	c.Assert(err, check.IsNil)
+	return path
+}
+
+func (s *S) TestFindPlugins(c *check.C) {
+	restore, pluginsDir, pathDir := setUpPlugins(c)
+	defer restore()
+	backup := writePlugin(c, pluginsDir, "tsuru-admin-backup", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-backup", envPlugin, 0755)
+	report := writePlugin(c, pathDir, "tsuru-admin-report", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-notes", "notes", 0644)
+	writePlugin(c, pathDir, "tsuru-backup", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-1.2", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-Backup", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-report-", envPlugin, 0755)
+	err := os.Mkdir(filepath.Join(pathDir, "tsuru-admin-dir"), 0755)
+	c.Assert(err, check.IsNil)
+	c.Assert(findPlugins("tsuru-admin"), check.DeepEquals, map[string]string{
+		"backup": backup,
+		"report": report,
+	})
+}
+
+func (s *S) TestFindPlugin(c *check.C) {
+	restore, pluginsDir, pathDir := setUpPlugins(c)
+	defer restore()
+	backup := writePlugin(c, pluginsDir, "tsuru-admin-backup", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-backup", envPlugin, 0755)
+	report := writePlugin(c, pathDir, "tsuru-admin-pool-report", envPlugin, 0755)
+	writePlugin(c, pathDir, "tsuru-admin-notes", "notes", 0644)
+	writePlugin(c, pathDir, "tsuru-admin-1.2", envPlugin, 0755)
+	err := os.Mkdir(filepath.Join(pathDir, "tsuru-admin-dir"), 0755)
+	c.Assert(err, check.IsNil)
+	c.Assert(findPlugin("tsuru-admin", "backup"), check.Equals, backup)
+	c.Assert(findPlugin("tsuru-admin", "pool-report"), check.Equals, report)
+	c.Assert(findPlugin("tsuru-admin", "notes"), check.Equals, "")
+	c.Assert(findPlugin("tsuru-admin", "1.2"), check.Equals, "")
+	c.Assert(findPlugin("tsuru-admin", "dir"), check.Equals, "")
+	c.Assert(findPlugin("tsuru-admin", "../tsuru-admin-backup"), check.Equals, "")
+	c.Assert(findPlugin("tsuru-admin", "missing"), check.Equals, "")
+}
+
+func (s *S) TestRegisterPlugins(c *check.C) {
+	restore, pluginsDir, _ := setUpPlugins(c)
+	defer restore()
+	writePlugin(c, pluginsDir, "tsuru-admin-backup", envPlugin, 0755)
+	writePlugin(c, pluginsDir, "tsuru-admin-plan-create", envPlugin, 0755)
+	m := buildManager("tsuru-admin")
+	c.Assert(m.Commands["backup"], check.IsNil)
+	registerPlugins(m, "tsuru-admin")
+	c.Assert(m.Commands["backup"], check.DeepEquals, &plugin{name: "backup", path: filepath.Join(pluginsDir, "tsuru-admin-backup")})
+	c.Assert(m.Commands["plan-create"], check.FitsTypeOf, &planCreate{})
+}
+
+func (s *S) TestPluginInfo(c *check.C) {
+	p := &plugin{name: "backup", path: "/usr/local/bin/tsuru-admin-backup"}
+	info := p.Info()
+	c.Assert(info.Name, check.Equals, "backup")
+	c.Assert(info.Usage, check.Equals, "backup [args...]")
+	c.Assert(info.Desc, check.Matches, "(?s)Runs the backup plugin.\n\nThe plugin is installed at /usr/local/bin/tsuru-admin-backup.*")
+}
+
+func (s *S) TestPluginRun(c *check.C) {
+	restore, pluginsDir, _ := setUpPlugins(c)
+	defer restore()
+	os.Setenv("TSURU_TOKEN", "admin-token")
+	defer os.Unsetenv("TSURU_TOKEN")
+	p := &plugin{name: "backup", path: writePlugin(c, pluginsDir, "tsuru-admin-backup", envPlugin, 0755)}
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"--all", "-o", "out"}, Stdout: &stdout, Stderr: &stderr, Stdin: strings.NewReader("")}
+	err := p.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "backup http://localhost admin-token --all -o out\n")
+}
+
+func (s *S) TestPluginRunExitStatus(c *check.C) {
+	restore, pluginsDir, _ := setUpPlugins(c)
+	defer restore()
+	p := &plugin{name: "fail", path: writePlugin(c, pluginsDir, "tsuru-admin-fail", "#!/bin/sh\necho failed >&2\nexit 7\n", 0755)}
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr, Stdin: strings.NewReader("")}
+	err := p.Run(&context, nil)
+	c.Assert(err, check.DeepEquals, &pluginError{name: "fail", status: 7})
+	c.Assert(err, check.ErrorMatches, `plugin "fail" exited with status 7`)
+	c.Assert(exitCode(err), check.Equals, 7)
+	c.Assert(stderr.String(), check.Equals, "failed\n")
+}
+
+func (s *S) TestRunPlugin(c *check.C) {
+	restore, pluginsDir, _ := setUpPlugins(c)
+	defer restore()
+	writePlugin(c, pluginsDir, "tsuru-admin-fail", "#!/bin/sh\nexit 3\n", 0755)
+	writePlugin(c, pluginsDir, "tsuru-admin-backup", envPlugin, 0755)
+	status := -1
+	pluginExit = func(code int) { status = code }
+	defer func() { pluginExit = os.Exit }()
+	m := buildManager("tsuru-admin")
+	var stdout bytes.Buffer
+	context := cmd.Context{Args: []string{"backup", "--unknown-flag"}, Stdout: &stdout, Stderr: &stdout, Stdin: strings.NewReader("")}
+	err := runPlugin(m, "tsuru-admin", &context)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, -1)
+	c.Assert(stdout.String(), check.Equals, "backup http://localhost  --unknown-flag\n")
+	context.Args = []string{"fail"}
+	err = runPlugin(m, "tsuru-admin", &context)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, 3)
+	context.Args = []string{"plan-create", "small"}
+	c.Assert(runPlugin(m, "tsuru-admin", &context), check.Equals, cmd.ErrLookup)
+	context.Args = []string{"missing"}
+	c.Assert(runPlugin(m, "tsuru-admin", &context), check.Equals, cmd.ErrLookup)
+	c.Assert(m.Commands["backup"], check.IsNil)
+	context.Args = []string{"help"}
+	c.Assert(runPlugin(m, "tsuru-admin", &context), check.Equals, cmd.ErrLookup)
+	c.Assert(m.Commands["backup"], check.FitsTypeOf, &plugin{})
+	context.Args = nil
+	c.Assert(runPlugin(m, "tsuru-admin", &context), check.Equals, cmd.ErrLookup)
+}
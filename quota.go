@@ -17,18 +17,20 @@ import (
 	"github.com/tsuru/tsuru/quota"
 )
 
-type userQuotaView struct{}
+type userQuotaView struct {
+	outputCommand
+}
 
 func (*userQuotaView) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "user-quota-view",
 		MinArgs: 1,
-		Usage:   "user-quota-view <user-email>",
+		Usage:   "user-quota-view <user-email> [-o <format>]",
 		Desc:    "Displays the current usage and limit of the user.",
 	}
 }
 
-func (*userQuotaView) Run(context *cmd.Context, client *cmd.Client) error {
+func (c *userQuotaView) Run(context *cmd.Context, client *cmd.Client) error {
 	url, err := cmd.GetURL("/users/" + context.Args[0] + "/quota")
 	if err != nil {
 		return err
@@ -44,9 +46,11 @@ func (*userQuotaView) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(context.Stdout, "User: %s\n", context.Args[0])
-	fmt.Fprintf(context.Stdout, "Apps usage: %d/%d\n", quota.InUse, quota.Limit)
-	return nil
+	return c.render(context.Stdout, quota, func() error {
+		fmt.Fprintf(context.Stdout, "User: %s\n", context.Args[0])
+		fmt.Fprintf(context.Stdout, "Apps usage: %d/%d\n", quota.InUse, quota.Limit)
+		return nil
+	})
 }
 
 type userChangeQuota struct{}
@@ -84,18 +88,20 @@ func (*userChangeQuota) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type appQuotaView struct{}
+type appQuotaView struct {
+	outputCommand
+}
 
 func (*appQuotaView) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "app-quota-view",
 		MinArgs: 1,
-		Usage:   "app-quota-view <app-name>",
+		Usage:   "app-quota-view <app-name> [-o <format>]",
 		Desc:    "Displays the current usage and limit of the given app.",
 	}
 }
 
-func (*appQuotaView) Run(context *cmd.Context, client *cmd.Client) error {
+func (c *appQuotaView) Run(context *cmd.Context, client *cmd.Client) error {
 	url, err := cmd.GetURL("/apps/" + context.Args[0] + "/quota")
 	if err != nil {
 		return err
@@ -111,9 +117,11 @@ func (*appQuotaView) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(context.Stdout, "App: %s\n", context.Args[0])
-	fmt.Fprintf(context.Stdout, "Units usage: %d/%d\n", quota.InUse, quota.Limit)
-	return nil
+	return c.render(context.Stdout, quota, func() error {
+		fmt.Fprintf(context.Stdout, "App: %s\n", context.Args[0])
+		fmt.Fprintf(context.Stdout, "Units usage: %d/%d\n", quota.InUse, quota.Limit)
+		return nil
+	})
 }
 
 type appQuotaChange struct{}
@@ -39,6 +39,22 @@ Apps usage: 3/4
 	c.Assert(stdout.String(), check.Equals, expected)
 }
 
+func (s *S) TestUserQuotaViewRunCSV(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"fss@corp.globo.com"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := cmdtest.Transport{Message: `{"inuse":3,"limit":4}`, Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := userQuotaView{}
+	command.Flags().Parse(true, []string{"-o", "csv"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Limit,InUse\n4,3\n")
+}
+
 func (s *S) TestUserQuotaViewRunFailure(c *check.C) {
 	context := cmd.Context{Args: []string{"fss@corp.globo.com"}}
 	trans := cmdtest.Transport{Message: "user not found", Status: http.StatusNotFound}
@@ -157,6 +173,22 @@ Units usage: 3/4
 	c.Assert(stdout.String(), check.Equals, expected)
 }
 
+func (s *S) TestAppQuotaViewRunTemplate(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"hibria"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := cmdtest.Transport{Message: `{"inuse":3,"limit":4}`, Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := appQuotaView{}
+	command.Flags().Parse(true, []string{"-o", "template={{.InUse}} of {{.Limit}}"})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "3 of 4")
+}
+
 func (s *S) TestAppQuotaViewRunFailure(c *check.C) {
 	context := cmd.Context{Args: []string{"hybria"}}
 	trans := cmdtest.Transport{Message: "app not found", Status: http.StatusNotFound}
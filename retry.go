@@ -0,0 +1,161 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	requestRetriesEnv = "TSURU_ADMIN_REQUEST_RETRIES"
+	// defaultRequestRetries is the number of times a failed idempotent
+	// request is retried.
+	defaultRequestRetries = 3
+	maxRetryDelay         = 30 * time.Second
+)
+
+// retryDelay is the base delay between retries, doubled after each one.
+var retryDelay = time.Second
+
+// backoffDelay returns the delay before the given retry, starting at zero:
+// an exponential backoff with jitter, so clients failing at the same time
+// don't retry at the same time.
+func backoffDelay(retry int) time.Duration {
+	delay := retryDelay * time.Duration(1<<uint(retry))
+	if delay > maxRetryDelay || delay < 0 {
+		delay = maxRetryDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// retryTransport retries idempotent requests to the tsuru API that fail
+// because of network errors or gateway errors, usually caused by load
+// balancers in front of the API. It's used by all the requests made by
+// tsuru-admin.
+//
+// Requests to long-running endpoints, listed in nonRetriableRequests, are
+// never retried: a gateway timeout doesn't mean the API stopped handling
+// them, so retrying would run them again.
+type retryTransport struct {
+	transport http.RoundTripper
+	retries   int
+	verbosity int
+	out       io.Writer
+}
+
+// nonRetriableRequests are the idempotent requests that aren't retried, as
+// they stream the output of long-running operations.
+var nonRetriableRequests = []struct {
+	method string
+	path   *regexp.Regexp
+}{
+	// platform-update builds the image of the platform.
+	{method: "PUT", path: regexp.MustCompile(`/platforms/[^/]+$`)},
+}
+
+func isRetriable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "PUT", "DELETE":
+	default:
+		return false
+	}
+	for _, r := range nonRetriableRequests {
+		if req.Method == r.method && r.path.MatchString(req.URL.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+func isGatewayError(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.retries <= 0 || !isRetriable(req) {
+		return t.transport.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for retry := 0; ; retry++ {
+		attempt := *req
+		if body != nil {
+			attempt.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		resp, err := t.transport.RoundTrip(&attempt)
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case isGatewayError(resp.StatusCode):
+			reason = resp.Status
+		default:
+			return resp, nil
+		}
+		if retry >= t.retries {
+			return resp, err
+		}
+		delay := backoffDelay(retry)
+		if t.verbosity >= 1 {
+			fmt.Fprintf(t.out, "Request %s %s failed (%s), retrying in %s (%d of %d).\n", req.Method, req.URL, reason, delay, retry+1, t.retries)
+		}
+		if !waitRetry(req, delay) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// waitRetry waits for the delay before retrying the request, returning false
+// when the request is canceled, like when the timeout of the client expires.
+func waitRetry(req *http.Request, delay time.Duration) bool {
+	select {
+	case <-req.Cancel:
+		return false
+	default:
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-req.Cancel:
+		return false
+	}
+}
+
+// parseRetries parses the number of retries for failed requests.
+func parseRetries(value string) (int, error) {
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		return 0, fmt.Errorf("%q is not a valid number of retries", value)
+	}
+	return retries, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+// flakyTransport fails with a network error for the first failures requests.
+type flakyTransport struct {
+	failures int
+	bodies   []string
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+	t.bodies = append(t.bodies, string(body))
+	if len(t.bodies) <= t.failures {
+		return nil, errors.New("connection reset by peer")
+	}
+	return (&cmdtest.Transport{Message: "ok", Status: http.StatusOK}).RoundTrip(req)
+}
+
+func statusTransport(status int) cmdtest.ConditionalTransport {
+	return cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: http.StatusText(status), Status: status},
+		CondFunc:  func(*http.Request) bool { return true },
+	}
+}
+
+func (s *S) TestRetryTransportGatewayErrors(c *check.C) {
+	trans := &cmdtest.MultiConditionalTransport{ConditionalTransports: []cmdtest.ConditionalTransport{
+		statusTransport(http.StatusBadGateway),
+		statusTransport(http.StatusServiceUnavailable),
+		statusTransport(http.StatusOK),
+	}}
+	var out bytes.Buffer
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 3, verbosity: 1, out: &out}}
+	resp, err := client.Get("http://localhost/1.0/pools")
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(trans.ConditionalTransports, check.HasLen, 0)
+	c.Assert(out.String(), check.Equals,
+		"Request GET http://localhost/1.0/pools failed (502 Bad Gateway), retrying in 0s (1 of 3).\n"+
+			"Request GET http://localhost/1.0/pools failed (503 Service Unavailable), retrying in 0s (2 of 3).\n")
+}
+
+func (s *S) TestRetryTransportExhausted(c *check.C) {
+	trans := &cmdtest.MultiConditionalTransport{ConditionalTransports: []cmdtest.ConditionalTransport{
+		statusTransport(http.StatusGatewayTimeout),
+		statusTransport(http.StatusGatewayTimeout),
+		statusTransport(http.StatusGatewayTimeout),
+	}}
+	var out bytes.Buffer
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 2, out: &out}}
+	req, err := http.NewRequest("DELETE", "http://localhost/1.0/plans/small", nil)
+	c.Assert(err, check.IsNil)
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusGatewayTimeout)
+	body, _ := ioutil.ReadAll(resp.Body)
+	c.Assert(string(body), check.Equals, "Gateway Timeout")
+	c.Assert(trans.ConditionalTransports, check.HasLen, 0)
+	c.Assert(out.String(), check.Equals, "")
+}
+
+func (s *S) TestRetryTransportNetworkErrorResendsBody(c *check.C) {
+	trans := &flakyTransport{failures: 2}
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 3}}
+	req, err := http.NewRequest("PUT", "http://localhost/1.0/pools/pool1", strings.NewReader("public=true"))
+	c.Assert(err, check.IsNil)
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(trans.bodies, check.DeepEquals, []string{"public=true", "public=true", "public=true"})
+}
+
+func (s *S) TestRetryTransportNetworkErrorExhausted(c *check.C) {
+	trans := &flakyTransport{failures: 5}
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 1}}
+	_, err := client.Get("http://localhost/1.0/pools")
+	c.Assert(err, check.ErrorMatches, ".*connection reset by peer")
+	c.Assert(trans.bodies, check.HasLen, 2)
+}
+
+func (s *S) TestRetryTransportDoesNotRetryNonIdempotent(c *check.C) {
+	trans := &flakyTransport{failures: 1}
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 3}}
+	_, err := client.Post("http://localhost/1.0/plans", "application/x-www-form-urlencoded", strings.NewReader("name=small"))
+	c.Assert(err, check.ErrorMatches, ".*connection reset by peer")
+	c.Assert(trans.bodies, check.HasLen, 1)
+}
+
+func (s *S) TestRetryTransportDoesNotRetryPlatformUpdate(c *check.C) {
+	trans := &cmdtest.MultiConditionalTransport{ConditionalTransports: []cmdtest.ConditionalTransport{
+		statusTransport(http.StatusGatewayTimeout),
+		statusTransport(http.StatusOK),
+	}}
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 3}}
+	req, err := http.NewRequest("PUT", "http://localhost/1.0/platforms/python", strings.NewReader("disabled=true"))
+	c.Assert(err, check.IsNil)
+	resp, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Assert(resp.StatusCode, check.Equals, http.StatusGatewayTimeout)
+	c.Assert(trans.ConditionalTransports, check.HasLen, 1)
+}
+
+func (s *S) TestRetryTransportCanceled(c *check.C) {
+	trans := &flakyTransport{failures: 5}
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 3}}
+	req, err := http.NewRequest("GET", "http://localhost/1.0/pools", nil)
+	c.Assert(err, check.IsNil)
+	cancel := make(chan struct{})
+	close(cancel)
+	req.Cancel = cancel
+	_, err = client.Do(req)
+	c.Assert(err, check.ErrorMatches, ".*connection reset by peer")
+	c.Assert(trans.bodies, check.HasLen, 1)
+}
+
+func (s *S) TestRetryTransportDisabled(c *check.C) {
+	trans := &flakyTransport{failures: 1}
+	client := &http.Client{Transport: &retryTransport{transport: trans, retries: 0}}
+	_, err := client.Get("http://localhost/1.0/pools")
+	c.Assert(err, check.ErrorMatches, ".*connection reset by peer")
+	c.Assert(trans.bodies, check.HasLen, 1)
+}
+
+func (s *S) TestBackoffDelay(c *check.C) {
+	c.Assert(backoffDelay(2), check.Equals, time.Duration(0))
+	retryDelay = time.Second
+	defer func() { retryDelay = 0 }()
+	for retry := 0; retry < 4; retry++ {
+		delay := backoffDelay(retry)
+		max := time.Second * time.Duration(1<<uint(retry))
+		c.Assert(delay >= max/2 && delay < max, check.Equals, true, check.Commentf("retry %d: %s", retry, delay))
+	}
+	c.Assert(backoffDelay(20) <= maxRetryDelay, check.Equals, true)
+}
+
+func (s *S) TestParseRetries(c *check.C) {
+	retries, err := parseRetries("5")
+	c.Assert(err, check.IsNil)
+	c.Assert(retries, check.Equals, 5)
+	_, err = parseRetries("-1")
+	c.Assert(err, check.ErrorMatches, `"-1" is not a valid number of retries`)
+}
+
+func (s *S) TestParseGlobalOptionsRetries(c *check.C) {
+	opts, _, err := parseGlobalOptions([]string{"plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.retries, check.Equals, defaultRequestRetries)
+	os.Setenv(requestRetriesEnv, "7")
+	defer os.Unsetenv(requestRetriesEnv)
+	opts, _, err = parseGlobalOptions([]string{"-v", "1", "plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.retries, check.Equals, 7)
+	c.Assert(opts.verbosity, check.Equals, 1)
+	opts, args, err := parseGlobalOptions([]string{"--request-retries", "0", "--verbosity=2", "plan-list"})
+	c.Assert(err, check.IsNil)
+	c.Assert(opts.retries, check.Equals, 0)
+	c.Assert(opts.verbosity, check.Equals, 2)
+	c.Assert(args, check.DeepEquals, []string{"--verbosity=2", "plan-list"})
+	os.Setenv(requestRetriesEnv, "many")
+	_, _, err = parseGlobalOptions([]string{"plan-list"})
+	c.Assert(err, check.ErrorMatches, `invalid value "many" for TSURU_ADMIN_REQUEST_RETRIES: "many" is not a valid number of retries`)
+}
+
+func (s *S) TestGlobalOptionsApplyRetries(c *check.C) {
+	var out bytes.Buffer
+	client := &http.Client{}
+	opts := globalOptions{retries: 5, verbosity: 1}
+	err := opts.apply(client, &out, &out)
+	c.Assert(err, check.IsNil)
+	retry, ok := client.Transport.(*retryTransport)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(retry.retries, check.Equals, 5)
+	c.Assert(retry.verbosity, check.Equals, 1)
+	c.Assert(retry.out, check.Equals, &out)
+	c.Assert(retry.transport, check.Equals, http.DefaultTransport)
+}
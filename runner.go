@@ -0,0 +1,129 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// commandRunner runs many command lines in the same process, sharing the
+// command manager and the client.
+type commandRunner struct {
+	manager *cmd.Manager
+	client  *cmd.Client
+}
+
+// run parses the command line and runs the command, using the given context
+// for its output.
+func (r *commandRunner) run(ctx *cmd.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command name")
+	}
+	name := args[0]
+	registered, ok := r.manager.Commands[name]
+	if !ok {
+		return fmt.Errorf("%q is not a tsuru-admin command", name)
+	}
+	if r.client != nil {
+		auditCommand(r.client.HTTPClient, r.manager.Commands, args)
+	}
+	return r.runCommand(ctx, name, freshCommand(registered), args[1:])
+}
+
+// runCommand parses the arguments with the flags of the command and runs it.
+func (r *commandRunner) runCommand(ctx *cmd.Context, name string, command cmd.Command, args []string) error {
+	fs := gnuflag.NewFlagSet(name, gnuflag.ContinueOnError)
+	if flagged, ok := command.(cmd.FlaggedCommand); ok {
+		fs = flagged.Flags()
+		fs.Init(name, gnuflag.ContinueOnError)
+	}
+	fs.SetOutput(ctx.Stderr)
+	if err := fs.Parse(true, args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	info := command.Info()
+	if len(args) < info.MinArgs || (info.MaxArgs > 0 && len(args) > info.MaxArgs) {
+		return fmt.Errorf("wrong number of arguments, usage: %s", info.Usage)
+	}
+	ctx.Args = args
+	return command.Run(ctx, r.client)
+}
+
+// freshCopier is implemented by the commands holding dependencies set when
+// they're registered, returning a copy with the same dependencies and no
+// parsed flags.
+type freshCopier interface {
+	freshCopy() cmd.Command
+}
+
+// freshCommand returns a copy of the registered command, so flag values
+// parsed for a command line don't leak into the next ones. Commands with
+// flags are copied from their zero value, as the flag set built by a previous
+// call to Flags (by help, for example) is bound to the registered command.
+func freshCommand(command cmd.Command) cmd.Command {
+	if deprecated, ok := command.(*cmd.DeprecatedCommand); ok {
+		command = deprecated.Command
+	}
+	if copier, ok := command.(freshCopier); ok {
+		return copier.freshCopy()
+	}
+	if _, ok := command.(cmd.FlaggedCommand); !ok {
+		return command
+	}
+	value := reflect.ValueOf(command)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return command
+	}
+	return reflect.New(value.Elem().Type()).Interface().(cmd.Command)
+}
+
+// splitCommandLine splits a command line into arguments, handling single and
+// double quotes and backslash escapes the way a shell would.
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	var current []rune
+	var quote rune
+	inArg, escaped := false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			current = append(current, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current = append(current, r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, string(current))
+				current, inArg = nil, false
+			}
+		default:
+			current = append(current, r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", strings.TrimSpace(line))
+	}
+	if inArg {
+		args = append(args, string(current))
+	}
+	return args, nil
+}
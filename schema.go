@@ -0,0 +1,244 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sajari/fuzzy"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/iaas"
+)
+
+type paramValidator func(value string) error
+
+// iaasSchema describes the parameters read by an IaaS provider when creating
+// machines. A nil validator means any value is accepted.
+type iaasSchema struct {
+	caseInsensitive bool
+	params          map[string]paramValidator
+}
+
+// commonTemplateParams are handled by tsuru itself, regardless of the IaaS,
+// and also end up as node metadata.
+var commonTemplateParams = map[string]bool{"iaas": true, "pool": true, "template": true}
+
+var iaasSchemas = map[string]*iaasSchema{
+	"ec2":          ec2Schema(),
+	"cloudstack":   cloudstackSchema(),
+	"digitalocean": digitalOceanSchema(),
+}
+
+func validateInt(value string) error {
+	_, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", value)
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", value)
+	}
+	return nil
+}
+
+func validateJSONObject(value string) error {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &obj); err != nil {
+		return fmt.Errorf("%q is not a JSON object", value)
+	}
+	return nil
+}
+
+func validateJSONList(value string) error {
+	var list []map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &list); err != nil {
+		return fmt.Errorf("%q is not a JSON list of objects", value)
+	}
+	return nil
+}
+
+// ec2Schema mirrors the way the ec2 IaaS fills ec2.RunInstancesInput: each
+// parameter is matched case insensitively against the fields of the struct,
+// with a few aliases and fields that can't be set.
+func ec2Schema() *iaasSchema {
+	forbiddenFields := map[string]bool{
+		"maxcount": true, "mincount": true, "dryrun": true, "monitoring": true,
+	}
+	aliases := map[string]string{
+		"image":         "imageid",
+		"type":          "instancetype",
+		"securitygroup": "securitygroups",
+		"ebs-optimized": "ebsoptimized",
+	}
+	params := map[string]paramValidator{
+		"region":             nil,
+		"endpoint":           nil,
+		"tags":               nil,
+		"network-index":      validateInt,
+		"monitoring-enabled": validateBool,
+	}
+	refType := reflect.TypeOf(ec2.RunInstancesInput{})
+	for i := 0; i < refType.NumField(); i++ {
+		field := refType.Field(i)
+		name := strings.ToLower(field.Name)
+		if field.PkgPath != "" || forbiddenFields[name] {
+			continue
+		}
+		var validator paramValidator
+		switch field.Type.Kind() {
+		case reflect.Ptr:
+			switch field.Type.Elem().Kind() {
+			case reflect.Int64:
+				validator = validateInt
+			case reflect.Bool:
+				validator = validateBool
+			case reflect.Struct:
+				validator = validateJSONObject
+			}
+		case reflect.Slice:
+			if field.Type.Elem().Elem().Kind() == reflect.Struct {
+				validator = validateJSONList
+			}
+		}
+		params[name] = validator
+	}
+	for alias, name := range aliases {
+		params[alias] = params[name]
+	}
+	return &iaasSchema{caseInsensitive: true, params: params}
+}
+
+// cloudstackSchema lists the parameters of cloudstack's deployVirtualMachine
+// command, which receives all the parameters given to the IaaS.
+func cloudstackSchema() *iaasSchema {
+	params := map[string]paramValidator{
+		"rootdisksize": validateInt,
+		"size":         validateInt,
+		"startvm":      validateBool,
+		"displayvm":    validateBool,
+	}
+	for _, name := range []string{
+		"serviceofferingid", "templateid", "zoneid", "networkids", "account",
+		"affinitygroupids", "affinitygroupnames", "customid", "deploymentplanner",
+		"details", "diskofferingid", "displayname", "domainid", "group", "hostid",
+		"hypervisor", "ip6address", "ipaddress", "iptonetworklist", "keyboard",
+		"keypair", "name", "projectid", "securitygroupids", "securitygroupnames",
+		"tags",
+	} {
+		params[name] = nil
+	}
+	return &iaasSchema{params: params}
+}
+
+func digitalOceanSchema() *iaasSchema {
+	return &iaasSchema{params: map[string]paramValidator{
+		"name":               nil,
+		"region":             nil,
+		"size":               nil,
+		"image":              nil,
+		"ssh-keys":           nil,
+		"private-networking": validateBool,
+	}}
+}
+
+// schemaForIaaS returns the schema for the given IaaS name. Custom IaaSes are
+// usually named after their provider (e.g. "ec2-east"), so the name is also
+// matched by its prefix. It returns nil if the provider can't be identified.
+func schemaForIaaS(name string) *iaasSchema {
+	name = strings.ToLower(name)
+	if schema, ok := iaasSchemas[name]; ok {
+		return schema
+	}
+	for provider, schema := range iaasSchemas {
+		if strings.HasPrefix(name, provider+"-") || strings.HasPrefix(name, provider+"_") {
+			return schema
+		}
+	}
+	return nil
+}
+
+func (s *iaasSchema) names() []string {
+	names := make([]string, 0, len(s.params))
+	for name := range s.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *iaasSchema) suggest(name string) string {
+	var best string
+	bestDistance := 3
+	for _, candidate := range s.names() {
+		if distance := fuzzy.Levenshtein(&candidate, &name); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// validateTemplateParams checks the template parameters against the schema of
+// the IaaS, returning a description of each problem found. Parameters with
+// empty values are ignored, as they're used for removing parameters.
+func validateTemplateParams(iaasName string, data iaas.TemplateDataList) []string {
+	schema := schemaForIaaS(iaasName)
+	if schema == nil {
+		return nil
+	}
+	var problems []string
+	for _, param := range data {
+		if param.Value == "" {
+			continue
+		}
+		name := param.Name
+		if schema.caseInsensitive {
+			name = strings.ToLower(name)
+		}
+		validator, ok := schema.params[name]
+		if !ok && commonTemplateParams[name] {
+			continue
+		}
+		if !ok {
+			problem := fmt.Sprintf("unknown parameter %q for IaaS %q", param.Name, iaasName)
+			if suggestion := schema.suggest(name); suggestion != "" {
+				problem += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			problems = append(problems, problem)
+			continue
+		}
+		if validator != nil {
+			if err := validator(param.Value); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid value for parameter %q: %s", param.Name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkTemplateParams validates the template parameters, writing warnings to
+// the context's stderr, or failing in strict mode.
+func checkTemplateParams(context *cmd.Context, iaasName string, data iaas.TemplateDataList, strict bool) error {
+	problems := validateTemplateParams(iaasName, data)
+	if len(problems) == 0 {
+		return nil
+	}
+	if strict {
+		return fmt.Errorf("invalid template parameters:\n  %s", strings.Join(problems, "\n  "))
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(context.Stderr, "WARNING: %s\n", problem)
+	}
+	return nil
+}
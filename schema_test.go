@@ -0,0 +1,66 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/tsuru/tsuru/iaas"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestSchemaForIaaS(c *check.C) {
+	c.Assert(schemaForIaaS("ec2"), check.Equals, iaasSchemas["ec2"])
+	c.Assert(schemaForIaaS("EC2-east"), check.Equals, iaasSchemas["ec2"])
+	c.Assert(schemaForIaaS("cloudstack_prod"), check.Equals, iaasSchemas["cloudstack"])
+	c.Assert(schemaForIaaS("digitalocean"), check.Equals, iaasSchemas["digitalocean"])
+	c.Assert(schemaForIaaS("ec2east"), check.IsNil)
+	c.Assert(schemaForIaaS("my-iaas"), check.IsNil)
+}
+
+func (s *S) TestValidateTemplateParamsEC2(c *check.C) {
+	problems := validateTemplateParams("ec2", iaas.TemplateDataList{
+		{Name: "image", Value: "ami-123"},
+		{Name: "InstanceType", Value: "m1.small"},
+		{Name: "securityGroup", Value: "sg1,sg2"},
+		{Name: "ebsoptimized", Value: "true"},
+		{Name: "placement", Value: `{"AvailabilityZone": "us-east-1a"}`},
+		{Name: "blockdevicemappings", Value: `[{"DeviceName": "/dev/sda1"}]`},
+		{Name: "network-index", Value: "1"},
+		{Name: "pool", Value: "pool1"},
+		{Name: "zone", Value: ""},
+	})
+	c.Assert(problems, check.IsNil)
+	problems = validateTemplateParams("ec2", iaas.TemplateDataList{
+		{Name: "imagid", Value: "ami-123"},
+		{Name: "ebs-optimized", Value: "yes"},
+		{Name: "placement", Value: "us-east-1a"},
+		{Name: "maxcount", Value: "2"},
+	})
+	c.Assert(problems, check.DeepEquals, []string{
+		`unknown parameter "imagid" for IaaS "ec2", did you mean "imageid"?`,
+		`invalid value for parameter "ebs-optimized": "yes" is not a boolean`,
+		`invalid value for parameter "placement": "us-east-1a" is not a JSON object`,
+		`unknown parameter "maxcount" for IaaS "ec2"`,
+	})
+}
+
+func (s *S) TestValidateTemplateParamsCloudstack(c *check.C) {
+	problems := validateTemplateParams("cloudstack", iaas.TemplateDataList{
+		{Name: "templateid", Value: "abc"},
+		{Name: "zoneid", Value: "def"},
+		{Name: "rootdisksize", Value: "big"},
+		{Name: "ZoneId", Value: "def"},
+	})
+	c.Assert(problems, check.DeepEquals, []string{
+		`invalid value for parameter "rootdisksize": "big" is not an integer`,
+		`unknown parameter "ZoneId" for IaaS "cloudstack", did you mean "zoneid"?`,
+	})
+}
+
+func (s *S) TestValidateTemplateParamsUnknownIaaS(c *check.C) {
+	problems := validateTemplateParams("my-iaas", iaas.TemplateDataList{
+		{Name: "whatever", Value: "abc"},
+	})
+	c.Assert(problems, check.IsNil)
+}
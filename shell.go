@@ -0,0 +1,306 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	shellPrompt     = "tsuru-admin> "
+	shellHistoryMax = 100
+)
+
+type shell struct {
+	manager     *cmd.Manager
+	historyFile string
+	fs          *gnuflag.FlagSet
+}
+
+func (c *shell) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "shell",
+		Usage:   "shell [--history-file <path>]",
+		MinArgs: 0,
+		Desc: `Starts an interactive shell for running tsuru-admin commands.
+
+The target and the token are loaded once, when the shell starts. Command
+names, flags and the names of apps, pools, plans, machine templates and
+machines are completed with the TAB key. The commands are stored in a
+history file, available through the arrow keys in the next sessions. Use
+"exit" or Ctrl+D for leaving the shell.`,
+	}
+}
+
+func (c *shell) freshCopy() cmd.Command {
+	return &shell{manager: c.manager}
+}
+
+func (c *shell) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("shell", gnuflag.ExitOnError)
+		path := cmd.JoinWithUserDir(".tsuru", "admin_history")
+		c.fs.StringVar(&c.historyFile, "history-file", path, "Path to the file storing the history of commands.")
+	}
+	return c.fs
+}
+
+// lineReader reads the command lines typed in the shell.
+type lineReader interface {
+	readLine() (string, error)
+	close()
+}
+
+func (c *shell) Run(context *cmd.Context, client *cmd.Client) error {
+	session := newSession()
+	defer session.restore()
+	history := readHistory(c.historyFile)
+	completer := &completer{
+		manager: c.manager,
+		fetch: func(kind string) ([]string, error) {
+			return fetchNames(client, kind)
+		},
+	}
+	var reader lineReader
+	if fd, ok := terminalFd(context.Stdin); ok {
+		reader = newTerminalReader(fd, context, history, completer)
+	} else {
+		reader = &plainReader{reader: bufio.NewReader(context.Stdin), out: context.Stdout}
+	}
+	defer reader.close()
+	runner := commandRunner{manager: c.manager, client: client}
+	for {
+		line, err := reader.readLine()
+		if err == io.EOF {
+			fmt.Fprintln(context.Stdout)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		appendHistory(c.historyFile, line)
+		args, err := splitCommandLine(line)
+		if err == nil && args[0] == "shell" {
+			err = fmt.Errorf("already running a shell")
+		}
+		if err == nil {
+			lineContext := *context
+			err = runner.run(&lineContext, args)
+			switch args[0] {
+			case "login", "logout", "target-set":
+				session.load()
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(context.Stderr, "Error: %s\n", strings.TrimSpace(err.Error()))
+		}
+	}
+}
+
+// session keeps the current target and token in the environment, so they
+// aren't read from disk by every request made in the shell.
+type session struct {
+	// target and token hold the values originally set in the environment.
+	target string
+	token  string
+}
+
+func newSession() *session {
+	s := &session{target: os.Getenv("TSURU_TARGET"), token: os.Getenv("TSURU_TOKEN")}
+	s.load()
+	return s
+}
+
+func (s *session) load() {
+	s.restore()
+	if target, err := cmd.ReadTarget(); err == nil {
+		os.Setenv("TSURU_TARGET", target)
+	}
+	if token, err := cmd.ReadToken(); err == nil && token != "" {
+		os.Setenv("TSURU_TOKEN", token)
+	}
+}
+
+func (s *session) restore() {
+	setEnv("TSURU_TARGET", s.target)
+	setEnv("TSURU_TOKEN", s.token)
+}
+
+func setEnv(name, value string) {
+	if value == "" {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, value)
+	}
+}
+
+func readHistory(path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > shellHistoryMax {
+		lines = lines[len(lines)-shellHistoryMax:]
+	}
+	return lines
+}
+
+func appendHistory(path, line string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+func terminalFd(stdin io.Reader) (int, bool) {
+	desc, ok := stdin.(interface {
+		Fd() uintptr
+	})
+	if !ok {
+		return 0, false
+	}
+	fd := int(desc.Fd())
+	return fd, terminal.IsTerminal(fd)
+}
+
+type plainReader struct {
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+func (r *plainReader) readLine() (string, error) {
+	fmt.Fprint(r.out, shellPrompt)
+	line, err := r.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		return line, nil
+	}
+	return line, err
+}
+
+func (r *plainReader) close() {}
+
+// terminalReader reads lines with the terminal in raw mode, allowing line
+// editing, history and completion. The terminal is restored while commands
+// run, so their output is displayed normally.
+type terminalReader struct {
+	fd        int
+	stdin     io.Reader
+	term      *terminal.Terminal
+	out       *switchWriter
+	completer *completer
+}
+
+// switchWriter allows discarding the output of the terminal while the history
+// is loaded.
+type switchWriter struct {
+	w io.Writer
+}
+
+func (w *switchWriter) Write(data []byte) (int, error) {
+	return w.w.Write(data)
+}
+
+func newTerminalReader(fd int, context *cmd.Context, history []string, completer *completer) *terminalReader {
+	// The terminal doesn't allow adding entries to its history, so previous
+	// lines are fed to it as if they were typed before the user input.
+	var input string
+	for _, line := range history {
+		input += line + "\r"
+	}
+	out := &switchWriter{w: ioutil.Discard}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{io.MultiReader(strings.NewReader(input), context.Stdin), out}
+	term := terminal.NewTerminal(rw, shellPrompt)
+	for range history {
+		term.ReadLine()
+	}
+	out.w = context.Stdout
+	if width, height, err := terminal.GetSize(fd); err == nil && width > 0 {
+		term.SetSize(width, height)
+	}
+	r := &terminalReader{fd: fd, stdin: context.Stdin, term: term, out: out, completer: completer}
+	term.AutoCompleteCallback = r.complete
+	return r
+}
+
+func (r *terminalReader) readLine() (string, error) {
+	state, err := terminal.MakeRaw(r.fd)
+	if err != nil {
+		return "", err
+	}
+	defer terminal.Restore(r.fd, state)
+	line, err := r.term.ReadLine()
+	// An empty read flushes the output held by the pager of the command
+	// manager, so the typed line is displayed before the command output.
+	r.stdin.Read(nil)
+	return line, err
+}
+
+func (r *terminalReader) close() {}
+
+func (r *terminalReader) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	newLine, newPos, options := completeLine(r.completer, line, pos)
+	if len(options) > 1 {
+		fmt.Fprintf(r.term, "%s\n", strings.Join(options, "  "))
+	}
+	return newLine, newPos, true
+}
+
+// completeLine completes the word before pos, returning the new line, the new
+// position and the candidates found.
+func completeLine(c *completer, line string, pos int) (string, int, []string) {
+	before, after := line[:pos], line[pos:]
+	start := strings.LastIndexAny(before, " \t") + 1
+	word := before[start:]
+	args, err := splitCommandLine(before[:start])
+	if err != nil {
+		return line, pos, nil
+	}
+	options := c.candidates(args, word)
+	if len(options) == 0 {
+		return line, pos, nil
+	}
+	completion := commonPrefix(options)
+	if len(options) == 1 {
+		completion += " "
+	}
+	newBefore := before[:start] + completion
+	return newBefore + after, len(newBefore), options
+}
+
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/cmd/cmdtest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestShellRun(c *check.C) {
+	historyFile := filepath.Join(c.MkDir(), "history")
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("plan-remove small\n\nunknown\nplan-remove 'x\nshell\nexit\nplan-remove large\n"),
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return strings.HasSuffix(req.URL.Path, "/plans/small") && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := shell{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{"--history-file", historyFile})
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "tsuru-admin> Plan successfully removed!\n"+strings.Repeat("tsuru-admin> ", 5))
+	c.Assert(stderr.String(), check.Equals, `Error: "unknown" is not a tsuru-admin command
+Error: unterminated quote or escape in "plan-remove 'x"
+Error: already running a shell
+`)
+	data, err := ioutil.ReadFile(historyFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "plan-remove small\nunknown\nplan-remove 'x\nshell\n")
+	c.Assert(readHistory(historyFile), check.DeepEquals, []string{"plan-remove small", "unknown", "plan-remove 'x", "shell"})
+}
+
+func (s *S) TestShellRunEOF(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader(""),
+	}
+	command := shell{manager: buildManager("tsuru-admin")}
+	command.Flags().Parse(true, []string{"--history-file", filepath.Join(c.MkDir(), "history")})
+	err := command.Run(&context, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "tsuru-admin> \n")
+}
+
+func (s *S) TestReadHistoryLimit(c *check.C) {
+	historyFile := filepath.Join(c.MkDir(), "history")
+	c.Assert(readHistory(historyFile), check.HasLen, 0)
+	for i := 0; i < shellHistoryMax+10; i++ {
+		appendHistory(historyFile, "router-list")
+	}
+	appendHistory(historyFile, "plan-remove small")
+	history := readHistory(historyFile)
+	c.Assert(history, check.HasLen, shellHistoryMax)
+	c.Assert(history[shellHistoryMax-1], check.Equals, "plan-remove small")
+}
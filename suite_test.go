@@ -24,6 +24,7 @@ func (s *S) SetUpSuite(c *check.C) {
 	var stdout, stderr bytes.Buffer
 	manager = cmd.NewManager("glb", version, header, &stdout, &stderr, os.Stdin, nil)
 	os.Setenv("TSURU_TARGET", "http://localhost")
+	retryDelay = 0
 }
 
 func (s *S) TearDownSuite(c *check.C) {
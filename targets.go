@@ -0,0 +1,198 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+type target struct {
+	label string
+	url   string
+}
+
+// readTargets reads the targets file used by target-add and target-set,
+// returning the targets in the order they were added.
+func readTargets() ([]target, error) {
+	data, err := ioutil.ReadFile(cmd.JoinWithUserDir(".tsuru", "targets"))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(cmd.JoinWithUserDir(".tsuru_targets"))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var targets []target
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) == 2 {
+			targets = append(targets, target{label: parts[0], url: parts[1]})
+		}
+	}
+	return targets, nil
+}
+
+// selectTargets returns the targets with the given labels, or all the targets
+// when labels is empty.
+func selectTargets(labels []string) ([]target, error) {
+	targets, err := readTargets()
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("no targets found, please use %q to add them", "target-add")
+		}
+		return targets, nil
+	}
+	selected := make([]target, len(labels))
+	for i, label := range labels {
+		var found bool
+		for _, t := range targets {
+			if t.label == label {
+				selected[i], found = t, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("target %q not found", label)
+		}
+	}
+	return selected, nil
+}
+
+// token returns the token used for the target, which is read from the
+// TSURU_TOKEN_<LABEL> environment variable or the ~/.tsuru/token.d/<label>
+// file. The token of the current target may also be used.
+func (t target) token() (string, error) {
+	if token := os.Getenv(t.tokenEnv()); token != "" {
+		return token, nil
+	}
+	data, err := ioutil.ReadFile(cmd.JoinWithUserDir(".tsuru", "token.d", t.label))
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	if current, _ := cmd.ReadTarget(); current != "" && current == t.url {
+		return cmd.ReadToken()
+	}
+	return "", fmt.Errorf("no token found for target %q, please set %s or write it to ~/.tsuru/token.d/%s", t.label, t.tokenEnv(), t.label)
+}
+
+func (t target) tokenEnv() string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(t.label))
+	return "TSURU_TOKEN_" + name
+}
+
+// targetRunner runs the command line against a single target, writing its
+// output to stdout and stderr.
+type targetRunner func(t target, token string, args []string, stdout, stderr io.Writer) error
+
+// runExecutable runs tsuru-admin itself against the target, using the
+// environment variables honored by the command manager for selecting the
+// target and the token.
+func runExecutable(t target, token string, args []string, stdout, stderr io.Writer) error {
+	executable, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	command := exec.Command(executable, args...)
+	command.Env = append(os.Environ(), "TSURU_TARGET="+t.url, "TSURU_TOKEN="+token)
+	command.Stdin = os.Stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+	return command.Run()
+}
+
+// runOnTargets runs the command line against each target, prefixing every
+// output line with the target label, and prints a summary at the end. It
+// returns the exit status for tsuru-admin.
+func runOnTargets(targets []target, args []string, stdout, stderr io.Writer, run targetRunner) int {
+	var mu sync.Mutex
+	results := make([]error, len(targets))
+	for i, t := range targets {
+		prefix := "[" + t.label + "] "
+		out := &prefixWriter{w: stdout, prefix: prefix, mu: &mu}
+		errOut := &prefixWriter{w: stderr, prefix: prefix, mu: &mu}
+		token, err := t.token()
+		if err == nil {
+			err = run(t, token, args, out, errOut)
+		} else {
+			fmt.Fprintf(errOut, "Error: %s\n", err)
+		}
+		out.Flush()
+		errOut.Flush()
+		results[i] = err
+	}
+	status := 0
+	fmt.Fprintln(stdout, "\nSummary:")
+	for i, t := range targets {
+		if results[i] != nil {
+			status = 1
+			fmt.Fprintf(stdout, "  %s: failed (%s)\n", t.label, results[i])
+		} else {
+			fmt.Fprintf(stdout, "  %s: ok\n", t.label)
+		}
+	}
+	return status
+}
+
+// prefixWriter writes the prefix at the beginning of each line. Partial
+// lines, such as confirmation prompts, are written right away.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	mu      *sync.Mutex
+	midLine bool
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var buf bytes.Buffer
+	for rest := data; len(rest) > 0; {
+		if !p.midLine {
+			buf.WriteString(p.prefix)
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			buf.Write(rest)
+			p.midLine = true
+			break
+		}
+		buf.Write(rest[:i+1])
+		rest = rest[i+1:]
+		p.midLine = false
+	}
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flush terminates the last line, if it was left incomplete.
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.midLine {
+		p.w.Write([]byte("\n"))
+		p.midLine = false
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 tsuru-admin authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/check.v1"
+)
+
+func setUpHome(c *check.C, targets string) string {
+	home := c.MkDir()
+	err := os.MkdirAll(filepath.Join(home, ".tsuru", "token.d"), 0700)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(home, ".tsuru", "targets"), []byte(targets), 0600)
+	c.Assert(err, check.IsNil)
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	return oldHome
+}
+
+func (s *S) TestSelectTargets(c *check.C) {
+	oldHome := setUpHome(c, "prod-us\thttps://us.tsuru.example.com\nprod-eu\thttps://eu.tsuru.example.com\n")
+	defer os.Setenv("HOME", oldHome)
+	targets, err := selectTargets(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(targets, check.DeepEquals, []target{
+		{label: "prod-us", url: "https://us.tsuru.example.com"},
+		{label: "prod-eu", url: "https://eu.tsuru.example.com"},
+	})
+	targets, err = selectTargets([]string{"prod-eu"})
+	c.Assert(err, check.IsNil)
+	c.Assert(targets, check.DeepEquals, []target{{label: "prod-eu", url: "https://eu.tsuru.example.com"}})
+	_, err = selectTargets([]string{"prod-br"})
+	c.Assert(err, check.ErrorMatches, `target "prod-br" not found`)
+}
+
+func (s *S) TestSelectTargetsNoTargets(c *check.C) {
+	oldHome := setUpHome(c, "")
+	defer os.Setenv("HOME", oldHome)
+	_, err := selectTargets(nil)
+	c.Assert(err, check.ErrorMatches, `no targets found, please use "target-add" to add them`)
+}
+
+func (s *S) TestTargetToken(c *check.C) {
+	oldHome := setUpHome(c, "")
+	defer os.Setenv("HOME", oldHome)
+	home := os.Getenv("HOME")
+	err := ioutil.WriteFile(filepath.Join(home, ".tsuru", "token.d", "prod-us"), []byte("us-token\n"), 0600)
+	c.Assert(err, check.IsNil)
+	token, err := target{label: "prod-us", url: "https://us.tsuru.example.com"}.token()
+	c.Assert(err, check.IsNil)
+	c.Assert(token, check.Equals, "us-token")
+	os.Setenv("TSURU_TOKEN_PROD_EU", "eu-token")
+	defer os.Unsetenv("TSURU_TOKEN_PROD_EU")
+	token, err = target{label: "prod-eu", url: "https://eu.tsuru.example.com"}.token()
+	c.Assert(err, check.IsNil)
+	c.Assert(token, check.Equals, "eu-token")
+	_, err = target{label: "prod-br", url: "https://br.tsuru.example.com"}.token()
+	c.Assert(err, check.ErrorMatches, `no token found for target "prod-br", please set TSURU_TOKEN_PROD_BR or write it to ~/.tsuru/token.d/prod-br`)
+}
+
+func (s *S) TestRunOnTargets(c *check.C) {
+	os.Setenv("TSURU_TOKEN_PROD_US", "us-token")
+	os.Setenv("TSURU_TOKEN_PROD_EU", "eu-token")
+	defer os.Unsetenv("TSURU_TOKEN_PROD_US")
+	defer os.Unsetenv("TSURU_TOKEN_PROD_EU")
+	targets := []target{
+		{label: "prod-us", url: "https://us.tsuru.example.com"},
+		{label: "prod-eu", url: "https://eu.tsuru.example.com"},
+	}
+	var stdout, stderr bytes.Buffer
+	var calls []string
+	run := func(t target, token string, args []string, out, errOut io.Writer) error {
+		calls = append(calls, fmt.Sprintf("%s %s %v", t.url, token, args))
+		fmt.Fprintf(out, "Plan successfully removed!\nsecond line")
+		if t.label == "prod-eu" {
+			fmt.Fprintln(errOut, "Error: plan not found")
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+	status := runOnTargets(targets, []string{"plan-remove", "plan1"}, &stdout, &stderr, run)
+	c.Assert(status, check.Equals, 1)
+	c.Assert(calls, check.DeepEquals, []string{
+		"https://us.tsuru.example.com us-token [plan-remove plan1]",
+		"https://eu.tsuru.example.com eu-token [plan-remove plan1]",
+	})
+	c.Assert(stdout.String(), check.Equals, `[prod-us] Plan successfully removed!
+[prod-us] second line
+[prod-eu] Plan successfully removed!
+[prod-eu] second line
+
+Summary:
+  prod-us: ok
+  prod-eu: failed (exit status 1)
+`)
+	c.Assert(stderr.String(), check.Equals, "[prod-eu] Error: plan not found\n")
+}
+
+func (s *S) TestPrefixWriter(c *check.C) {
+	var buf bytes.Buffer
+	w := &prefixWriter{w: &buf, prefix: "[t] ", mu: &sync.Mutex{}}
+	fmt.Fprint(w, "Are you sure? (y/n) ")
+	fmt.Fprint(w, "Abort.\nline 1\nline")
+	fmt.Fprint(w, " 2\n")
+	w.Flush()
+	c.Assert(buf.String(), check.Equals, "[t] Are you sure? (y/n) Abort.\n[t] line 1\n[t] line 2\n")
+}